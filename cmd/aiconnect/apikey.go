@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/auth"
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+const apiKeyUsage = `Uso: aiconnect apikey <comando> [opzioni]
+
+Comandi:
+  create   emette una nuova API key (il token viene mostrato una sola volta)
+  list     elenca le API key
+  revoke   revoca una API key: aiconnect apikey revoke <id>
+
+Eseguire "aiconnect apikey <comando> -h" per le opzioni.
+`
+
+// stringList raccoglie un flag ripetibile
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// runAPIKeyCommand gestisce "aiconnect apikey" e restituisce l'exit code
+func runAPIKeyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, apiKeyUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("apikey "+args[0], flag.ContinueOnError)
+	configPath := fs.String("config", "", "Percorso file configurazione YAML")
+	file := fs.String("file", "", "File delle API key (default: api_keys.file della configurazione)")
+
+	var (
+		owner, description, expires string
+		service                     bool
+		scopes                      stringList
+	)
+	switch args[0] {
+	case "create":
+		fs.StringVar(&owner, "owner", "", "Username AD o nome dell'identità di servizio")
+		fs.BoolVar(&service, "service", false, "La chiave appartiene a un'identità di servizio e non a un utente AD")
+		fs.StringVar(&description, "description", "", "Descrizione della chiave")
+		fs.StringVar(&expires, "expires", "", "Validità, es. 720h o 90d (default: nessuna scadenza)")
+		fs.Var(&scopes, "scope", "Backend e modelli consentiti, es. ollama o vllm:llama3* (ripetibile)")
+	case "list", "revoke":
+	default:
+		fmt.Fprintf(os.Stderr, "Comando apikey sconosciuto: %s\n\n%s", args[0], apiKeyUsage)
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	store, err := openAPIKeyStore(*configPath, *file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Errore:", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "create":
+		ttl, err := parseTTL(expires)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Errore:", err)
+			return 2
+		}
+		ownerType := auth.OwnerUser
+		if service {
+			ownerType = auth.OwnerService
+		}
+		token, key, err := store.Create(owner, ownerType, description, scopes, ttl)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Errore:", err)
+			return 1
+		}
+		fmt.Printf("API key %s creata per %s\n", key.ID, key.Owner)
+		if key.ExpiresAt != nil {
+			fmt.Printf("Scadenza: %s\n", key.ExpiresAt.Format(time.RFC3339))
+		}
+		fmt.Println("Token (non verrà mostrato di nuovo):")
+		fmt.Println(token)
+
+	case "list":
+		keys, err := store.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Errore:", err)
+			return 1
+		}
+		printAPIKeys(keys)
+
+	case "revoke":
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Uso: aiconnect apikey revoke <id>")
+			return 2
+		}
+		if err := store.Revoke(fs.Arg(0)); err != nil {
+			fmt.Fprintln(os.Stderr, "Errore:", err)
+			return 1
+		}
+		fmt.Printf("API key %s revocata\n", fs.Arg(0))
+	}
+	return 0
+}
+
+// openAPIKeyStore apre il file indicato o quello della configurazione
+func openAPIKeyStore(configPath, file string) (*auth.KeyStore, error) {
+	if file == "" {
+		cfg, err := config.Load(resolveConfigPath(configPath))
+		if err != nil {
+			return nil, err
+		}
+		file = cfg.APIKeys.File
+	}
+	return auth.OpenKeyStore(file)
+}
+
+// parseTTL interpreta una durata Go con in più il suffisso "d" per i giorni
+func parseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("scadenza non valida: %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("scadenza non valida: %q", s)
+	}
+	return d, nil
+}
+
+func printAPIKeys(keys []auth.APIKey) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITOLARE\tTIPO\tSCOPE\tCREATA\tSCADENZA\tSTATO\tDESCRIZIONE")
+	now := time.Now()
+	for _, k := range keys {
+		scopes := "*"
+		if len(k.Scopes) > 0 {
+			scopes = strings.Join(k.Scopes, ",")
+		}
+		expiry := "-"
+		if k.ExpiresAt != nil {
+			expiry = k.ExpiresAt.Format("2006-01-02")
+		}
+		status := "attiva"
+		switch {
+		case k.RevokedAt != nil:
+			status = "revocata"
+		case k.Expired(now):
+			status = "scaduta"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			k.ID, k.Owner, k.OwnerType, scopes, k.CreatedAt.Format("2006-01-02"), expiry, status, k.Description)
+	}
+	tw.Flush()
+}
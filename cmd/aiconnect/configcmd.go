@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+const configUsage = `Uso: aiconnect config <comando> [opzioni]
+
+Comandi:
+  dump   mostra la configurazione effettiva e l'origine di ogni valore
+         (file, variabili d'ambiente, file di segreti o default)
+
+Eseguire "aiconnect config <comando> -h" per le opzioni.
+`
+
+// runConfigCommand gestisce "aiconnect config" e restituisce l'exit code
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "dump" {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config dump", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Percorso file configurazione YAML")
+	showSecrets := fs.Bool("show-secrets", false, "Mostra in chiaro password e chiavi")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, sources, err := config.LoadWithSources(resolveConfigPath(*configPath))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Errore:", err)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CAMPO\tVALORE\tORIGINE")
+	for _, f := range config.Fields(cfg, sources) {
+		value := f.Value
+		if f.Secret && value != "" && !*showSecrets {
+			value = "********"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Path, value, f.Source)
+	}
+	tw.Flush()
+
+	if err := config.Validate(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "Configurazione non valida:", err)
+		return 1
+	}
+	return 0
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fzanti/aiconnect/internal/auth"
@@ -17,10 +22,17 @@ import (
 	"github.com/fzanti/aiconnect/internal/registry"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/acme/autocert"
 )
 
 func main() {
+	// Sottocomandi di gestione
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		os.Exit(runAPIKeyCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
 	configPathFlag := flag.String("config", "", "Percorso file configurazione YAML")
 	initFlag := flag.Bool("init", false, "Avvia il wizard di configurazione e termina")
 	forceFlag := flag.Bool("force", false, "Forza sovrascrittura config nel wizard")
@@ -29,14 +41,7 @@ func main() {
 	// Setup logger
 	log := logrus.New()
 
-	// Get config path from environment or default
-	configPath := strings.TrimSpace(*configPathFlag)
-	if configPath == "" {
-		configPath = os.Getenv("AICONNECT_CONFIG")
-	}
-	if configPath == "" {
-		configPath = "/etc/aiconnect/config.yaml"
-	}
+	configPath := resolveConfigPath(*configPathFlag)
 
 	if *initFlag {
 		_, err := config.RunWizard(config.WizardOptions{ConfigPath: configPath, Force: *forceFlag})
@@ -71,27 +76,8 @@ func main() {
 		}
 	}
 
-}
-
-func isInteractiveStdin() bool {
-	fi, err := os.Stdin.Stat()
-	if err != nil {
-		return false
-	}
-	return (fi.Mode() & os.ModeCharDevice) != 0
-
 	// Configure logger based on config
-	level, err := logrus.ParseLevel(cfg.Logging.Level)
-	if err != nil {
-		level = logrus.InfoLevel
-	}
-	log.SetLevel(level)
-
-	if cfg.Logging.Format == "json" {
-		log.SetFormatter(&logrus.JSONFormatter{})
-	} else {
-		log.SetFormatter(&logrus.TextFormatter{})
-	}
+	configureLogger(log, cfg)
 
 	log.Info("AIConnect in avvio...")
 
@@ -159,33 +145,66 @@ func isInteractiveStdin() bool {
 	// Initialize metrics manager
 	metricsManager := metrics.NewManager()
 
-	// Initialize Ollama load balancer
+	// Initialize load balancers: strategia e circuit breaker sono applicati
+	// insieme al resto della configurazione
 	ollamaLB := loadbalancer.NewOllamaLoadBalancer(
 		cfg.Backends.OllamaServers,
 		cfg.Monitoring.HealthCheckInterval,
 		log,
 	)
-	ollamaLB.Start()
-
-	// Initialize vLLM load balancer
+	ollamaLB.SetCircuitObserver(circuitObserver(metricsManager, "ollama"))
 	vllmLB := loadbalancer.NewVLLMLoadBalancer(
 		cfg.Backends.VLLMServers,
 		cfg.Monitoring.HealthCheckInterval,
 		log,
 	)
+	vllmLB.SetCircuitObserver(circuitObserver(metricsManager, "vllm"))
+
+	// Connessioni LDAP riusate ed esiti delle autenticazioni in cache
+	ldapAuth := auth.NewLDAPAuthenticator(cfg, log, metricsManager)
+	defer ldapAuth.Close()
+
+	// Proxy, load balancer e autenticatori (API key, token OIDC, Kerberos,
+	// certificati client) seguono la configurazione anche dopo un reload
+	svc := &services{
+		log:      log,
+		proxy:    proxy.NewHandler(cfg, log, ollamaLB, vllmLB, metricsManager),
+		ollamaLB: ollamaLB,
+		vllmLB:   vllmLB,
+		ldap:     ldapAuth,
+		authn:    auth.Authenticators{LDAP: ldapAuth, Metrics: metricsManager},
+	}
+	if err := svc.apply(cfg); err != nil {
+		log.WithError(err).Fatal("Configurazione non applicabile")
+	}
+	ollamaLB.Start()
 	vllmLB.Start()
 
-	// Create proxy handler
-	proxyHandler := proxy.NewHandler(cfg, log, ollamaLB, vllmLB, metricsManager)
+	// Merge discovered nodes into the load balancers alongside static servers
+	if cfg.MDNS.DiscoveryEnabled {
+		loadbalancer.WatchRegistry(nodeRegistry, registry.NodeTypeOllama, ollamaLB, log)
+		loadbalancer.WatchRegistry(nodeRegistry, registry.NodeTypeVLLM, vllmLB, log)
+	}
 
-	// Wrap with authentication middleware
-	authHandler := auth.LDAPAuthMiddleware(cfg, log)(proxyHandler)
+	// Reload su SIGHUP o modifica del file: una configurazione non valida
+	// viene scartata e resta in uso quella corrente
+	watcher := config.NewWatcher(configPath, config.DefaultWatchInterval, svc.apply, func(err error) {
+		if err != nil {
+			log.WithError(err).Error("Reload della configurazione rifiutato, resta in uso la precedente")
+			return
+		}
+		log.WithField("config", configPath).Info("Configurazione ricaricata")
+	})
+	watcher.Start()
+	defer watcher.Stop()
 
 	// Setup HTTP mux
 	mux := http.NewServeMux()
-	mux.Handle("/ollama/", authHandler)
-	mux.Handle("/vllm/", authHandler)
-	mux.Handle("/openai/", authHandler)
+	mux.Handle("/ollama/", &svc.handler)
+	mux.Handle("/vllm/", &svc.handler)
+	mux.Handle("/openai/", &svc.handler)
+	mux.Handle("/v1/", &svc.handler)
+	mux.Handle("/api/", &svc.handler)
 
 	// Health check endpoint (unauthenticated)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -199,42 +218,139 @@ func isInteractiveStdin() bool {
 	mux.HandleFunc("/internal/nodes", mdns.NodesHandler(nodeRegistry, localHost, cfg.HTTPS.Port))
 
 	// Start metrics server on separate port
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Monitoring.MetricsPort),
+		Handler: metricsMux,
+	}
 	go func() {
-		metricsMux := http.NewServeMux()
-		metricsMux.Handle("/metrics", promhttp.Handler())
-
-		metricsAddr := fmt.Sprintf(":%d", cfg.Monitoring.MetricsPort)
-		log.WithField("address", metricsAddr).Info("Server metriche in ascolto")
+		log.WithField("address", metricsServer.Addr).Info("Server metriche in ascolto")
 
-		if err := http.ListenAndServe(metricsAddr, metricsMux); err != nil {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.WithError(err).Fatal("Errore server metriche")
 		}
 	}()
 
-	// Configure autocert manager for LetsEncrypt
-	certManager := autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(cfg.HTTPS.Domain),
-		Cache:      autocert.DirCache(cfg.HTTPS.CacheDir),
+	// Certificati via ACME o da file; nessun TLS in modalità plain
+	tlsConfig, certManager, err := serverTLSConfig(cfg, log)
+	if err != nil {
+		log.WithError(err).Fatal("Impossibile configurare TLS")
 	}
 
 	// Configure HTTPS server
+	var handler http.Handler = mux
+	if hsts := config.HSTSHeader(cfg); hsts != "" {
+		handler = hstsHandler(hsts, mux)
+	}
 	httpsAddr := fmt.Sprintf(":%d", cfg.HTTPS.Port)
 	server := &http.Server{
 		Addr:      httpsAddr,
-		Handler:   mux,
-		TLSConfig: certManager.TLSConfig(),
+		Handler:   handler,
+		TLSConfig: tlsConfig,
 	}
 
-	log.WithFields(logrus.Fields{
+	// Listener HTTP per le challenge ACME HTTP-01 e il redirect su HTTPS
+	var httpServer *http.Server
+	if cfg.HTTPS.HTTPListener.Enabled {
+		httpServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.HTTPS.HTTPListener.Port),
+			Handler: httpListenerHandler(cfg, certManager),
+		}
+		go func() {
+			log.WithField("address", httpServer.Addr).Info("Listener HTTP in ascolto (challenge ACME e redirect HTTPS)")
+
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.WithError(err).Fatal("Errore listener HTTP")
+			}
+		}()
+	}
+
+	// Certificato client richiesto ma non obbligatorio: chi non lo presenta
+	// usa gli altri metodi, quelli presentati devono essere firmati dalla CA
+	if cfg.MTLS.Enabled {
+		clientCAs, err := config.LoadCertPool(cfg.MTLS.CAFile)
+		if err != nil {
+			log.WithError(err).Fatal("Impossibile caricare mtls.ca_file")
+		}
+		server.TLSConfig.ClientCAs = clientCAs
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	serverFields := logrus.Fields{
 		"address": httpsAddr,
-		"domain":  cfg.HTTPS.Domain,
-	}).Info("Server HTTPS in avvio")
+		"mode":    cfg.HTTPS.Mode,
+		"domains": strings.Join(config.ServerNames(cfg), ","),
+	}
+	if tlsConfig == nil {
+		log.WithFields(serverFields).Info("Server HTTP in avvio, TLS terminato dall'ingress")
+	} else {
+		log.WithFields(serverFields).Info("Server HTTPS in avvio")
+	}
 
 	// Start HTTPS server
-	if err := server.ListenAndServeTLS("", ""); err != nil {
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	serverErr := make(chan error, 1)
+	go func() {
+		if tlsConfig == nil {
+			serverErr <- server.ListenAndServe()
+			return
+		}
+		serverErr <- server.ListenAndServeTLS("", "")
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.WithError(err).Fatal("Errore server HTTPS")
+	case <-ctx.Done():
+	}
+
+	// Arresto ordinato: un secondo segnale termina subito il processo
+	stopSignals()
+	log.Info("Arresto in corso")
+
+	// L'annuncio mDNS è ritirato per primo, così i client smettono di
+	// scegliere questo nodo mentre le richieste in corso terminano
+	if mdnsAdvertiser != nil {
+		mdnsAdvertiser.Stop()
+	}
+	watcher.Stop()
+	if httpServer != nil {
+		stopAuxServer(httpServer, "HTTP", log)
 	}
+
+	drainTimeout := time.Duration(svc.currentConfig().Shutdown.DrainTimeout) * time.Second
+	drainServer(server, drainTimeout, &svc.handler, log)
+
+	ollamaLB.Stop()
+	vllmLB.Stop()
+	// Il server delle metriche è chiuso per ultimo, così i valori registrati
+	// durante l'attesa delle richieste restano disponibili allo scrape
+	stopAuxServer(metricsServer, "metriche", log)
+
+	log.Info("AIConnect arrestato")
+}
+
+// resolveConfigPath restituisce il percorso della configurazione: flag,
+// variabile AICONNECT_CONFIG o default
+func resolveConfigPath(flagValue string) string {
+	configPath := strings.TrimSpace(flagValue)
+	if configPath == "" {
+		configPath = os.Getenv("AICONNECT_CONFIG")
+	}
+	if configPath == "" {
+		configPath = "/etc/aiconnect/config.yaml"
+	}
+	return configPath
+}
+
+func isInteractiveStdin() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return (fi.Mode() & os.ModeCharDevice) != 0
 }
 
 // getLocalHost returns the local host IP address, consistent with mDNS advertisement
@@ -245,3 +361,11 @@ func getLocalHost() string {
 	}
 	return "127.0.0.1"
 }
+
+// circuitObserver esporta le transizioni dei circuit breaker di un pool come metriche
+func circuitObserver(mm *metrics.Manager, backend string) loadbalancer.CircuitObserver {
+	return func(server string, _, to loadbalancer.CircuitState) {
+		mm.RecordCircuitState(backend, server, to.String())
+		mm.SetBackendHealth(backend, server, to != loadbalancer.CircuitOpen)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fzanti/aiconnect/internal/auth"
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/fzanti/aiconnect/internal/loadbalancer"
+	"github.com/fzanti/aiconnect/internal/proxy"
+	"github.com/sirupsen/logrus"
+)
+
+// swapHandler inoltra ogni richiesta all'handler corrente, sostituito
+// atomicamente a ogni reload: le richieste in corso, compresi gli stream,
+// terminano con l'handler con cui sono iniziate
+type swapHandler struct {
+	current  atomic.Pointer[http.Handler]
+	inFlight atomic.Int64 // richieste in corso, attese all'arresto
+}
+
+func (h *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
+	(*h.current.Load()).ServeHTTP(w, r)
+}
+
+func (h *swapHandler) set(handler http.Handler) {
+	h.current.Store(&handler)
+}
+
+// services raccoglie i componenti che seguono la configurazione e li allinea
+// a ogni reload senza interrompere le richieste in corso
+type services struct {
+	log      *logrus.Logger
+	proxy    *proxy.Handler
+	ollamaLB loadbalancer.Balancer
+	vllmLB   loadbalancer.Balancer
+	ldap     *auth.LDAPAuthenticator
+	handler  swapHandler // middleware di autenticazione e proxy
+
+	mutex sync.Mutex
+	cfg   *config.Config // configurazione in uso, nil prima della prima apply
+	authn auth.Authenticators
+}
+
+// currentConfig restituisce la configurazione in uso
+func (s *services) currentConfig() *config.Config {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cfg
+}
+
+// reloadStep allinea un componente alla configurazione next partendo da prev
+// (nil alla prima apply). I passi possono fallire e vengono annullati
+// rieseguendoli con le configurazioni invertite.
+type reloadStep func(prev, next *config.Config) error
+
+// apply applica una configurazione già validata. Se un componente la rifiuta
+// quelli già aggiornati tornano alla configurazione precedente, che resta in uso.
+func (s *services) apply(cfg *config.Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prev := s.cfg
+	steps := []reloadStep{
+		s.reloadBalancers,
+		s.reloadAPIKeys,
+		s.reloadOIDC,
+		s.reloadKerberos,
+		s.reloadCerts,
+	}
+	for i, step := range steps {
+		if err := step(prev, cfg); err != nil {
+			if prev != nil {
+				for j := i - 1; j >= 0; j-- {
+					if rbErr := steps[j](cfg, prev); rbErr != nil {
+						s.log.WithError(rbErr).Error("Ripristino della configurazione precedente incompleto")
+					}
+				}
+			}
+			return err
+		}
+	}
+
+	// Da qui in poi nessun passo può fallire
+	if prev != nil {
+		if !reflect.DeepEqual(prev.AD, cfg.AD) {
+			s.ldap.Reload(cfg)
+		}
+		warnRestartRequired(s.log, prev, cfg)
+	}
+	s.ollamaLB.SetStaticServers(cfg.Backends.OllamaServers)
+	s.vllmLB.SetStaticServers(cfg.Backends.VLLMServers)
+	s.reloadLimiter(cfg)
+	if prev != nil {
+		s.proxy.Reload(cfg)
+	}
+	s.handler.set(auth.AuthMiddleware(cfg, s.log, s.authn)(s.proxy))
+	configureLogger(s.log, cfg)
+
+	s.cfg = cfg
+	return nil
+}
+
+// reloadBalancers aggiorna strategia e circuit breaker dei pool
+func (s *services) reloadBalancers(_, next *config.Config) error {
+	if err := s.ollamaLB.Configure(next.LoadBalancing.Ollama); err != nil {
+		return fmt.Errorf("load balancer Ollama: %w", err)
+	}
+	if err := s.vllmLB.Configure(next.LoadBalancing.VLLM); err != nil {
+		return fmt.Errorf("load balancer vLLM: %w", err)
+	}
+	return nil
+}
+
+// reloadAPIKeys apre il file delle API key se abilitate o spostate; le
+// modifiche al contenuto sono già rilette dal KeyStore
+func (s *services) reloadAPIKeys(prev, next *config.Config) error {
+	switch {
+	case !next.APIKeys.Enabled:
+		s.authn.APIKeys = nil
+	case s.authn.APIKeys == nil || prev == nil || prev.APIKeys.File != next.APIKeys.File:
+		keys, err := auth.OpenKeyStore(next.APIKeys.File)
+		if err != nil {
+			return fmt.Errorf("api_keys: %w", err)
+		}
+		s.authn.APIKeys = keys
+		s.log.WithField("file", next.APIKeys.File).Info("Autenticazione con API key abilitata")
+	}
+	return nil
+}
+
+// reloadOIDC crea o riconfigura l'autenticatore OIDC; la cache delle chiavi
+// viene scartata solo se cambiano le impostazioni OIDC o i gruppi ammessi
+func (s *services) reloadOIDC(prev, next *config.Config) error {
+	switch {
+	case !next.OIDC.Enabled:
+		s.authn.OIDC = nil
+	case s.authn.OIDC == nil:
+		oidcAuth, err := auth.NewOIDCAuthenticator(next, s.log)
+		if err != nil {
+			return fmt.Errorf("oidc: %w", err)
+		}
+		s.authn.OIDC = oidcAuth
+		s.log.WithField("issuer", next.OIDC.Issuer).Info("Autenticazione con token JWT abilitata")
+	case !reflect.DeepEqual(prev.OIDC, next.OIDC) || !reflect.DeepEqual(prev.AD.AllowedGroups, next.AD.AllowedGroups):
+		if err := s.authn.OIDC.Reload(next); err != nil {
+			return fmt.Errorf("oidc: %w", err)
+		}
+	}
+	return nil
+}
+
+// reloadKerberos crea l'autenticatore Kerberos o rilegge il keytab, che può
+// essere stato ruotato anche senza modifiche alla configurazione
+func (s *services) reloadKerberos(_, next *config.Config) error {
+	switch {
+	case !next.Kerberos.Enabled:
+		s.authn.Kerberos = nil
+	case s.authn.Kerberos == nil:
+		kerberosAuth, err := auth.NewKerberosAuthenticator(next, s.log, s.ldap)
+		if err != nil {
+			return fmt.Errorf("kerberos: %w", err)
+		}
+		s.authn.Kerberos = kerberosAuth
+		s.log.WithField("realm", next.Kerberos.Realm).Info("Autenticazione Kerberos (SPNEGO) abilitata")
+	default:
+		if err := s.authn.Kerberos.Reload(next); err != nil {
+			return fmt.Errorf("kerberos: %w", err)
+		}
+	}
+	return nil
+}
+
+// reloadCerts aggiorna le regole di associazione dei certificati client; la
+// CA è quella caricata dal server TLS all'avvio
+func (s *services) reloadCerts(_, next *config.Config) error {
+	switch {
+	case !next.MTLS.Enabled:
+		s.authn.Certs = nil
+	case s.authn.Certs == nil:
+		certAuth, err := auth.NewCertAuthenticator(next)
+		if err != nil {
+			return fmt.Errorf("mtls: %w", err)
+		}
+		s.authn.Certs = certAuth
+		s.log.WithField("identities", len(next.MTLS.Identities)).Info("Autenticazione con certificato client abilitata")
+	default:
+		if err := s.authn.Certs.Reload(next); err != nil {
+			return fmt.Errorf("mtls: %w", err)
+		}
+	}
+	return nil
+}
+
+// reloadLimiter applica le nuove soglie mantenendo i blocchi in corso
+func (s *services) reloadLimiter(cfg *config.Config) {
+	if s.authn.Limiter == nil {
+		s.authn.Limiter = auth.NewLoginLimiter(cfg)
+		return
+	}
+	if cfg.LoginProtection.Enabled != nil && !*cfg.LoginProtection.Enabled {
+		s.authn.Limiter = nil
+		return
+	}
+	s.authn.Limiter.Reload(cfg)
+}
+
+// warnRestartRequired segnala le modifiche che hanno effetto solo al riavvio:
+// listener, certificati del server, mDNS e intervallo degli health check
+func warnRestartRequired(log *logrus.Logger, prev, next *config.Config) {
+	var changed []string
+	if !reflect.DeepEqual(prev.HTTPS, next.HTTPS) {
+		changed = append(changed, "https")
+	}
+	if prev.Monitoring.MetricsPort != next.Monitoring.MetricsPort {
+		changed = append(changed, "monitoring.metrics_port")
+	}
+	if prev.Monitoring.HealthCheckInterval != next.Monitoring.HealthCheckInterval {
+		changed = append(changed, "monitoring.health_check_interval")
+	}
+	if !reflect.DeepEqual(prev.MDNS, next.MDNS) {
+		changed = append(changed, "mdns")
+	}
+	if prev.MTLS.Enabled != next.MTLS.Enabled {
+		changed = append(changed, "mtls.enabled")
+	}
+	if prev.MTLS.CAFile != next.MTLS.CAFile {
+		changed = append(changed, "mtls.ca_file")
+	}
+	if len(changed) > 0 {
+		log.WithField("settings", strings.Join(changed, ", ")).Warn("Modifiche applicate solo al prossimo riavvio")
+	}
+}
+
+// configureLogger imposta livello e formato dei log
+func configureLogger(log *logrus.Logger, cfg *config.Config) {
+	level, err := logrus.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	log.SetLevel(level)
+
+	if cfg.Logging.Format == "json" {
+		log.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		log.SetFormatter(&logrus.TextFormatter{})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// auxShutdownTimeout limita l'arresto dei server ausiliari (metriche e
+// listener HTTP), che servono solo richieste brevi
+const auxShutdownTimeout = 5 * time.Second
+
+// drainServer smette di accettare connessioni e attende le richieste in corso
+// fino a timeout; allo scadere chiude le connessioni rimaste
+func drainServer(server *http.Server, timeout time.Duration, handler *swapHandler, log *logrus.Logger) {
+	log.WithFields(logrus.Fields{
+		"in_flight": handler.inFlight.Load(),
+		"timeout":   timeout,
+	}).Info("Attesa delle richieste in corso")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithError(err).WithField("interrupted", handler.inFlight.Load()).Warn("Tempo di attesa scaduto, richieste in corso interrotte")
+		server.Close()
+		return
+	}
+	log.Info("Richieste in corso completate")
+}
+
+// stopAuxServer arresta un server ausiliario attendendo brevemente le
+// richieste in corso
+func stopAuxServer(server *http.Server, name string, log *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), auxShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithError(err).Warn("Arresto del server " + name + " non completato")
+		server.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serverTLSConfig costruisce la configurazione TLS del server secondo
+// https.mode; restituisce nil in modalità plain e il manager ACME solo in
+// modalità acme
+func serverTLSConfig(cfg *config.Config, log *logrus.Logger) (*tls.Config, *autocert.Manager, error) {
+	switch cfg.HTTPS.Mode {
+	case config.HTTPSModePlain:
+		return nil, nil, nil
+
+	case config.HTTPSModeStatic:
+		certs, err := config.LoadCertFile(cfg.HTTPS.CertFile, cfg.HTTPS.KeyFile, func(err error) {
+			if err != nil {
+				log.WithError(err).Error("Certificato HTTPS non valido, resta in uso il precedente")
+				return
+			}
+			log.WithField("cert_file", cfg.HTTPS.CertFile).Info("Certificato HTTPS ricaricato")
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}, nil, nil
+	}
+
+	manager, err := acmeManager(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return manager.TLSConfig(), manager, nil
+}
+
+// acmeManager configura autocert per i nomi del servizio, con Let's Encrypt o
+// con la directory ACME privata indicata in https.acme
+func acmeManager(cfg *config.Config) (*autocert.Manager, error) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.ServerNames(cfg)...),
+		Cache:      autocert.DirCache(cfg.HTTPS.CacheDir),
+		Email:      cfg.HTTPS.ACME.Email,
+	}
+
+	acmeCfg := cfg.HTTPS.ACME
+	if acmeCfg.DirectoryURL != "" || acmeCfg.CAFile != "" {
+		client := &acme.Client{DirectoryURL: acmeCfg.DirectoryURL}
+		if acmeCfg.CAFile != "" {
+			pool, err := config.LoadCertPool(acmeCfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			client.HTTPClient = &http.Client{Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			}}
+		}
+		manager.Client = client
+	}
+
+	if acmeCfg.EABKeyID != "" {
+		key, err := config.ParseEABKey(acmeCfg.EABHMACKey)
+		if err != nil {
+			return nil, err
+		}
+		manager.ExternalAccountBinding = &acme.ExternalAccountBinding{KID: acmeCfg.EABKeyID, Key: key}
+	}
+	return manager, nil
+}
+
+// httpListenerHandler gestisce il listener HTTP: le challenge ACME HTTP-01
+// sono servite dal manager (se presente), il resto è reindirizzato su HTTPS.
+// Con il listener attivo autocert prova HTTP-01 oltre a TLS-ALPN-01, che non
+// funziona dietro i load balancer che terminano ALPN.
+func httpListenerHandler(cfg *config.Config, manager *autocert.Manager) http.Handler {
+	redirect := redirectHandler(cfg.HTTPS.Port)
+	if manager == nil {
+		return redirect
+	}
+	return manager.HTTPHandler(redirect)
+}
+
+// redirectHandler risponde con un redirect permanente allo stesso URL in
+// HTTPS; 308 conserva metodo e body delle richieste API
+func redirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		// IPv6 senza porta ("[::1]"): le parentesi vengono riaggiunte sotto
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "Host header required", http.StatusBadRequest)
+			return
+		}
+		switch {
+		case httpsPort != 443:
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// hstsHandler aggiunge l'header Strict-Transport-Security alle risposte
+func hstsHandler(value string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsPort int
+		host      string
+		want      string
+	}{
+		{"default port", 443, "ai.example.com", "https://ai.example.com/v1/models?x=1"},
+		{"HTTP port dropped", 443, "ai.example.com:80", "https://ai.example.com/v1/models?x=1"},
+		{"custom HTTPS port", 8443, "ai.example.com:8080", "https://ai.example.com:8443/v1/models?x=1"},
+		{"IPv6 with port", 443, "[::1]:80", "https://[::1]/v1/models?x=1"},
+		{"IPv6 without port", 443, "[::1]", "https://[::1]/v1/models?x=1"},
+		{"IPv6 custom HTTPS port", 8443, "[::1]", "https://[::1]:8443/v1/models?x=1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/v1/models?x=1", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		redirectHandler(tt.httpsPort).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: expected 308, got %d", tt.name, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyPrefix identifica i token emessi da AIConnect nell'header Bearer
+const APIKeyPrefix = "aic_"
+
+// Tipi di titolare di una API key
+const (
+	OwnerUser    = "user"    // utente AD
+	OwnerService = "service" // identità di servizio (CI, applicazioni)
+)
+
+// keyStoreRecheck è l'intervallo minimo tra due controlli di modifica del file
+const keyStoreRecheck = 5 * time.Second
+
+// Errori di autenticazione con API key
+var (
+	ErrInvalidAPIKey = errors.New("API key non valida")
+	ErrAPIKeyExpired = errors.New("API key scaduta")
+	ErrAPIKeyRevoked = errors.New("API key revocata")
+	ErrAPIKeyUnknown = errors.New("API key inesistente")
+)
+
+// APIKey è una chiave personale o di servizio. Il token in chiaro non viene mai
+// salvato: su disco resta solo il suo hash SHA-256 (i token sono casuali a 256
+// bit, un hash lento non aggiungerebbe sicurezza).
+type APIKey struct {
+	ID          string     `json:"id"`
+	Owner       string     `json:"owner"`      // username AD o nome dell'identità di servizio
+	OwnerType   string     `json:"owner_type"` // user o service
+	Description string     `json:"description,omitempty"`
+	Scopes      Scopes     `json:"scopes,omitempty"`
+	Hash        string     `json:"hash"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Expired indica se la chiave è scaduta all'istante indicato
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Scopes limita una chiave a backend e modelli. Ogni scope ha la forma
+// "backend" oppure "backend:pattern", con backend ollama, vllm, openai o "*" e
+// pattern glob sul nome del modello (es. "vllm:llama3*", "*:gpt-4*").
+// Nessuno scope equivale ad accesso completo.
+type Scopes []string
+
+// Allows indica se gli scope consentono il modello sul backend. Con un pattern
+// di modello sono consentite solo le richieste che indicano un modello.
+func (s Scopes) Allows(backend, model string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, scope := range s {
+		b, pattern, hasPattern := strings.Cut(scope, ":")
+		if b != "*" && b != backend {
+			continue
+		}
+		if !hasPattern {
+			return true
+		}
+		if matched, _ := path.Match(pattern, model); matched && model != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateScopes verifica la sintassi degli scope
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		b, pattern, hasPattern := strings.Cut(scope, ":")
+		switch b {
+		case "*", "ollama", "vllm", "openai":
+		default:
+			return fmt.Errorf("scope %q: backend non valido (ollama, vllm, openai o *)", scope)
+		}
+		if !hasPattern {
+			continue
+		}
+		if pattern == "" {
+			return fmt.Errorf("scope %q: pattern modello vuoto", scope)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("scope %q: pattern modello non valido: %w", scope, err)
+		}
+	}
+	return nil
+}
+
+type scopesKey struct{}
+
+// WithScopes associa al contesto gli scope della credenziale usata
+func WithScopes(ctx context.Context, scopes Scopes) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// ScopesFromContext restituisce gli scope della credenziale, se limitata
+func ScopesFromContext(ctx context.Context) (Scopes, bool) {
+	scopes, ok := ctx.Value(scopesKey{}).(Scopes)
+	return scopes, ok
+}
+
+// KeyStore gestisce le API key salvate in un file JSON. Le modifiche fatte da
+// un altro processo (es. il comando "aiconnect apikey") vengono rilette
+// automaticamente.
+type KeyStore struct {
+	path string
+
+	mutex     sync.RWMutex
+	keys      map[string]*APIKey
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// keyFile è il formato del file delle API key
+type keyFile struct {
+	Keys []*APIKey `json:"keys"`
+}
+
+// OpenKeyStore apre il file delle API key; un file assente equivale a nessuna chiave
+func OpenKeyStore(file string) (*KeyStore, error) {
+	s := &KeyStore{path: file, keys: make(map[string]*APIKey)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load rilegge il file. Va chiamato con il mutex acquisito in scrittura.
+func (s *KeyStore) load() error {
+	s.checkedAt = time.Now()
+
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.keys = make(map[string]*APIKey)
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("errore accesso file API key: %w", err)
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("errore lettura file API key: %w", err)
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("errore parsing file API key: %w", err)
+	}
+
+	keys := make(map[string]*APIKey, len(f.Keys))
+	for _, k := range f.Keys {
+		keys[k.ID] = k
+	}
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// refresh rilegge il file se è cambiato dall'ultimo caricamento
+func (s *KeyStore) refresh() error {
+	s.mutex.RLock()
+	fresh := time.Since(s.checkedAt) < keyStoreRecheck
+	s.mutex.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info, err := os.Stat(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && s.modTime.IsZero():
+		s.checkedAt = time.Now()
+		return nil
+	case err == nil && info.ModTime().Equal(s.modTime):
+		s.checkedAt = time.Now()
+		return nil
+	}
+	return s.load()
+}
+
+// save scrive il file in modo atomico. Va chiamato con il mutex acquisito in scrittura.
+func (s *KeyStore) save() error {
+	f := keyFile{Keys: make([]*APIKey, 0, len(s.keys))}
+	for _, k := range s.keys {
+		f.Keys = append(f.Keys, k)
+	}
+	sort.Slice(f.Keys, func(i, j int) bool { return f.Keys[i].CreatedAt.Before(f.Keys[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("errore creazione directory API key: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".api_keys-*")
+	if err != nil {
+		return fmt.Errorf("errore scrittura file API key: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("errore scrittura file API key: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("errore scrittura file API key: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("errore scrittura file API key: %w", err)
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	s.checkedAt = time.Now()
+	return nil
+}
+
+// Create emette una nuova chiave e restituisce il token in chiaro, mostrato
+// una sola volta. ttl pari a zero significa nessuna scadenza.
+func (s *KeyStore) Create(owner, ownerType, description string, scopes []string, ttl time.Duration) (string, *APIKey, error) {
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		return "", nil, errors.New("titolare della chiave obbligatorio")
+	}
+	if ownerType != OwnerUser && ownerType != OwnerService {
+		return "", nil, fmt.Errorf("tipo titolare non valido: %q (user o service)", ownerType)
+	}
+	if err := ValidateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	if ttl < 0 {
+		return "", nil, errors.New("durata della chiave non valida")
+	}
+
+	id, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	token := APIKeyPrefix + id + "_" + secret
+
+	now := time.Now().UTC()
+	key := &APIKey{
+		ID:          id,
+		Owner:       owner,
+		OwnerType:   ownerType,
+		Description: description,
+		Scopes:      scopes,
+		Hash:        hashToken(token),
+		CreatedAt:   now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return "", nil, err
+	}
+	s.keys[id] = key
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return "", nil, err
+	}
+	return token, key, nil
+}
+
+// Revoke revoca una chiave; le chiavi revocate restano nel file per audit
+func (s *KeyStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	key, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrAPIKeyUnknown, id)
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	return s.save()
+}
+
+// List restituisce tutte le chiavi ordinate per data di creazione
+func (s *KeyStore) List() ([]APIKey, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, *k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Authenticate verifica un token Bearer e restituisce la chiave corrispondente
+func (s *KeyStore) Authenticate(token string) (*APIKey, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(token, APIKeyPrefix), "_")
+	if !strings.HasPrefix(token, APIKeyPrefix) || !ok || id == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	key, exists := s.keys[id]
+	s.mutex.RUnlock()
+
+	if !exists || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+	if key.Expired(time.Now()) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	result := *key
+	return &result, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("errore generazione chiave: %w", err)
+	}
+	return encode(b), nil
+}
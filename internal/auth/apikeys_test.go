@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestKeyStore_CreateAuthenticateRevoke(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys", "api_keys.json")
+	store, err := OpenKeyStore(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	token, key, err := store.Create("mrossi", OwnerUser, "CI", []string{"vllm:llama3*"}, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(token, APIKeyPrefix+key.ID+"_") {
+		t.Errorf("Unexpected token format: %s", token)
+	}
+
+	// Only the hash is stored on disk, with restrictive permissions
+	data, _ := os.ReadFile(file)
+	if strings.Contains(string(data), token) {
+		t.Error("Plaintext token must not be stored")
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm()&0o077 != 0 {
+		t.Errorf("Expected private key file, got %v", info.Mode().Perm())
+	}
+
+	got, err := store.Authenticate(token)
+	if err != nil || got.Owner != "mrossi" {
+		t.Fatalf("Expected valid key for mrossi, got %v (err %v)", got, err)
+	}
+	if _, err := store.Authenticate(token + "x"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected invalid key error for tampered token, got %v", err)
+	}
+	if _, err := store.Authenticate("sk-not-ours"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected invalid key error for foreign token, got %v", err)
+	}
+
+	// Revocation from another process (the CLI) is picked up after the recheck interval
+	cli, _ := OpenKeyStore(file)
+	if err := cli.Revoke(key.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.mutex.Lock()
+	store.checkedAt = time.Time{}
+	store.mutex.Unlock()
+	if _, err := store.Authenticate(token); !errors.Is(err, ErrAPIKeyRevoked) {
+		t.Errorf("Expected revoked key error, got %v", err)
+	}
+
+	if err := cli.Revoke("missing"); !errors.Is(err, ErrAPIKeyUnknown) {
+		t.Errorf("Expected unknown key error, got %v", err)
+	}
+}
+
+func TestKeyStore_Expiry(t *testing.T) {
+	store, _ := OpenKeyStore(filepath.Join(t.TempDir(), "api_keys.json"))
+	token, _, err := store.Create("ci-pipeline", OwnerService, "", nil, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := store.Authenticate(token); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Expected expired key error, got %v", err)
+	}
+
+	if _, _, err := store.Create("", OwnerUser, "", nil, 0); err == nil {
+		t.Error("Expected error for missing owner")
+	}
+	if _, _, err := store.Create("bob", OwnerUser, "", []string{"mysql"}, 0); err == nil {
+		t.Error("Expected error for invalid scope")
+	}
+}
+
+func TestScopes_Allows(t *testing.T) {
+	tests := []struct {
+		scopes  Scopes
+		backend string
+		model   string
+		want    bool
+	}{
+		{nil, "openai", "gpt-4o", true},
+		{Scopes{"ollama"}, "ollama", "", true},
+		{Scopes{"ollama"}, "vllm", "llama3", false},
+		{Scopes{"vllm:llama3*"}, "vllm", "llama3-8b", true},
+		{Scopes{"vllm:llama3*"}, "vllm", "mistral", false},
+		// Model-restricted keys cannot use endpoints without a model
+		{Scopes{"vllm:llama3*"}, "vllm", "", false},
+		{Scopes{"*:gpt-4*", "ollama"}, "openai", "gpt-4o", true},
+	}
+	for _, tt := range tests {
+		if got := tt.scopes.Allows(tt.backend, tt.model); got != tt.want {
+			t.Errorf("%v.Allows(%q, %q) = %v, expected %v", tt.scopes, tt.backend, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestAuthMiddleware_APIKey(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AD.Enabled = boolPtr(true)
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	store, _ := OpenKeyStore(filepath.Join(t.TempDir(), "api_keys.json"))
+	token, _, _ := store.Create("svc-rag", OwnerService, "", []string{"ollama"}, 0)
+
+	var gotUser string
+	var gotScopes Scopes
+	handler := AuthMiddleware(cfg, log, Authenticators{APIKeys: store})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = UserFromContext(r.Context())
+		gotScopes, _ = ScopesFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || gotUser != "svc-rag" {
+		t.Fatalf("Expected authenticated request as svc-rag, got %d %q", rr.Code, gotUser)
+	}
+	if len(gotScopes) != 1 || gotScopes[0] != "ollama" {
+		t.Errorf("Expected key scopes in context, got %v", gotScopes)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer aic_deadbeef_wrong")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unknown key, got %d", rr.Code)
+	}
+
+	// Without a key store Bearer tokens are rejected
+	rr = httptest.NewRecorder()
+	LDAPAuthMiddleware(cfg, log)(http.NotFoundHandler()).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when API keys are disabled, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddleware_UserAPIKeyDirectory(t *testing.T) {
+	entries := append(testDirectory(), testLDAPEntry{
+		DN: "CN=Carol,OU=Users,DC=example,DC=com",
+		Attrs: map[string][]string{
+			"sAMAccountName": {"carol"},
+			"memberOf":       {"CN=Guests,OU=Groups,DC=example,DC=com"},
+		},
+	})
+	server := newTestLDAPServer(t, nil, entries...)
+	cfg := newDirectoryTestConfig(server.URL("ldap"))
+	cfg.APIKeys.Enabled = true
+	ldapAuth := newDirectoryTestAuthenticator(cfg)
+	defer ldapAuth.Close()
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	store, _ := OpenKeyStore(filepath.Join(t.TempDir(), "api_keys.json"))
+	create := func(owner, ownerType string) string {
+		token, _, err := store.Create(owner, ownerType, "", nil, 0)
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+		return token
+	}
+
+	var got *Identity
+	handler := AuthMiddleware(cfg, log, Authenticators{LDAP: ldapAuth, APIKeys: store})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IdentityFromContext(r.Context())
+	}))
+	serve := func(token string) int {
+		got = nil
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// User keys carry the owner's AD groups for the policies
+	if code := serve(create("alice", OwnerUser)); code != http.StatusOK || got == nil || len(got.Groups) != 1 {
+		t.Errorf("Expected alice authenticated with her groups, got %d %+v", code, got)
+	}
+
+	// Owners removed from AD or from the allowed groups lose access
+	if code := serve(create("bob", OwnerUser)); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for owner missing from AD, got %d", code)
+	}
+	if code := serve(create("carol", OwnerUser)); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for owner outside allowed groups, got %d", code)
+	}
+
+	// Service keys are not looked up in AD
+	if code := serve(create("svc-rag", OwnerService)); code != http.StatusOK || got == nil || got.Groups != nil {
+		t.Errorf("Expected service key accepted without groups, got %d %+v", code, got)
+	}
+}
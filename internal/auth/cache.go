@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Default della cache degli esiti LDAP
+const (
+	defaultAuthCacheTTL     = 5 * time.Minute
+	defaultNegativeCacheTTL = 30 * time.Second
+	maxAuthCacheEntries     = 10000
+)
+
+// authCacheEntry è l'esito di una verifica LDAP: i gruppi dell'utente oppure
+// il rifiuto delle credenziali
+type authCacheEntry struct {
+	groups  []string
+	err     error
+	expires time.Time
+}
+
+// authCache memorizza gli esiti LDAP per (username, password). La password non
+// viene mai conservata: la chiave è un HMAC con un segreto casuale del processo.
+type authCache struct {
+	ttl         time.Duration // <= 0 disabilita la cache degli esiti positivi
+	negativeTTL time.Duration // <= 0 disabilita la cache dei rifiuti
+	secret      []byte
+
+	mutex   sync.Mutex
+	entries map[string]authCacheEntry
+}
+
+func newAuthCache(ttl, negativeTTL time.Duration) *authCache {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &authCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		secret:      secret,
+		entries:     make(map[string]authCacheEntry),
+	}
+}
+
+func (c *authCache) key(username, password string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// get restituisce l'esito memorizzato, se presente e non scaduto
+func (c *authCache) get(username, password string) (authCacheEntry, bool) {
+	if c.ttl <= 0 && c.negativeTTL <= 0 {
+		return authCacheEntry{}, false
+	}
+	key := c.key(username, password)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return authCacheEntry{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return authCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// putGroups memorizza un'autenticazione riuscita
+func (c *authCache) putGroups(username, password string, groups []string) {
+	c.put(username, password, authCacheEntry{groups: groups}, c.ttl)
+}
+
+// putDenied memorizza un rifiuto delle credenziali
+func (c *authCache) putDenied(username, password string, err error) {
+	c.put(username, password, authCacheEntry{err: err}, c.negativeTTL)
+}
+
+func (c *authCache) put(username, password string, entry authCacheEntry, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	entry.expires = time.Now().Add(ttl)
+	key := c.key(username, password)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= maxAuthCacheEntries {
+		c.evictExpired()
+	}
+	if len(c.entries) >= maxAuthCacheEntries {
+		c.entries = make(map[string]authCacheEntry)
+	}
+	c.entries[key] = entry
+}
+
+func (c *authCache) evictExpired() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
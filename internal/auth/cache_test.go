@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestAuthenticator returns an authenticator whose directory lookups are
+// answered by users (username -> password) and counted in calls
+func newTestAuthenticator(cfg *config.Config, users map[string]string, groups []string, calls *int) *LDAPAuthenticator {
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	a := NewLDAPAuthenticator(cfg, log, nil)
+	a.lookup = func(_ *config.Config, _ *ldapPool, username, password string) ([]string, error) {
+		*calls++
+		if want, ok := users[username]; !ok || want != password {
+			return nil, fmt.Errorf("%w: %s", errCredentialsRejected, username)
+		}
+		return groups, nil
+	}
+	return a
+}
+
+func newCacheTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.AD.AllowedGroups = []string{"CN=AI-Users"}
+	return cfg
+}
+
+func TestLDAPAuthenticator_CachesSuccess(t *testing.T) {
+	calls := 0
+	cfg := newCacheTestConfig()
+	a := newTestAuthenticator(cfg, map[string]string{"alice": "secret"}, []string{"CN=AI-Users,OU=Groups,DC=example,DC=com"}, &calls)
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.Authenticate("alice", "secret"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected a single LDAP lookup, got %d", calls)
+	}
+
+	// A different password is a different cache entry
+	if _, err := a.Authenticate("alice", "other"); err == nil {
+		t.Error("Expected wrong password to be rejected")
+	}
+	if calls != 2 {
+		t.Errorf("Expected a lookup for the new password, got %d", calls)
+	}
+
+	// Allowed groups are evaluated on cached groups, not cached decisions
+	cfg.AD.AllowedGroups = []string{"CN=Admins"}
+	if _, err := a.Authenticate("alice", "secret"); err == nil {
+		t.Error("Expected cached user outside allowed groups to be rejected")
+	}
+	if calls != 2 {
+		t.Errorf("Expected group check on cached entry, got %d lookups", calls)
+	}
+}
+
+func TestLDAPAuthenticator_NegativeCache(t *testing.T) {
+	calls := 0
+	a := newTestAuthenticator(newCacheTestConfig(), map[string]string{}, nil, &calls)
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.Authenticate("mallory", "guess"); !errors.Is(err, errCredentialsRejected) {
+			t.Fatalf("Expected rejected credentials, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected rejected credentials to be cached, got %d lookups", calls)
+	}
+
+	// With negative caching disabled every attempt reaches the directory
+	cfg := newCacheTestConfig()
+	cfg.AD.NegativeCacheTTL = -1
+	calls = 0
+	a = newTestAuthenticator(cfg, map[string]string{}, nil, &calls)
+	a.Authenticate("mallory", "guess")
+	a.Authenticate("mallory", "guess")
+	if calls != 2 {
+		t.Errorf("Expected no negative caching, got %d lookups", calls)
+	}
+}
+
+func TestLDAPAuthenticator_InfrastructureErrorsNotCached(t *testing.T) {
+	calls := 0
+	a := newTestAuthenticator(newCacheTestConfig(), nil, nil, &calls)
+	a.lookup = func(_ *config.Config, _ *ldapPool, _, _ string) ([]string, error) {
+		calls++
+		return nil, errors.New("errore connessione LDAP: connection refused")
+	}
+
+	a.Authenticate("alice", "secret")
+	a.Authenticate("alice", "secret")
+	if calls != 2 {
+		t.Errorf("Expected directory errors not to be cached, got %d lookups", calls)
+	}
+}
+
+func TestLDAPAuthenticator_ReloadClearsCache(t *testing.T) {
+	calls := 0
+	cfg := newCacheTestConfig()
+	a := newTestAuthenticator(cfg, map[string]string{"alice": "secret"}, []string{"CN=AI-Users,DC=example,DC=com"}, &calls)
+
+	a.Authenticate("alice", "secret")
+	a.Reload(newCacheTestConfig())
+	if _, err := a.Authenticate("alice", "secret"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected a fresh lookup after reload, got %d", calls)
+	}
+}
+
+func TestLDAPLookup_EmptyPassword(t *testing.T) {
+	// An empty password must never reach the directory: it would be an anonymous bind
+	pool := newLDAPPool(1, func() (*ldap.Conn, error) {
+		t.Fatal("Unexpected LDAP dial")
+		return nil, nil
+	})
+	if _, err := ldapLookup(newCacheTestConfig(), pool, "alice", ""); !errors.Is(err, errCredentialsRejected) {
+		t.Errorf("Expected rejected credentials, got %v", err)
+	}
+}
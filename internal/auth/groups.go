@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// errGroupDenied indica un utente autenticato ma fuori dai gruppi autorizzati
+var errGroupDenied = errors.New("utente non autorizzato")
+
+// matchingRuleInChain è l'OID di LDAP_MATCHING_RULE_IN_CHAIN: Active Directory
+// risolve la catena di appartenenza ai gruppi lato server
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// userSearchFilter costruisce il filtro di ricerca dell'utente
+func userSearchFilter(cfg *config.Config, username string) string {
+	attr := cfg.AD.UserAttribute
+	if attr == "" {
+		attr = "sAMAccountName"
+	}
+	filter := fmt.Sprintf("(%s=%s)", attr, ldap.EscapeFilter(username))
+	if extra := strings.TrimSpace(cfg.AD.UserFilter); extra != "" {
+		filter = "(&" + extra + filter + ")"
+	}
+	return filter
+}
+
+// groupAttribute restituisce l'attributo dell'utente con i DN dei gruppi
+func groupAttribute(cfg *config.Config) string {
+	if cfg.AD.GroupAttribute == "" {
+		return "memberOf"
+	}
+	return cfg.AD.GroupAttribute
+}
+
+// searchNestedGroups restituisce tutti i gruppi di cui userDN è membro, anche
+// indirettamente, con una sola ricerca
+func searchNestedGroups(cfg *config.Config, l *ldap.Conn, userDN string) ([]string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		cfg.AD.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(member:%s:=%s)", matchingRuleInChain, ldap.EscapeFilter(userDN)),
+		[]string{"1.1"}, // solo i DN, nessun attributo
+		nil,
+	)
+
+	sr, err := l.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(sr.Entries))
+	for _, entry := range sr.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// mergeGroups unisce due elenchi di DN senza duplicati
+func mergeGroups(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	merged := make([]string, 0, len(a)+len(b))
+	for _, group := range append(append([]string(nil), a...), b...) {
+		key := strings.ToLower(group)
+		if !seen[key] {
+			seen[key] = true
+			merged = append(merged, group)
+		}
+	}
+	return merged
+}
+
+// groupMatches confronta un gruppo autorizzato con il DN di un gruppo
+// dell'utente. Un DN completo deve coincidere esattamente (senza distinzione
+// tra maiuscole e minuscole); un nome semplice ("AI-Users") o un solo RDN
+// ("CN=AI-Users") viene confrontato con il primo RDN del gruppo, quindi
+// "CN=AI-Users" non corrisponde a "CN=AI-Users-Disabled".
+func groupMatches(allowed, group string) bool {
+	groupDN, err := ldap.ParseDN(group)
+	if err != nil || len(groupDN.RDNs) == 0 {
+		return strings.EqualFold(allowed, group)
+	}
+
+	if !strings.Contains(allowed, "=") {
+		for _, attr := range groupDN.RDNs[0].Attributes {
+			if strings.EqualFold(attr.Type, "CN") && strings.EqualFold(attr.Value, allowed) {
+				return true
+			}
+		}
+		return false
+	}
+
+	allowedDN, err := ldap.ParseDN(allowed)
+	if err != nil || len(allowedDN.RDNs) == 0 {
+		return false
+	}
+	if len(allowedDN.RDNs) == 1 {
+		return allowedDN.RDNs[0].EqualFold(groupDN.RDNs[0])
+	}
+	return allowedDN.EqualFold(groupDN)
+}
+
+// authorizeGroups verifica l'appartenenza a uno dei gruppi autorizzati
+func authorizeGroups(allowed []string, log *logrus.Logger, username string, userGroups []string) error {
+	for _, allowedGroup := range allowed {
+		for _, userGroup := range userGroups {
+			if groupMatches(allowedGroup, userGroup) {
+				log.WithFields(logrus.Fields{
+					"username":      username,
+					"matched_group": allowedGroup,
+				}).Debug("Utente autorizzato tramite gruppo")
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %s non appartiene a nessun gruppo autorizzato", errGroupDenied, username)
+}
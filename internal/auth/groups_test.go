@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+func TestGroupMatches(t *testing.T) {
+	const group = "CN=AI-Users,OU=Groups,DC=example,DC=com"
+	tests := []struct {
+		allowed string
+		group   string
+		want    bool
+	}{
+		{"CN=AI-Users,OU=Groups,DC=example,DC=com", group, true},
+		{"cn=ai-users, ou=groups, dc=example, dc=com", group, true},
+		{"CN=AI-Users,OU=Other,DC=example,DC=com", group, false},
+		{"CN=AI-Users", group, true},
+		{"AI-Users", group, true},
+		{"ai-users", group, true},
+		// Prefixes and substrings no longer match
+		{"CN=AI-Users", "CN=AI-Users-Disabled,OU=Groups,DC=example,DC=com", false},
+		{"AI-Users", "CN=AI-Users-Disabled,OU=Groups,DC=example,DC=com", false},
+		{"CN=AI-Users,OU=Groups,DC=example,DC=com", "CN=AI-Users-Disabled,OU=Groups,DC=example,DC=com", false},
+		{"Groups", group, false},
+		// Escaped characters are compared after parsing
+		{`CN=R\2CD,OU=Groups,DC=example,DC=com`, `CN=R\,D,OU=Groups,DC=example,DC=com`, true},
+	}
+
+	for _, tt := range tests {
+		if got := groupMatches(tt.allowed, tt.group); got != tt.want {
+			t.Errorf("groupMatches(%q, %q) = %v, expected %v", tt.allowed, tt.group, got, tt.want)
+		}
+	}
+}
+
+func TestUserSearchFilter(t *testing.T) {
+	cfg := &config.Config{}
+	if got := userSearchFilter(cfg, "alice"); got != "(sAMAccountName=alice)" {
+		t.Errorf("Unexpected default filter: %s", got)
+	}
+
+	cfg.AD.UserAttribute = "uid"
+	cfg.AD.UserFilter = "(objectClass=inetOrgPerson)"
+	if got := userSearchFilter(cfg, "a*)(uid=*"); got != `(&(objectClass=inetOrgPerson)(uid=a\2a\29\28uid=\2a))` {
+		t.Errorf("Unexpected filter: %s", got)
+	}
+}
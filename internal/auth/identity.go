@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header con l'identità autenticata inoltrati ai backend. Quelli ricevuti dal
+// client vengono sempre rimossi: l'identità arriva solo dal contesto.
+const (
+	HeaderUser       = "X-Forwarded-User"
+	HeaderGroups     = "X-Forwarded-Groups"      // gruppi con percent-encoding, separati da virgola
+	HeaderAuthMethod = "X-Forwarded-Auth-Method" // ldap, api_key, oidc, mtls o kerberos
+	HeaderTimestamp  = "X-AIConnect-Identity-Timestamp"
+	HeaderSignature  = "X-AIConnect-Identity-Signature" // v1=<HMAC-SHA256 esadecimale>
+)
+
+var identityHeaders = []string{HeaderUser, HeaderGroups, HeaderAuthMethod, HeaderTimestamp, HeaderSignature}
+
+// Errori di verifica degli header di identità
+var (
+	ErrIdentityUnsigned = errors.New("header di identità senza firma")
+	ErrIdentitySigned   = errors.New("firma degli header di identità non valida")
+	ErrIdentityStale    = errors.New("header di identità scaduti")
+)
+
+// Identity è l'utente autenticato dal middleware
+type Identity struct {
+	User   string
+	Groups []string
+	Method string // MethodLDAP, MethodAPIKey, MethodOIDC, MethodMTLS o MethodKerberos
+}
+
+type identityKey struct{}
+
+// WithIdentity associa al contesto l'identità autenticata
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext restituisce l'identità autenticata, nil per le richieste
+// anonime (autenticazione disabilitata o path pubblici)
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// UserFromContext restituisce lo username autenticato, vuoto se anonimo
+func UserFromContext(ctx context.Context) string {
+	if id := IdentityFromContext(ctx); id != nil {
+		return id.User
+	}
+	return ""
+}
+
+// StripIdentityHeaders rimuove gli header di identità, che il client potrebbe falsificare
+func StripIdentityHeaders(h http.Header) {
+	for _, name := range identityHeaders {
+		h.Del(name)
+	}
+}
+
+// SetIdentityHeaders sostituisce gli header di identità con quelli
+// dell'identità indicata (nessuno se nil). Con una chiave gli header sono
+// firmati, così i backend esposti anche ad altri client possono fidarsene.
+func SetIdentityHeaders(h http.Header, id *Identity, key []byte, now time.Time) {
+	StripIdentityHeaders(h)
+	if id == nil {
+		return
+	}
+	h.Set(HeaderUser, id.User)
+	h.Set(HeaderAuthMethod, id.Method)
+	groups := encodeGroups(id.Groups)
+	if groups != "" {
+		h.Set(HeaderGroups, groups)
+	}
+	if len(key) == 0 {
+		return
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	h.Set(HeaderTimestamp, ts)
+	h.Set(HeaderSignature, "v1="+signIdentity(key, id.User, groups, id.Method, ts))
+}
+
+// VerifyIdentityHeaders verifica la firma degli header di identità e
+// restituisce l'identità; maxAge limita il riuso di header intercettati.
+// È il controllo che un backend deve replicare per fidarsi degli header.
+func VerifyIdentityHeaders(h http.Header, key []byte, maxAge time.Duration, now time.Time) (*Identity, error) {
+	sig, ok := strings.CutPrefix(h.Get(HeaderSignature), "v1=")
+	ts := h.Get(HeaderTimestamp)
+	if !ok || ts == "" {
+		return nil, ErrIdentityUnsigned
+	}
+
+	user, groups, method := h.Get(HeaderUser), h.Get(HeaderGroups), h.Get(HeaderAuthMethod)
+	expected := signIdentity(key, user, groups, method, ts)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return nil, ErrIdentitySigned
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrIdentitySigned
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > maxAge || age < -maxAge {
+		return nil, ErrIdentityStale
+	}
+
+	decoded, err := decodeGroups(groups)
+	if err != nil {
+		return nil, ErrIdentitySigned
+	}
+	return &Identity{User: user, Groups: decoded, Method: method}, nil
+}
+
+// signIdentity calcola l'HMAC sui valori degli header separati da newline,
+// che non può comparire nei valori
+func signIdentity(key []byte, user, groups, method, ts string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{"v1", user, groups, method, ts}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encodeGroups codifica i gruppi in un unico header: i DN contengono virgole,
+// per cui ogni gruppo è codificato con percent-encoding
+func encodeGroups(groups []string) string {
+	encoded := make([]string, len(groups))
+	for i, g := range groups {
+		encoded[i] = url.QueryEscape(g)
+	}
+	return strings.Join(encoded, ",")
+}
+
+func decodeGroups(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	groups := make([]string, len(parts))
+	for i, p := range parts {
+		g, err := url.QueryUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		groups[i] = g
+	}
+	return groups, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestIdentityHeaders_SignAndVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1700000000, 0)
+	id := &Identity{
+		User:   "alice",
+		Groups: []string{"CN=AI-Users,OU=Groups,DC=example,DC=com", "ricerca & sviluppo"},
+		Method: MethodOIDC,
+	}
+
+	h := http.Header{}
+	SetIdentityHeaders(h, id, key, now)
+	got, err := VerifyIdentityHeaders(h, key, time.Minute, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("Expected valid signature, got %v", err)
+	}
+	if got.User != id.User || got.Method != id.Method || len(got.Groups) != 2 || got.Groups[0] != id.Groups[0] || got.Groups[1] != id.Groups[1] {
+		t.Errorf("Expected %+v, got %+v", id, got)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(h http.Header)
+		at     time.Time
+		want   error
+	}{
+		{"tampered user", func(h http.Header) { h.Set(HeaderUser, "mallory") }, now, ErrIdentitySigned},
+		{"added group", func(h http.Header) { h.Set(HeaderGroups, h.Get(HeaderGroups)+",Domain+Admins") }, now, ErrIdentitySigned},
+		{"missing signature", func(h http.Header) { h.Del(HeaderSignature) }, now, ErrIdentityUnsigned},
+		{"replayed", func(h http.Header) {}, now.Add(2 * time.Minute), ErrIdentityStale},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		SetIdentityHeaders(h, id, key, now)
+		tt.modify(h)
+		if _, err := VerifyIdentityHeaders(h, key, time.Minute, tt.at); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// Without a key the identity is forwarded unsigned
+	h = http.Header{}
+	h.Set(HeaderSignature, "v1=forged")
+	SetIdentityHeaders(h, &Identity{User: "bob", Method: MethodLDAP}, nil, now)
+	if h.Get(HeaderUser) != "bob" || h.Get(HeaderSignature) != "" || h.Get(HeaderGroups) != "" {
+		t.Errorf("Unexpected unsigned headers: %v", h)
+	}
+}
+
+func TestAuthMiddleware_StripsIdentityHeaders(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	disabled := &config.Config{}
+	disabled.AD.Enabled = boolPtr(false)
+	public := &config.Config{}
+	public.AD.Enabled = boolPtr(true)
+	public.AD.PublicPaths = []string{"/health"}
+
+	for name, cfg := range map[string]*config.Config{"auth disabled": disabled, "public path": public} {
+		var header http.Header
+		var id *Identity
+		handler := AuthMiddleware(cfg, log, Authenticators{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header, id = r.Header, IdentityFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", "/health", nil)
+		req.Header.Set(HeaderUser, "admin")
+		req.Header.Set(HeaderGroups, "Domain+Admins")
+		req.Header.Set(HeaderSignature, "v1=forged")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if id != nil || header.Get(HeaderUser) != "" || header.Get(HeaderGroups) != "" || header.Get(HeaderSignature) != "" {
+			t.Errorf("%s: expected anonymous request without identity headers, got %+v %v", name, id, header)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidTicket indica un token Negotiate non valido o un ticket Kerberos rifiutato
+var ErrInvalidTicket = errors.New("ticket Kerberos non valido")
+
+// defaultKerberosClockSkew è usato quando la configurazione non passa da config.Load
+const defaultKerberosClockSkew = 5 * time.Minute
+
+// KerberosAuthenticator verifica i token SPNEGO (Authorization: Negotiate) dei
+// client nel dominio con il keytab del servizio e legge i gruppi dell'utente
+// da AD con la ricerca LDAP, senza che la password transiti dal proxy
+type KerberosAuthenticator struct {
+	log  *logrus.Logger
+	ldap *LDAPAuthenticator
+
+	mutex     sync.RWMutex
+	keytab    *keytab.Keytab
+	principal string
+	realm     string
+	clockSkew time.Duration
+}
+
+// NewKerberosAuthenticator carica il keytab; i gruppi sono risolti da ldapAuth
+func NewKerberosAuthenticator(cfg *config.Config, log *logrus.Logger, ldapAuth *LDAPAuthenticator) (*KerberosAuthenticator, error) {
+	a := &KerberosAuthenticator{log: log, ldap: ldapAuth}
+	if err := a.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload rilegge il keytab, ad esempio dopo la rotazione della chiave del servizio
+func (a *KerberosAuthenticator) Reload(cfg *config.Config) error {
+	kt, err := keytab.Load(cfg.Kerberos.Keytab)
+	if err != nil {
+		return fmt.Errorf("errore caricamento keytab Kerberos: %w", err)
+	}
+	clockSkew := time.Duration(cfg.Kerberos.ClockSkew) * time.Second
+	if clockSkew <= 0 {
+		clockSkew = defaultKerberosClockSkew
+	}
+
+	a.mutex.Lock()
+	a.keytab = kt
+	a.principal = cfg.Kerberos.ServicePrincipal
+	a.realm = cfg.Kerberos.Realm
+	a.clockSkew = clockSkew
+	a.mutex.Unlock()
+
+	a.log.WithField("keytab", cfg.Kerberos.Keytab).Debug("Keytab Kerberos caricato")
+	return nil
+}
+
+// Authenticate verifica il token Negotiate (base64) inviato da remoteAddr e
+// restituisce l'identità con i gruppi letti da AD
+func (a *KerberosAuthenticator) Authenticate(token, remoteAddr string) (*Identity, error) {
+	a.mutex.RLock()
+	kt, principal, realm, clockSkew := a.keytab, a.principal, a.realm, a.clockSkew
+	a.mutex.RUnlock()
+
+	krb5Token, err := decodeNegotiateToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTicket, err)
+	}
+
+	options := []func(*service.Settings){
+		service.MaxClockSkew(clockSkew),
+		service.DecodePAC(false),
+	}
+	if principal != "" {
+		options = append(options, service.KeytabPrincipal(principal))
+	}
+	if addr, err := types.GetHostAddress(remoteAddr); err == nil {
+		options = append(options, service.ClientAddress(addr))
+	}
+	ok, creds, err := service.VerifyAPREQ(&krb5Token.APReq, service.NewSettings(kt, options...))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTicket, err)
+	}
+	if !ok {
+		return nil, ErrInvalidTicket
+	}
+
+	// Solo gli utenti del realm configurato: un trust tra domini non deve
+	// far corrispondere username omonimi di un altro realm
+	if !strings.EqualFold(creds.Domain(), realm) {
+		return nil, fmt.Errorf("%w: realm %s non accettato", ErrInvalidTicket, creds.Domain())
+	}
+
+	username := creds.UserName()
+	groups, err := a.ldap.Groups(username)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{User: username, Groups: groups, Method: MethodKerberos}, nil
+}
+
+// decodeNegotiateToken estrae l'AP_REQ dal token SPNEGO; alcuni client inviano
+// direttamente il token Kerberos senza l'involucro SPNEGO
+func decodeNegotiateToken(token string) (*spnego.KRB5Token, error) {
+	b, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("token non in base64: %w", err)
+	}
+
+	mechToken := b
+	var st spnego.SPNEGOToken
+	if err := st.Unmarshal(b); err == nil {
+		if !st.Init || len(st.NegTokenInit.MechTypes) == 0 {
+			return nil, errors.New("token SPNEGO senza NegTokenInit")
+		}
+		oid := st.NegTokenInit.MechTypes[0]
+		if !oid.Equal(gssapi.OIDKRB5.OID()) && !oid.Equal(gssapi.OIDMSLegacyKRB5.OID()) {
+			return nil, errors.New("meccanismo SPNEGO diverso da Kerberos (NTLM non è supportato)")
+		}
+		mechToken = st.NegTokenInit.MechTokenBytes
+	}
+
+	var krb5Token spnego.KRB5Token
+	if err := krb5Token.Unmarshal(mechToken); err != nil {
+		return nil, fmt.Errorf("token Kerberos non valido: %w", err)
+	}
+	if !krb5Token.IsAPReq() {
+		return nil, errors.New("il token Kerberos non contiene un AP_REQ")
+	}
+	return &krb5Token, nil
+}
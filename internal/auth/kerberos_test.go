@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testRealm = "TEST.LOCAL"
+	testSPN   = "HTTP/aiconnect.test.local"
+)
+
+// testKDC stands in for the domain controller: it holds the service key also
+// written to the keytab and issues service tickets like a TGS would
+type testKDC struct {
+	keytab     *keytab.Keytab
+	KeytabFile string
+}
+
+func newTestKDC(t *testing.T, serviceSecret string) *testKDC {
+	t.Helper()
+	kt := keytab.New()
+	if err := kt.AddEntry(testSPN, testRealm, serviceSecret, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatalf("keytab entry: %v", err)
+	}
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("marshal keytab: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "aiconnect.keytab")
+	if err := os.WriteFile(file, b, 0o600); err != nil {
+		t.Fatalf("write keytab: %v", err)
+	}
+	return &testKDC{keytab: kt, KeytabFile: file}
+}
+
+// negotiate returns the Negotiate token a domain-joined client would send
+// after obtaining a service ticket for user@realm valid from start for validity
+func (k *testKDC) negotiate(t *testing.T, user, realm string, start time.Time, validity time.Duration) string {
+	t.Helper()
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, user)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, testSPN)
+	tkt, sessionKey, err := messages.NewTicket(cname, realm, sname, testRealm, types.NewKrbFlags(), k.keytab,
+		etypeID.AES256_CTS_HMAC_SHA1_96, 1, start, start, start.Add(validity), start.Add(validity))
+	if err != nil {
+		t.Fatalf("issue ticket: %v", err)
+	}
+
+	cl := client.NewWithPassword(user, realm, "unused", krbconfig.New(), client.DisablePAFXFAST(true))
+	init, err := spnego.NewNegTokenInitKRB5(cl, tkt, sessionKey)
+	if err != nil {
+		t.Fatalf("build AP_REQ: %v", err)
+	}
+	st := spnego.SPNEGOToken{Init: true, NegTokenInit: init}
+	b, err := st.Marshal()
+	if err != nil {
+		t.Fatalf("marshal SPNEGO token: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func newKerberosTestConfig(kdc *testKDC, ldapURL string) *config.Config {
+	cfg := newDirectoryTestConfig(ldapURL)
+	cfg.Kerberos.Enabled = true
+	cfg.Kerberos.Keytab = kdc.KeytabFile
+	cfg.Kerberos.Realm = testRealm
+	return cfg
+}
+
+func TestKerberosAuthenticator(t *testing.T) {
+	server := newTestLDAPServer(t, nil, testDirectory()...)
+	kdc := newTestKDC(t, "service-secret")
+	cfg := newKerberosTestConfig(kdc, server.URL("ldap"))
+
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+	ldapAuth := newDirectoryTestAuthenticator(cfg)
+	defer ldapAuth.Close()
+	a, err := NewKerberosAuthenticator(cfg, log, ldapAuth)
+	if err != nil {
+		t.Fatalf("NewKerberosAuthenticator: %v", err)
+	}
+
+	now := time.Now().UTC()
+	token := kdc.negotiate(t, "alice", testRealm, now, time.Hour)
+	id, err := a.Authenticate(token, "10.0.0.1:50000")
+	if err != nil {
+		t.Fatalf("Expected valid ticket, got %v", err)
+	}
+	if id.User != "alice" || id.Method != MethodKerberos || len(id.Groups) != 1 {
+		t.Errorf("Unexpected identity %+v", id)
+	}
+	// Groups come from the service account search: the user never binds
+	if _, binds := server.counts(); binds != 1 {
+		t.Errorf("Expected only the service account bind, got %d binds", binds)
+	}
+
+	if _, err := a.Authenticate(token, "10.0.0.1:50000"); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("Expected replayed token to be rejected, got %v", err)
+	}
+
+	other := newTestKDC(t, "another-secret")
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired ticket", kdc.negotiate(t, "alice", testRealm, now.Add(-3*time.Hour), time.Hour), ErrInvalidTicket},
+		{"foreign realm", kdc.negotiate(t, "alice", "OTHER.LOCAL", now, time.Hour), ErrInvalidTicket},
+		{"wrong service key", other.negotiate(t, "alice", testRealm, now, time.Hour), ErrInvalidTicket},
+		{"not base64", "%%%", ErrInvalidTicket},
+		{"unknown user", kdc.negotiate(t, "bob", testRealm, now, time.Hour), errCredentialsRejected},
+	}
+	for _, tt := range tests {
+		if _, err := a.Authenticate(tt.token, "10.0.0.1:50000"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestAuthMiddleware_Negotiate(t *testing.T) {
+	kdc := newTestKDC(t, "service-secret")
+	cfg := newKerberosTestConfig(kdc, "ldap://127.0.0.1:1")
+	cfg.AD.AllowedGroups = []string{"ai-users"}
+	cfg.Auth.Routes = []config.AuthRoute{{PathPrefix: "/openai/", Methods: []string{MethodLDAP}}}
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	ldapAuth := NewLDAPAuthenticator(cfg, log, nil)
+	ldapAuth.groupLookup = func(_ *config.Config, _ *ldapPool, username string) ([]string, error) {
+		if username == "guest" {
+			return []string{"CN=guests,OU=Groups,DC=example,DC=com"}, nil
+		}
+		return []string{"CN=ai-users,OU=Groups,DC=example,DC=com"}, nil
+	}
+	krb, err := NewKerberosAuthenticator(cfg, log, ldapAuth)
+	if err != nil {
+		t.Fatalf("NewKerberosAuthenticator: %v", err)
+	}
+
+	var got *Identity
+	handler := AuthMiddleware(cfg, log, Authenticators{LDAP: ldapAuth, Kerberos: krb})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IdentityFromContext(r.Context())
+	}))
+	serve := func(path, header string) *httptest.ResponseRecorder {
+		got = nil
+		req := httptest.NewRequest("POST", path, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Browsers only attempt single sign-on when the server offers Negotiate
+	rr := serve("/v1/chat/completions", "")
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "Negotiate" {
+		t.Errorf("Expected 401 with Negotiate challenge, got %d %q", rr.Code, rr.Header().Get("WWW-Authenticate"))
+	}
+	if rr := serve("/openai/v1/models", ""); rr.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("Expected no Negotiate challenge on LDAP-only route, got %q", rr.Header().Get("WWW-Authenticate"))
+	}
+
+	now := time.Now().UTC()
+	if rr := serve("/v1/chat/completions", "Negotiate "+kdc.negotiate(t, "alice", testRealm, now, time.Hour)); rr.Code != http.StatusOK || got == nil || got.User != "alice" {
+		t.Errorf("Expected alice authenticated via Kerberos, got %d %+v", rr.Code, got)
+	}
+	if rr := serve("/v1/chat/completions", "Negotiate "+kdc.negotiate(t, "guest", testRealm, now, time.Hour)); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for user outside allowed groups, got %d", rr.Code)
+	}
+	if rr := serve("/v1/chat/completions", "Negotiate bm90IGEgdGlja2V0"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for invalid token, got %d", rr.Code)
+	}
+	if rr := serve("/openai/v1/models", "Negotiate "+kdc.negotiate(t, "alice", testRealm, now, time.Hour)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for Negotiate on LDAP-only route, got %d", rr.Code)
+	}
+}
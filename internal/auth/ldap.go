@@ -1,12 +1,16 @@
 package auth
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/fzanti/aiconnect/internal/metrics"
 	"github.com/go-ldap/ldap/v3"
 	"github.com/sirupsen/logrus"
 )
@@ -40,90 +44,246 @@ func isPublicPath(path string, publicPaths []string) bool {
 
 // LDAPAuthMiddleware gestisce l'autenticazione LDAP e l'autorizzazione basata su gruppi AD
 func LDAPAuthMiddleware(cfg *config.Config, log *logrus.Logger) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Se l'autenticazione AD non è abilitata, passa direttamente
-			if cfg.AD.Enabled != nil && !*cfg.AD.Enabled {
-				log.WithField("path", r.URL.Path).Debug("Autenticazione AD disabilitata, accesso consentito")
-				next.ServeHTTP(w, r)
-				return
-			}
+	return AuthMiddleware(cfg, log, Authenticators{LDAP: NewLDAPAuthenticator(cfg, log, nil)})
+}
 
-			// Controlla se il path è pubblico (accessibile senza autenticazione)
-			if isPublicPath(r.URL.Path, cfg.AD.PublicPaths) {
-				log.WithField("path", r.URL.Path).Debug("Path pubblico, accesso senza autenticazione")
-				next.ServeHTTP(w, r)
-				return
-			}
+// Esiti della consultazione della cache LDAP (label della metrica)
+const (
+	cacheHit         = "hit"
+	cacheNegativeHit = "negative_hit"
+	cacheMiss        = "miss"
+)
 
-			// Estrai credenziali dall'header Authorization
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				log.Warn("Richiesta senza header Authorization")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+// errCredentialsRejected indica credenziali rifiutate dalla directory (utente
+// inesistente o password errata): è l'unico errore LDAP memorizzato in cache
+var errCredentialsRejected = errors.New("credenziali rifiutate")
 
-			// Verifica che sia Basic Auth
-			if !strings.HasPrefix(authHeader, "Basic ") {
-				log.Warn("Tipo autenticazione non supportato")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+// LDAPAuthenticator verifica le credenziali AD riusando le connessioni LDAP e
+// memorizzando gli esiti per (username, hash della password). I gruppi
+// autorizzati sono valutati a ogni richiesta sui gruppi in cache, quindi una
+// modifica di allowed_groups ha effetto subito.
+type LDAPAuthenticator struct {
+	log     *logrus.Logger
+	metrics *metrics.Manager // opzionale
 
-			// Decodifica credenziali Base64
-			encoded := strings.TrimPrefix(authHeader, "Basic ")
-			decoded, err := base64.StdEncoding.DecodeString(encoded)
-			if err != nil {
-				log.WithError(err).Warn("Errore decodifica credenziali")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+	mutex      sync.RWMutex
+	cfg        *config.Config
+	pool       *ldapPool
+	cache      *authCache
+	groupCache *authCache // gruppi degli utenti autenticati con altri metodi (Kerberos)
 
-			// Separa username e password
-			credentials := strings.SplitN(string(decoded), ":", 2)
-			if len(credentials) != 2 {
-				log.Warn("Formato credenziali invalido")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+	// lookup interroga la directory e restituisce i gruppi dell'utente
+	lookup func(cfg *config.Config, pool *ldapPool, username, password string) ([]string, error)
+	// groupLookup restituisce i gruppi di un utente senza verificarne la password
+	groupLookup func(cfg *config.Config, pool *ldapPool, username string) ([]string, error)
+}
 
-			username := credentials[0]
-			password := credentials[1]
-
-			// Autentica contro AD e verifica gruppi
-			if err := authenticateAndAuthorize(cfg, log, username, password); err != nil {
-				log.WithFields(logrus.Fields{
-					"username": username,
-					"error":    err.Error(),
-				}).Warn("Autenticazione o autorizzazione fallita")
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				return
+// NewLDAPAuthenticator crea l'autenticatore; mm può essere nil
+func NewLDAPAuthenticator(cfg *config.Config, log *logrus.Logger, mm *metrics.Manager) *LDAPAuthenticator {
+	a := &LDAPAuthenticator{log: log, metrics: mm, lookup: ldapLookup, groupLookup: ldapGroupLookup}
+	a.configure(cfg)
+	return a
+}
+
+// configure crea pool e cache per la configurazione. Va chiamato con il mutex
+// acquisito in scrittura.
+func (a *LDAPAuthenticator) configure(cfg *config.Config) {
+	a.cfg = cfg
+	a.pool = newLDAPPool(cfg.AD.PoolSize, ldapDialer(cfg))
+	a.cache = newAuthCache(cacheTTL(cfg.AD.CacheTTL, defaultAuthCacheTTL), cacheTTL(cfg.AD.NegativeCacheTTL, defaultNegativeCacheTTL))
+	a.groupCache = newAuthCache(cacheTTL(cfg.AD.CacheTTL, defaultAuthCacheTTL), cacheTTL(cfg.AD.NegativeCacheTTL, defaultNegativeCacheTTL))
+
+	if strings.HasPrefix(cfg.AD.LDAPURL, "ldap://") && !cfg.AD.StartTLS && (cfg.AD.Enabled == nil || *cfg.AD.Enabled) {
+		a.log.Warn("Connessione LDAP senza TLS: le password degli utenti transitano in chiaro (usare ldaps:// o ad.start_tls)")
+	}
+}
+
+// ldapDialTimeout limita connessione e handshake TLS verso il server LDAP
+const ldapDialTimeout = 10 * time.Second
+
+// ldapDialer restituisce la funzione di connessione per la configurazione:
+// ldaps:// usa TLS dall'inizio, ldap:// con start_tls passa a TLS prima del bind
+func ldapDialer(cfg *config.Config) func() (*ldap.Conn, error) {
+	tlsCfg, tlsErr := config.LDAPTLSConfig(cfg)
+	return func() (*ldap.Conn, error) {
+		if tlsErr != nil {
+			return nil, tlsErr
+		}
+		conn, err := ldap.DialURL(cfg.AD.LDAPURL,
+			ldap.DialWithDialer(&net.Dialer{Timeout: ldapDialTimeout}),
+			ldap.DialWithTLSConfig(tlsCfg))
+		if err != nil {
+			return nil, err
+		}
+		if cfg.AD.StartTLS {
+			if err := conn.StartTLS(tlsCfg); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("errore StartTLS: %w", err)
 			}
+		}
+		return conn, nil
+	}
+}
+
+// cacheTTL converte i secondi della configurazione: 0 usa il default, un valore negativo disabilita
+func cacheTTL(seconds int, def time.Duration) time.Duration {
+	if seconds == 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Reload applica una nuova configurazione: le connessioni inattive vengono
+// chiuse e la cache svuotata, perché directory o credenziali potrebbero essere cambiate
+func (a *LDAPAuthenticator) Reload(cfg *config.Config) {
+	a.mutex.Lock()
+	old := a.pool
+	a.configure(cfg)
+	a.mutex.Unlock()
+
+	old.close()
+	a.log.Info("Configurazione LDAP ricaricata, cache autenticazioni svuotata")
+}
 
-			// Aggiungi username al contesto per audit
-			r.Header.Set("X-Forwarded-User", username)
+// Close chiude le connessioni LDAP inattive
+func (a *LDAPAuthenticator) Close() {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	a.pool.close()
+}
 
-			log.WithField("username", username).Info("Autenticazione e autorizzazione riuscita")
+// Authenticate verifica le credenziali e l'appartenenza a un gruppo
+// autorizzato e restituisce i gruppi dell'utente
+func (a *LDAPAuthenticator) Authenticate(username, password string) ([]string, error) {
+	// Snapshot: un reload concorrente non mescola configurazioni diverse e gli
+	// esiti ottenuti con la vecchia finiscono nella cache scartata
+	a.mutex.RLock()
+	cfg, pool, cache := a.cfg, a.pool, a.cache
+	a.mutex.RUnlock()
 
-			// Passa al prossimo handler
-			next.ServeHTTP(w, r)
-		})
+	if entry, ok := cache.get(username, password); ok {
+		if entry.err != nil {
+			a.recordCache(cacheNegativeHit)
+			return nil, entry.err
+		}
+		a.recordCache(cacheHit)
+		return entry.groups, authorizeGroups(cfg.AD.AllowedGroups, a.log, username, entry.groups)
 	}
+	a.recordCache(cacheMiss)
+
+	groups, err := a.lookup(cfg, pool, username, password)
+	if err != nil {
+		// Gli errori di rete o del service account non dipendono dalle
+		// credenziali e non vanno memorizzati
+		if errors.Is(err, errCredentialsRejected) {
+			cache.putDenied(username, password, err)
+		}
+		return nil, err
+	}
+	cache.putGroups(username, password, groups)
+	return groups, authorizeGroups(cfg.AD.AllowedGroups, a.log, username, groups)
 }
 
-// authenticateAndAuthorize esegue bind LDAP e verifica appartenenza a gruppi autorizzati
-func authenticateAndAuthorize(cfg *config.Config, log *logrus.Logger, username, password string) error {
-	// Connessione al server LDAP
-	l, err := ldap.DialURL(cfg.AD.LDAPURL)
+// Groups restituisce i gruppi di un utente già autenticato con un altro metodo
+// (es. ticket Kerberos) e ne verifica l'appartenenza a un gruppo autorizzato.
+// I risultati usano una cache separata: quella delle credenziali non deve
+// mai rispondere per una password che non è stata verificata.
+func (a *LDAPAuthenticator) Groups(username string) ([]string, error) {
+	a.mutex.RLock()
+	cfg, pool, cache := a.cfg, a.pool, a.groupCache
+	a.mutex.RUnlock()
+
+	if entry, ok := cache.get(username, ""); ok {
+		if entry.err != nil {
+			a.recordCache(cacheNegativeHit)
+			return nil, entry.err
+		}
+		a.recordCache(cacheHit)
+		return entry.groups, authorizeGroups(cfg.AD.AllowedGroups, a.log, username, entry.groups)
+	}
+	a.recordCache(cacheMiss)
+
+	groups, err := a.groupLookup(cfg, pool, username)
 	if err != nil {
-		return fmt.Errorf("errore connessione LDAP: %w", err)
+		if errors.Is(err, errCredentialsRejected) {
+			cache.putDenied(username, "", err)
+		}
+		return nil, err
+	}
+	cache.putGroups(username, "", groups)
+	return groups, authorizeGroups(cfg.AD.AllowedGroups, a.log, username, groups)
+}
+
+func (a *LDAPAuthenticator) recordCache(result string) {
+	if a.metrics != nil {
+		a.metrics.IncrementAuthCache(result)
+	}
+}
+
+// ldapLookup esegue bind del service account, ricerca dell'utente e bind con
+// le sue credenziali su una connessione del pool
+func ldapLookup(cfg *config.Config, pool *ldapPool, username, password string) ([]string, error) {
+	if password == "" {
+		// Un bind con password vuota sarebbe un bind anonimo, non un'autenticazione
+		return nil, fmt.Errorf("%w: password vuota per utente %s", errCredentialsRejected, username)
+	}
+
+	return withLDAPConn(pool, func(l *ldap.Conn) ([]string, error) {
+		return searchAndBind(cfg, l, username, password)
+	})
+}
+
+// ldapGroupLookup cerca l'utente con il service account e ne restituisce i
+// gruppi, senza bind con le sue credenziali
+func ldapGroupLookup(cfg *config.Config, pool *ldapPool, username string) ([]string, error) {
+	return withLDAPConn(pool, func(l *ldap.Conn) ([]string, error) {
+		_, groups, err := searchUser(cfg, l, username)
+		return groups, err
+	})
+}
+
+// withLDAPConn esegue op su una connessione del pool. Una connessione riusata
+// potrebbe essere stata chiusa dal server: in quel caso si riprova con una nuova.
+func withLDAPConn(pool *ldapPool, op func(l *ldap.Conn) ([]string, error)) ([]string, error) {
+	for attempt := 0; ; attempt++ {
+		conn, reused, err := pool.get()
+		if err != nil {
+			return nil, fmt.Errorf("errore connessione LDAP: %w", err)
+		}
+
+		groups, err := op(conn)
+		pool.put(conn, err == nil || !ldap.IsErrorWithCode(err, ldap.ErrorNetwork))
+		if err != nil && reused && attempt == 0 && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			continue
+		}
+		return groups, err
 	}
-	defer l.Close()
+}
 
-	// Bind con account di servizio per cercare l'utente
+// searchAndBind cerca l'utente con il service account e ne verifica la password
+func searchAndBind(cfg *config.Config, l *ldap.Conn, username, password string) ([]string, error) {
+	userDN, userGroups, err := searchUser(cfg, l, username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bind con credenziali utente per autenticazione
+	if err := l.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, fmt.Errorf("%w: credenziali invalide per utente %s", errCredentialsRejected, username)
+		}
+		return nil, fmt.Errorf("errore bind utente %s: %w", username, err)
+	}
+
+	return userGroups, nil
+}
+
+// searchUser cerca con il service account il DN e i gruppi dell'utente
+func searchUser(cfg *config.Config, l *ldap.Conn, username string) (string, []string, error) {
+	// Bind con account di servizio per cercare l'utente; ripetuto a ogni uso
+	// perché la connessione potrebbe essere associata all'utente precedente
 	if err := l.Bind(cfg.AD.BindDN, cfg.AD.BindPassword); err != nil {
-		return fmt.Errorf("errore bind service account: %w", err)
+		return "", nil, fmt.Errorf("errore bind service account: %w", err)
 	}
 
 	// Cerca DN dell'utente
@@ -134,50 +294,34 @@ func authenticateAndAuthorize(cfg *config.Config, log *logrus.Logger, username,
 		0,
 		0,
 		false,
-		fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(username)),
-		[]string{"dn", "memberOf"},
+		userSearchFilter(cfg, username),
+		[]string{"dn", groupAttribute(cfg)},
 		nil,
 	)
 
 	sr, err := l.Search(searchRequest)
 	if err != nil {
-		return fmt.Errorf("errore ricerca utente: %w", err)
+		return "", nil, fmt.Errorf("errore ricerca utente: %w", err)
 	}
 
 	if len(sr.Entries) == 0 {
-		return fmt.Errorf("utente non trovato: %s", username)
+		return "", nil, fmt.Errorf("%w: utente non trovato: %s", errCredentialsRejected, username)
+	}
+	if len(sr.Entries) > 1 {
+		return "", nil, fmt.Errorf("la ricerca dell'utente %s restituisce %d voci: verificare ad.user_attribute e ad.user_filter", username, len(sr.Entries))
 	}
 
 	userDN := sr.Entries[0].DN
-	userGroups := sr.Entries[0].GetAttributeValues("memberOf")
+	userGroups := sr.Entries[0].GetEqualFoldAttributeValues(groupAttribute(cfg))
 
-	// Bind con credenziali utente per autenticazione
-	if err := l.Bind(userDN, password); err != nil {
-		return fmt.Errorf("credenziali invalide per utente %s", username)
-	}
-
-	// Verifica appartenenza a gruppi autorizzati
-	authorized := false
-	for _, allowedGroup := range cfg.AD.AllowedGroups {
-		for _, userGroup := range userGroups {
-			// Controllo case-insensitive del CN del gruppo
-			if strings.Contains(strings.ToLower(userGroup), strings.ToLower(allowedGroup)) {
-				authorized = true
-				log.WithFields(logrus.Fields{
-					"username":      username,
-					"matched_group": allowedGroup,
-				}).Debug("Utente autorizzato tramite gruppo")
-				break
-			}
-		}
-		if authorized {
-			break
+	// Gruppi annidati, risolti con il service account prima del bind utente
+	if cfg.AD.NestedGroups {
+		nested, err := searchNestedGroups(cfg, l, userDN)
+		if err != nil {
+			return "", nil, fmt.Errorf("errore risoluzione gruppi annidati: %w", err)
 		}
+		userGroups = mergeGroups(userGroups, nested)
 	}
 
-	if !authorized {
-		return fmt.Errorf("utente %s non appartiene a nessun gruppo autorizzato", username)
-	}
-
-	return nil
+	return userDN, userGroups, nil
 }
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// defaultLDAPPoolSize è il numero di connessioni inattive mantenute per default
+const defaultLDAPPoolSize = 4
+
+// ldapPool mantiene connessioni LDAP aperte da riusare tra le autenticazioni,
+// evitando dial e handshake a ogni richiesta. Le connessioni vengono sempre
+// riassociate al service account prima dell'uso.
+type ldapPool struct {
+	dial func() (*ldap.Conn, error)
+	size int
+
+	mutex  sync.Mutex
+	idle   []*ldap.Conn
+	closed bool
+}
+
+func newLDAPPool(size int, dial func() (*ldap.Conn, error)) *ldapPool {
+	if size <= 0 {
+		size = defaultLDAPPoolSize
+	}
+	return &ldapPool{dial: dial, size: size}
+}
+
+// get restituisce una connessione inattiva o ne apre una nuova; reused indica
+// se proviene dal pool (e potrebbe essere stata chiusa dal server)
+func (p *ldapPool) get() (conn *ldap.Conn, reused bool, err error) {
+	p.mutex.Lock()
+	for len(p.idle) > 0 {
+		conn = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !conn.IsClosing() {
+			p.mutex.Unlock()
+			return conn, true, nil
+		}
+		conn.Close()
+	}
+	p.mutex.Unlock()
+
+	conn, err = p.dial()
+	return conn, false, err
+}
+
+// put restituisce la connessione al pool; quelle con errori di rete o in
+// eccesso vengono chiuse
+func (p *ldapPool) put(conn *ldap.Conn, healthy bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !healthy || p.closed || len(p.idle) >= p.size || conn.IsClosing() {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+}
+
+// close chiude le connessioni inattive; quelle in uso vengono chiuse al rilascio
+func (p *ldapPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.closed = true
+	for _, conn := range p.idle {
+		conn.Close()
+	}
+	p.idle = nil
+}
@@ -1,8 +1,11 @@
 package auth
 
 import (
+	"crypto/tls"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/fzanti/aiconnect/internal/config"
@@ -306,3 +309,225 @@ func TestLDAPAuthMiddleware_ADEnabledNilPointer(t *testing.T) {
 		t.Errorf("Expected status 401, got %d", rr.Code)
 	}
 }
+
+// testDirectory returns the entries shared by the LDAP stand-in tests
+func testDirectory() []testLDAPEntry {
+	return []testLDAPEntry{
+		{DN: "CN=svc,OU=Service,DC=example,DC=com", Password: "svc-pass"},
+		{
+			DN:       "CN=Alice,OU=Users,DC=example,DC=com",
+			Password: "alice-pass",
+			Attrs: map[string][]string{
+				"sAMAccountName": {"alice"},
+				"memberOf":       {"CN=AI-Users,OU=Groups,DC=example,DC=com"},
+			},
+		},
+	}
+}
+
+func newDirectoryTestConfig(url string) *config.Config {
+	cfg := &config.Config{}
+	cfg.AD.Enabled = boolPtr(true)
+	cfg.AD.LDAPURL = url
+	cfg.AD.BindDN = "CN=svc,OU=Service,DC=example,DC=com"
+	cfg.AD.BindPassword = "svc-pass"
+	cfg.AD.BaseDN = "DC=example,DC=com"
+	cfg.AD.AllowedGroups = []string{"CN=AI-Users"}
+	cfg.AD.CacheTTL = -1
+	cfg.AD.NegativeCacheTTL = -1
+	return cfg
+}
+
+func newDirectoryTestAuthenticator(cfg *config.Config) *LDAPAuthenticator {
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+	return NewLDAPAuthenticator(cfg, log, nil)
+}
+
+func TestLDAPAuthenticator_Directory(t *testing.T) {
+	server := newTestLDAPServer(t, nil, testDirectory()...)
+	a := newDirectoryTestAuthenticator(newDirectoryTestConfig(server.URL("ldap")))
+	defer a.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.Authenticate("alice", "alice-pass"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if _, err := a.Authenticate("alice", "wrong"); !errors.Is(err, errCredentialsRejected) {
+		t.Errorf("Expected rejected credentials, got %v", err)
+	}
+	if _, err := a.Authenticate("bob", "alice-pass"); !errors.Is(err, errCredentialsRejected) {
+		t.Errorf("Expected unknown user to be rejected, got %v", err)
+	}
+
+	// Connections are reused: the service account is rebound, the TCP connection is not redialed
+	if dials, binds := server.counts(); dials != 1 || binds != 9 {
+		t.Errorf("Expected 1 dial and 9 binds, got %d dials and %d binds", dials, binds)
+	}
+
+	// A closed pooled connection is replaced transparently
+	a.mutex.RLock()
+	for _, conn := range a.pool.idle {
+		conn.Close()
+	}
+	a.mutex.RUnlock()
+	if _, err := a.Authenticate("alice", "alice-pass"); err != nil {
+		t.Fatalf("Unexpected error after connection loss: %v", err)
+	}
+}
+
+func TestLDAPAuthenticator_StartTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := newTestLDAPServer(t, pki.serverTLS(false), testDirectory()...)
+
+	cfg := newDirectoryTestConfig(server.URL("ldap"))
+	cfg.AD.StartTLS = true
+	cfg.AD.CAFile = pki.CAFile
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The internal CA is required to trust the server
+	cfg = newDirectoryTestConfig(server.URL("ldap"))
+	cfg.AD.StartTLS = true
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err == nil || errors.Is(err, errCredentialsRejected) {
+		t.Errorf("Expected TLS verification error without ca_file, got %v", err)
+	}
+
+	// The server name override must match the certificate
+	cfg = newDirectoryTestConfig(server.URL("ldap"))
+	cfg.AD.StartTLS = true
+	cfg.AD.CAFile = pki.CAFile
+	cfg.AD.ServerName = "other.test"
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err == nil {
+		t.Error("Expected TLS verification error for mismatched server_name")
+	}
+	cfg.AD.ServerName = "ldap.test"
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err != nil {
+		t.Errorf("Unexpected error with server_name: %v", err)
+	}
+}
+
+func TestLDAPAuthenticator_LDAPS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := newTestLDAPSServer(t, pki.serverTLS(true), testDirectory()...)
+
+	cfg := newDirectoryTestConfig(server.URL("ldaps"))
+	cfg.AD.CAFile = pki.CAFile
+	cfg.AD.ClientCert = pki.ClientCert
+	cfg.AD.ClientKey = pki.ClientKey
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The server requires a client certificate
+	cfg.AD.ClientCert, cfg.AD.ClientKey = "", ""
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err == nil {
+		t.Error("Expected handshake failure without client certificate")
+	}
+
+	// A server limited to TLS 1.2 is refused when 1.3 is required
+	serverTLS := pki.serverTLS(false)
+	serverTLS.MaxVersion = tls.VersionTLS12
+	server = newTestLDAPSServer(t, serverTLS, testDirectory()...)
+	cfg = newDirectoryTestConfig(server.URL("ldaps"))
+	cfg.AD.CAFile = pki.CAFile
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err != nil {
+		t.Fatalf("Unexpected error with TLS 1.2: %v", err)
+	}
+	cfg.AD.MinTLSVersion = "1.3"
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("alice", "alice-pass"); err == nil {
+		t.Error("Expected handshake failure below min_tls_version")
+	}
+}
+
+// inChain resolves LDAP_MATCHING_RULE_IN_CHAIN on "member" for the stand-in
+func inChain(server *testLDAPServer) func(entry testLDAPEntry, attr, rule, value string) bool {
+	var isMember func(group testLDAPEntry, dn string, depth int) bool
+	isMember = func(group testLDAPEntry, dn string, depth int) bool {
+		for _, m := range entryValues(group, "member") {
+			if strings.EqualFold(m, dn) {
+				return true
+			}
+			if sub, ok := server.find(m); ok && depth < 10 && isMember(sub, dn, depth+1) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(entry testLDAPEntry, attr, rule, value string) bool {
+		return strings.EqualFold(attr, "member") && rule == matchingRuleInChain && isMember(entry, value, 0)
+	}
+}
+
+func TestLDAPAuthenticator_NestedGroups(t *testing.T) {
+	entries := []testLDAPEntry{
+		{DN: "CN=svc,OU=Service,DC=example,DC=com", Password: "svc-pass"},
+		{
+			DN:       "CN=Bob,OU=Users,DC=example,DC=com",
+			Password: "bob-pass",
+			Attrs: map[string][]string{
+				"sAMAccountName": {"bob"},
+				"memberOf":       {"CN=Team-A,OU=Groups,DC=example,DC=com", "CN=AI-Users-Disabled,OU=Groups,DC=example,DC=com"},
+			},
+		},
+		{
+			DN:    "CN=Team-A,OU=Groups,DC=example,DC=com",
+			Attrs: map[string][]string{"member": {"CN=Bob,OU=Users,DC=example,DC=com"}},
+		},
+		{
+			DN:    "CN=AI-Users,OU=Groups,DC=example,DC=com",
+			Attrs: map[string][]string{"member": {"CN=Team-A,OU=Groups,DC=example,DC=com"}},
+		},
+	}
+	server := newTestLDAPServer(t, nil, entries...)
+	server.extensible = inChain(server)
+
+	// Direct membership only: AI-Users-Disabled must not satisfy AI-Users
+	cfg := newDirectoryTestConfig(server.URL("ldap"))
+	cfg.AD.AllowedGroups = []string{"CN=AI-Users,OU=Groups,DC=example,DC=com"}
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("bob", "bob-pass"); err == nil {
+		t.Error("Expected bob to be rejected without nested group resolution")
+	}
+
+	cfg.AD.NestedGroups = true
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("bob", "bob-pass"); err != nil {
+		t.Errorf("Expected bob to be authorized through Team-A, got %v", err)
+	}
+}
+
+func TestLDAPAuthenticator_OpenLDAPSchema(t *testing.T) {
+	server := newTestLDAPServer(t, nil,
+		testLDAPEntry{DN: "cn=admin,dc=example,dc=org", Password: "admin-pass"},
+		testLDAPEntry{
+			DN:       "uid=carol,ou=people,dc=example,dc=org",
+			Password: "carol-pass",
+			Attrs: map[string][]string{
+				"uid":         {"carol"},
+				"objectClass": {"inetOrgPerson"},
+				"memberOf":    {"cn=ai-users,ou=groups,dc=example,dc=org"},
+			},
+		},
+		// Same uid, but not a person: excluded by user_filter
+		testLDAPEntry{
+			DN:    "uid=carol,ou=services,dc=example,dc=org",
+			Attrs: map[string][]string{"uid": {"carol"}, "objectClass": {"account"}},
+		},
+	)
+
+	cfg := newDirectoryTestConfig(server.URL("ldap"))
+	cfg.AD.BindDN = "cn=admin,dc=example,dc=org"
+	cfg.AD.BindPassword = "admin-pass"
+	cfg.AD.BaseDN = "dc=example,dc=org"
+	cfg.AD.AllowedGroups = []string{"ai-users"}
+	cfg.AD.UserAttribute = "uid"
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("carol", "carol-pass"); err == nil || errors.Is(err, errCredentialsRejected) {
+		t.Errorf("Expected an ambiguous search error, got %v", err)
+	}
+
+	cfg.AD.UserFilter = "(objectClass=inetOrgPerson)"
+	if _, err := newDirectoryTestAuthenticator(cfg).Authenticate("carol", "carol-pass"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testLDAPEntry is a directory object served by testLDAPServer
+type testLDAPEntry struct {
+	DN       string
+	Password string // empty: bind not allowed
+	Attrs    map[string][]string
+}
+
+// testLDAPServer is a minimal in-process LDAP server: simple bind, subtree
+// search with and/or/not/equality/present filters, StartTLS and LDAPS. It is
+// enough to exercise the client side without a real directory.
+type testLDAPServer struct {
+	t        *testing.T
+	listener net.Listener
+	tls      *tls.Config // StartTLS; nil disables the extended operation
+
+	mutex   sync.Mutex
+	entries []testLDAPEntry
+	dials   int
+	binds   int
+	// extensible, if set, evaluates extensible match filters (attr, rule, value)
+	extensible func(entry testLDAPEntry, attr, rule, value string) bool
+}
+
+// newTestLDAPServer starts a plain ldap:// server; startTLS enables the
+// StartTLS operation with serverTLS
+func newTestLDAPServer(t *testing.T, serverTLS *tls.Config, entries ...testLDAPEntry) *testLDAPServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return startTestLDAPServer(t, l, serverTLS, entries)
+}
+
+// newTestLDAPSServer starts an ldaps:// server
+func newTestLDAPSServer(t *testing.T, serverTLS *tls.Config, entries ...testLDAPEntry) *testLDAPServer {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return startTestLDAPServer(t, l, nil, entries)
+}
+
+func startTestLDAPServer(t *testing.T, l net.Listener, serverTLS *tls.Config, entries []testLDAPEntry) *testLDAPServer {
+	s := &testLDAPServer{t: t, listener: l, tls: serverTLS, entries: entries}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+// URL returns the server address with the given scheme
+func (s *testLDAPServer) URL(scheme string) string {
+	return scheme + "://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) counts() (dials, binds int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dials, s.binds
+}
+
+func (s *testLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.dials++
+		s.mutex.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *testLDAPServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := string(op.Children[1].Data.Bytes())
+			password := string(op.Children[2].Data.Bytes())
+			s.mutex.Lock()
+			s.binds++
+			s.mutex.Unlock()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if entry, ok := s.find(dn); ok && entry.Password != "" && entry.Password == password {
+				code = ldap.LDAPResultSuccess
+			}
+			writeLDAPResult(conn, id, ldap.ApplicationBindResponse, code)
+
+		case ldap.ApplicationSearchRequest:
+			base := strings.ToLower(string(op.Children[0].Data.Bytes()))
+			filter := op.Children[6]
+			var attrs []string
+			for _, a := range op.Children[7].Children {
+				attrs = append(attrs, string(a.Data.Bytes()))
+			}
+			s.mutex.Lock()
+			entries := append([]testLDAPEntry(nil), s.entries...)
+			s.mutex.Unlock()
+			for _, e := range entries {
+				if strings.HasSuffix(strings.ToLower(e.DN), base) && s.match(e, filter) {
+					writeLDAPEntry(conn, id, e, attrs)
+				}
+			}
+			writeLDAPResult(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+
+		case ldap.ApplicationExtendedRequest:
+			name := string(op.Children[0].Data.Bytes())
+			if name != "1.3.6.1.4.1.1466.20037" || s.tls == nil {
+				writeLDAPResult(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError)
+				continue
+			}
+			writeLDAPResult(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+
+		case ldap.ApplicationUnbindRequest:
+			return
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) find(dn string) (testLDAPEntry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) {
+			return e, true
+		}
+	}
+	return testLDAPEntry{}, false
+}
+
+// match evaluates an encoded search filter against an entry
+func (s *testLDAPServer) match(e testLDAPEntry, f *ber.Packet) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !s.match(e, c) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if s.match(e, c) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !s.match(e, f.Children[0])
+	case ldap.FilterEqualityMatch:
+		attr := string(f.Children[0].Data.Bytes())
+		value := string(f.Children[1].Data.Bytes())
+		for _, v := range entryValues(e, attr) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(entryValues(e, string(f.Data.Bytes()))) > 0
+	case ldap.FilterExtensibleMatch:
+		var attr, rule, value string
+		for _, c := range f.Children {
+			switch c.Tag {
+			case 1:
+				rule = string(c.Data.Bytes())
+			case 2:
+				attr = string(c.Data.Bytes())
+			case 3:
+				value = string(c.Data.Bytes())
+			}
+		}
+		return s.extensible != nil && s.extensible(e, attr, rule, value)
+	}
+	return false
+}
+
+// entryValues returns the values of an attribute; "objectClass" defaults to top
+func entryValues(e testLDAPEntry, attr string) []string {
+	if strings.EqualFold(attr, "distinguishedName") {
+		return []string{e.DN}
+	}
+	for k, v := range e.Attrs {
+		if strings.EqualFold(k, attr) {
+			return v
+		}
+	}
+	if strings.EqualFold(attr, "objectClass") {
+		return []string{"top"}
+	}
+	return nil
+}
+
+func writeLDAPResult(conn net.Conn, id int64, tag ber.Tag, code uint16) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	packet.AppendChild(res)
+	conn.Write(packet.Bytes())
+}
+
+func writeLDAPEntry(conn net.Conn, id int64, e testLDAPEntry, attrs []string) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "objectName"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for _, a := range attrs {
+		values := entryValues(e, a)
+		if len(values) == 0 {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attr.AppendChild(set)
+		list.AppendChild(attr)
+	}
+	res.AppendChild(list)
+	packet.AppendChild(res)
+	conn.Write(packet.Bytes())
+}
+
+// testPKI is a throwaway CA with a server certificate for 127.0.0.1/localhost
+// and a client certificate, written as PEM files in a temp dir
+type testPKI struct {
+	CAFile     string
+	ClientCert string
+	ClientKey  string
+	CAPool     *x509.CertPool
+	Server     tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "AIConnect Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		if usage == x509.ExtKeyUsageServerAuth {
+			tmpl.DNSNames = []string{"localhost", "ldap.test"}
+			tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("create certificate: %v", err)
+		}
+		return der, key
+	}
+
+	pki := &testPKI{CAPool: x509.NewCertPool()}
+	pki.CAPool.AddCert(caCert)
+	pki.CAFile = writeTestPEM(t, dir, "ca.pem", "CERTIFICATE", caDER)
+
+	serverDER, serverKey := issue(2, "ldap.test", x509.ExtKeyUsageServerAuth)
+	pki.Server = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+
+	clientDER, clientKey := issue(3, "aiconnect", x509.ExtKeyUsageClientAuth)
+	keyDER, _ := x509.MarshalECPrivateKey(clientKey)
+	pki.ClientCert = writeTestPEM(t, dir, "client.pem", "CERTIFICATE", clientDER)
+	pki.ClientKey = writeTestPEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+	return pki
+}
+
+// serverTLS returns a server configuration; with requireClient the client
+// certificate is mandatory and must be issued by the test CA
+func (p *testPKI) serverTLS(requireClient bool) *tls.Config {
+	cfg := &tls.Config{Certificates: []tls.Certificate{p.Server}}
+	if requireClient {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = p.CAPool
+	}
+	return cfg
+}
+
+func writeTestPEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return file
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+// Valori usati quando la configurazione non passa da config.Load
+const (
+	defaultMaxFailures   = 5
+	defaultIPMaxFailures = 20
+	defaultLockout       = 30 * time.Second
+	defaultMaxLockout    = 15 * time.Minute
+	defaultFailureWindow = 15 * time.Minute
+)
+
+// maxLimiterEntries limita la memoria usata con username sempre diversi: oltre
+// questa soglia i nuovi username non vengono tracciati, il limite per IP resta
+const maxLimiterEntries = 100000
+
+// limiterSettings sono le soglie correnti della protezione
+type limiterSettings struct {
+	maxFailures   int
+	ipMaxFailures int
+	lockout       time.Duration
+	maxLockout    time.Duration
+	window        time.Duration
+}
+
+// failureEntry conta i login falliti di uno username o di un IP
+type failureEntry struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimiter blocca temporaneamente username e IP client dopo troppi login
+// falliti, prima che i tentativi arrivino ad AD e ne blocchino gli account.
+// Ogni errore oltre la soglia raddoppia la durata del blocco (backoff
+// esponenziale) fino al massimo configurato. Un LoginLimiter nil non blocca nulla.
+type LoginLimiter struct {
+	now func() time.Time
+
+	mutex    sync.Mutex
+	settings limiterSettings
+	entries  map[string]*failureEntry
+	sweptAt  time.Time
+}
+
+// NewLoginLimiter crea la protezione dai login ripetuti; nil se disabilitata
+func NewLoginLimiter(cfg *config.Config) *LoginLimiter {
+	if cfg.LoginProtection.Enabled != nil && !*cfg.LoginProtection.Enabled {
+		return nil
+	}
+	return &LoginLimiter{
+		now:      time.Now,
+		settings: newLimiterSettings(cfg),
+		entries:  make(map[string]*failureEntry),
+	}
+}
+
+func newLimiterSettings(cfg *config.Config) limiterSettings {
+	lp := cfg.LoginProtection
+	s := limiterSettings{
+		maxFailures:   lp.MaxFailures,
+		ipMaxFailures: lp.IPMaxFailures,
+		lockout:       time.Duration(lp.Lockout) * time.Second,
+		maxLockout:    time.Duration(lp.MaxLockout) * time.Second,
+		window:        time.Duration(lp.Window) * time.Second,
+	}
+	if s.maxFailures <= 0 {
+		s.maxFailures = defaultMaxFailures
+	}
+	if s.ipMaxFailures <= 0 {
+		s.ipMaxFailures = defaultIPMaxFailures
+	}
+	if s.lockout <= 0 {
+		s.lockout = defaultLockout
+	}
+	if s.maxLockout <= 0 {
+		s.maxLockout = defaultMaxLockout
+	}
+	if s.maxLockout < s.lockout {
+		s.maxLockout = s.lockout
+	}
+	if s.window <= 0 {
+		s.window = defaultFailureWindow
+	}
+	return s
+}
+
+// Reload applica le nuove soglie mantenendo i conteggi in corso
+func (l *LoginLimiter) Reload(cfg *config.Config) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	l.settings = newLimiterSettings(cfg)
+	l.mutex.Unlock()
+}
+
+func userKey(username string) string { return "user:" + strings.ToLower(username) }
+func ipKey(ip string) string         { return "ip:" + ip }
+
+// Check restituisce per quanto tempo ancora lo username o l'IP sono bloccati;
+// zero se il tentativo è consentito. Username vuoto verifica solo l'IP.
+func (l *LoginLimiter) Check(username, ip string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var wait time.Duration
+	for _, key := range limiterKeys(username, ip) {
+		if e, ok := l.entries[key]; ok && e.lockedUntil.After(now) {
+			if d := e.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// Failure registra un login fallito e restituisce la durata del blocco che ne
+// deriva (zero se la soglia non è ancora raggiunta)
+func (l *LoginLimiter) Failure(username, ip string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	var locked time.Duration
+	if ip != "" {
+		locked = l.fail(ipKey(ip), l.settings.ipMaxFailures, now)
+	}
+	if username != "" {
+		if d := l.fail(userKey(username), l.settings.maxFailures, now); d > locked {
+			locked = d
+		}
+	}
+	return locked
+}
+
+// Success azzera gli errori dello username. Quelli dell'IP restano: un client
+// che prova molti account non deve poterli azzerare con il proprio.
+func (l *LoginLimiter) Success(username string) {
+	if l == nil || username == "" {
+		return
+	}
+	l.mutex.Lock()
+	delete(l.entries, userKey(username))
+	l.mutex.Unlock()
+}
+
+// fail incrementa il conteggio di key. Va chiamato con il mutex acquisito.
+func (l *LoginLimiter) fail(key string, threshold int, now time.Time) time.Duration {
+	e, ok := l.entries[key]
+	if !ok {
+		if len(l.entries) >= maxLimiterEntries {
+			return 0
+		}
+		e = &failureEntry{}
+		l.entries[key] = e
+	}
+	if l.expired(e, now) {
+		*e = failureEntry{}
+	}
+	e.failures++
+	e.last = now
+	if e.failures < threshold {
+		return 0
+	}
+
+	lockout := l.settings.lockout
+	for i := threshold; i < e.failures && lockout < l.settings.maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.settings.maxLockout {
+		lockout = l.settings.maxLockout
+	}
+	e.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// expired indica se gli errori di una voce sono abbastanza vecchi da essere dimenticati
+func (l *LoginLimiter) expired(e *failureEntry, now time.Time) bool {
+	return !e.lockedUntil.After(now) && now.Sub(e.last) > l.settings.window
+}
+
+// sweep rimuove le voci scadute al più una volta al minuto. Va chiamato con il
+// mutex acquisito.
+func (l *LoginLimiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < time.Minute {
+		return
+	}
+	l.sweptAt = now
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+func limiterKeys(username, ip string) []string {
+	var keys []string
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	if username != "" {
+		keys = append(keys, userKey(username))
+	}
+	return keys
+}
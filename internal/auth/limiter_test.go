@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestLimiter(maxFailures, ipMaxFailures int) (*LoginLimiter, *time.Time) {
+	cfg := &config.Config{}
+	cfg.LoginProtection.MaxFailures = maxFailures
+	cfg.LoginProtection.IPMaxFailures = ipMaxFailures
+	cfg.LoginProtection.Lockout = 10
+	cfg.LoginProtection.MaxLockout = 60
+	cfg.LoginProtection.Window = 300
+
+	now := time.Unix(1700000000, 0)
+	l := NewLoginLimiter(cfg)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLoginLimiter_Backoff(t *testing.T) {
+	l, now := newTestLimiter(3, 100)
+
+	for i := 0; i < 2; i++ {
+		if d := l.Failure("alice", "10.0.0.1"); d != 0 {
+			t.Fatalf("Failure %d: expected no lockout below threshold, got %v", i+1, d)
+		}
+	}
+	if d := l.Failure("Alice", "10.0.0.1"); d != 10*time.Second {
+		t.Fatalf("Expected 10s lockout at threshold (usernames are case-insensitive), got %v", d)
+	}
+	if d := l.Check("alice", "10.0.0.2"); d != 10*time.Second {
+		t.Errorf("Expected user locked from any IP, got %v", d)
+	}
+	if d := l.Check("bob", "10.0.0.1"); d != 0 {
+		t.Errorf("Expected other users on the same IP unaffected, got %v", d)
+	}
+
+	// Each further failure doubles the lockout up to the maximum
+	*now = now.Add(11 * time.Second)
+	if d := l.Check("alice", ""); d != 0 {
+		t.Errorf("Expected lockout expired, got %v", d)
+	}
+	for _, want := range []time.Duration{20 * time.Second, 40 * time.Second, 60 * time.Second, 60 * time.Second} {
+		if d := l.Failure("alice", "10.0.0.1"); d != want {
+			t.Errorf("Expected %v lockout, got %v", want, d)
+		}
+	}
+
+	// Failures are forgotten after the window without new errors
+	*now = now.Add(61*time.Second + 5*time.Minute)
+	if d := l.Failure("alice", "10.0.0.1"); d != 0 {
+		t.Errorf("Expected counter reset after the window, got %v", d)
+	}
+
+	// A successful login resets the username but not the IP
+	l.Success("alice")
+	if d := l.Failure("alice", "10.0.0.1"); d != 0 {
+		t.Errorf("Expected counter reset after success, got %v", d)
+	}
+}
+
+func TestLoginLimiter_PerIP(t *testing.T) {
+	l, _ := newTestLimiter(100, 3)
+
+	for _, user := range []string{"u1", "u2", "u3"} {
+		l.Failure(user, "192.0.2.7")
+	}
+	if d := l.Check("u4", "192.0.2.7"); d != 10*time.Second {
+		t.Errorf("Expected IP locked after spraying usernames, got %v", d)
+	}
+	if d := l.Check("u4", "192.0.2.8"); d != 0 {
+		t.Errorf("Expected other IPs unaffected, got %v", d)
+	}
+
+	var disabled *LoginLimiter
+	disabled.Failure("u1", "192.0.2.7")
+	if d := disabled.Check("u1", "192.0.2.7"); d != 0 {
+		t.Errorf("Expected nil limiter to allow everything, got %v", d)
+	}
+}
+
+func TestAuthMiddleware_Lockout(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AD.Enabled = boolPtr(true)
+	cfg.AD.AllowedGroups = []string{"ai-users"}
+	cfg.AD.NegativeCacheTTL = -1 // every wrong password reaches the lookup
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	ldapAuth := NewLDAPAuthenticator(cfg, log, nil)
+	binds := 0
+	ldapAuth.lookup = func(_ *config.Config, _ *ldapPool, username, password string) ([]string, error) {
+		binds++
+		if password != "right" {
+			return nil, errCredentialsRejected
+		}
+		return []string{"CN=ai-users,OU=Groups,DC=example,DC=com"}, nil
+	}
+
+	limiter, _ := newTestLimiter(2, 100)
+	handler := AuthMiddleware(cfg, log, Authenticators{LDAP: ldapAuth, Limiter: limiter})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.RemoteAddr = "10.0.0.1:51234"
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:"+password)))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := serve("wrong"); rr.Code != http.StatusForbidden {
+			t.Fatalf("Attempt %d: expected 403, got %d", i+1, rr.Code)
+		}
+	}
+	rr := serve("right")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "10" {
+		t.Fatalf("Expected 429 with Retry-After 10, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if binds != 2 {
+		t.Errorf("Expected locked attempt not to reach the directory, got %d binds", binds)
+	}
+}
+
+func TestClientIP_TrustedProxies(t *testing.T) {
+	trusted, err := config.ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct client", "192.0.2.7:51234", nil, "192.0.2.7"},
+		{"untrusted peer ignores header", "192.0.2.7:51234", []string{"198.51.100.1"}, "192.0.2.7"},
+		{"ingress", "10.0.0.5:51234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client-supplied entries skipped", "10.0.0.5:51234", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1"},
+		{"chained proxies", "10.0.0.5:51234", []string{"198.51.100.1", "10.1.2.3"}, "198.51.100.1"},
+		{"IPv6 proxy", "[2001:db8::1]:443", []string{"2001:db8::99"}, "2001:db8::99"},
+		{"no header", "10.0.0.5:51234", nil, "10.0.0.5"},
+		{"malformed hop", "10.0.0.5:51234", []string{"198.51.100.1, unknown"}, "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.RemoteAddr = tt.remote
+		for _, v := range tt.forwarded {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(req, trusted); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/fzanti/aiconnect/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// Metodi di autenticazione, selezionabili per path con auth.routes
+const (
+	MethodLDAP     = "ldap"     // credenziali AD con Basic Auth
+	MethodAPIKey   = "api_key"  // token Bearer aic_...
+	MethodOIDC     = "oidc"     // token Bearer JWT dell'identity provider
+	MethodMTLS     = "mtls"     // certificato client verificato dal server TLS
+	MethodKerberos = "kerberos" // ticket Kerberos con Authorization: Negotiate (SPNEGO)
+)
+
+// Authenticators raccoglie gli autenticatori disponibili; quelli nil sono disabilitati
+type Authenticators struct {
+	LDAP     *LDAPAuthenticator
+	APIKeys  *KeyStore
+	OIDC     *OIDCAuthenticator
+	Certs    *CertAuthenticator
+	Kerberos *KerberosAuthenticator
+
+	Limiter *LoginLimiter    // blocco dopo login falliti (opzionale)
+	Metrics *metrics.Manager // opzionale
+}
+
+// routeMethods restituisce i metodi accettati per il path secondo la prima
+// regola corrispondente; nil significa tutti i metodi abilitati
+func routeMethods(routes []config.AuthRoute, path string) []string {
+	for _, route := range routes {
+		if strings.HasPrefix(path, route.PathPrefix) {
+			return route.Methods
+		}
+	}
+	return nil
+}
+
+func methodAllowed(methods []string, method string) bool {
+	if methods == nil {
+		return true
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Motivi dei login falliti nella metrica aiconnect_auth_failures_total
+const (
+	reasonMissingCredentials = "missing_credentials"
+	reasonMethodNotAllowed   = "method_not_allowed"
+	reasonMalformed          = "malformed_credentials"
+	reasonInvalidCredentials = "invalid_credentials"
+	reasonInvalidAPIKey      = "invalid_api_key"
+	reasonInvalidToken       = "invalid_token"
+	reasonUnmappedCert       = "unmapped_certificate"
+	reasonInvalidTicket      = "invalid_ticket"
+	reasonGroupDenied        = "group_denied"
+	reasonDirectoryError     = "directory_error"
+	reasonLockedOut          = "locked_out"
+)
+
+// recordAttempt aggiorna le metriche di autenticazione; reason vuoto indica successo
+func (a Authenticators) recordAttempt(reason string) {
+	if a.Metrics == nil {
+		return
+	}
+	a.Metrics.IncrementAuthAttempts(reason == "")
+	if reason != "" {
+		a.Metrics.IncrementAuthFailures(reason)
+	}
+}
+
+// clientIP restituisce l'indirizzo del client senza porta. Se la richiesta
+// arriva da un proxy fidato usa l'ultimo indirizzo di X-Forwarded-For non
+// appartenente a un proxy fidato: quelli più a sinistra li sceglie il client.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trusted) {
+		return host
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if !isTrustedProxy(hop, trusted) {
+			return hop
+		}
+	}
+	return host
+}
+
+// isTrustedProxy indica se l'indirizzo appartiene a login_protection.trusted_proxies
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooManyAttempts risponde 429 indicando dopo quanti secondi riprovare
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+// AuthMiddleware autentica le richieste con credenziali AD (Basic), API key o
+// token JWT (Bearer), ticket Kerberos (Negotiate) o certificato client,
+// limitando i metodi per path secondo auth.routes
+func AuthMiddleware(cfg *config.Config, log *logrus.Logger, authn Authenticators) func(http.Handler) http.Handler {
+	// Già verificati da config.Validate
+	trustedProxies, _ := config.ParseTrustedProxies(cfg.LoginProtection.TrustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// L'identità è solo quella nel contesto: gli header ricevuti dal
+			// client non devono arrivare ai backend né finire nei log
+			StripIdentityHeaders(r.Header)
+
+			// Se nessun metodo di autenticazione è abilitato, passa direttamente
+			adEnabled := cfg.AD.Enabled == nil || *cfg.AD.Enabled
+			if !adEnabled && !cfg.APIKeys.Enabled && !cfg.OIDC.Enabled && !cfg.MTLS.Enabled && !cfg.Kerberos.Enabled {
+				log.WithField("path", r.URL.Path).Debug("Nessun metodo di autenticazione abilitato, accesso consentito")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Controlla se il path è pubblico (accessibile senza autenticazione)
+			if isPublicPath(r.URL.Path, cfg.AD.PublicPaths) {
+				log.WithField("path", r.URL.Path).Debug("Path pubblico, accesso senza autenticazione")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			methods := routeMethods(cfg.Auth.Routes, r.URL.Path)
+			negotiate := authn.Kerberos != nil && methodAllowed(methods, MethodKerberos)
+			unauthorized := func() {
+				// I browser nel dominio tentano il single sign-on solo se invitati
+				if negotiate {
+					w.Header().Set("WWW-Authenticate", "Negotiate")
+				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}
+
+			// Certificato client già verificato dal server TLS con mtls.ca_file
+			authHeader := r.Header.Get("Authorization")
+			if authn.Certs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && methodAllowed(methods, MethodMTLS) {
+				cert := r.TLS.VerifiedChains[0][0]
+				id, err := authn.Certs.Authenticate(cert)
+				if err == nil {
+					r = r.WithContext(WithIdentity(r.Context(), id))
+					authn.recordAttempt("")
+					log.WithFields(logrus.Fields{
+						"username": id.User,
+						"subject":  cert.Subject.String(),
+					}).Info("Autenticazione con certificato client riuscita")
+					next.ServeHTTP(w, r)
+					return
+				}
+				// Senza altre credenziali il certificato è l'unica identità offerta
+				log.WithError(err).Warn("Certificato client non associato a un'identità")
+				if authHeader == "" {
+					authn.recordAttempt(reasonUnmappedCert)
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			// Estrai credenziali dall'header Authorization
+			if authHeader == "" {
+				log.Warn("Richiesta senza header Authorization")
+				authn.recordAttempt(reasonMissingCredentials)
+				unauthorized()
+				return
+			}
+
+			rejectMethod := func(method string) {
+				log.WithFields(logrus.Fields{
+					"path":   r.URL.Path,
+					"method": method,
+				}).Warn("Metodo di autenticazione non abilitato per il path")
+				authn.recordAttempt(reasonMethodNotAllowed)
+				unauthorized()
+			}
+
+			if strings.HasPrefix(authHeader, "Bearer ") {
+				token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+
+				// API key personale o di servizio
+				if strings.HasPrefix(token, APIKeyPrefix) {
+					if authn.APIKeys == nil || !methodAllowed(methods, MethodAPIKey) {
+						rejectMethod(MethodAPIKey)
+						return
+					}
+					key, err := authn.APIKeys.Authenticate(token)
+					if err != nil {
+						log.WithError(err).Warn("Autenticazione con API key fallita")
+						authn.recordAttempt(reasonInvalidAPIKey)
+						http.Error(w, "Unauthorized", http.StatusUnauthorized)
+						return
+					}
+
+					// Le chiavi personali valgono finché il titolare resta
+					// autorizzato in AD; i suoi gruppi servono alle policy
+					id := &Identity{User: key.Owner, Method: MethodAPIKey}
+					if key.OwnerType == OwnerUser && adEnabled && authn.LDAP != nil {
+						groups, err := authn.LDAP.Groups(key.Owner)
+						switch {
+						case errors.Is(err, errCredentialsRejected), errors.Is(err, errGroupDenied):
+							log.WithError(err).WithField("key_id", key.ID).Warn("Autorizzazione con API key fallita")
+							authn.recordAttempt(reasonGroupDenied)
+							http.Error(w, "Forbidden", http.StatusForbidden)
+							return
+						case err != nil:
+							log.WithError(err).WithField("key_id", key.ID).Error("Errore lettura gruppi per titolare API key")
+							authn.recordAttempt(reasonDirectoryError)
+							http.Error(w, "Forbidden", http.StatusForbidden)
+							return
+						}
+						id.Groups = groups
+					}
+
+					ctx := WithIdentity(r.Context(), id)
+					if len(key.Scopes) > 0 {
+						ctx = WithScopes(ctx, key.Scopes)
+					}
+					r = r.WithContext(ctx)
+					authn.recordAttempt("")
+
+					log.WithFields(logrus.Fields{
+						"username": key.Owner,
+						"key_id":   key.ID,
+					}).Info("Autenticazione con API key riuscita")
+
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				// Token JWT dell'identity provider
+				if authn.OIDC == nil || !methodAllowed(methods, MethodOIDC) {
+					rejectMethod(MethodOIDC)
+					return
+				}
+				claims, err := authn.OIDC.Authenticate(token)
+				if errors.Is(err, errGroupDenied) {
+					log.WithError(err).Warn("Autorizzazione con token JWT fallita")
+					authn.recordAttempt(reasonGroupDenied)
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				if err != nil {
+					log.WithError(err).Warn("Autenticazione con token JWT fallita")
+					authn.recordAttempt(reasonInvalidToken)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				r = r.WithContext(WithIdentity(r.Context(), &Identity{
+					User:   claims.Username,
+					Groups: claims.Groups,
+					Method: MethodOIDC,
+				}))
+				authn.recordAttempt("")
+
+				log.WithField("username", claims.Username).Info("Autenticazione con token JWT riuscita")
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Ticket Kerberos dei client nel dominio (SPNEGO)
+			if strings.HasPrefix(authHeader, "Negotiate ") {
+				if !negotiate {
+					rejectMethod(MethodKerberos)
+					return
+				}
+				id, err := authn.Kerberos.Authenticate(strings.TrimSpace(strings.TrimPrefix(authHeader, "Negotiate ")), r.RemoteAddr)
+				switch {
+				case errors.Is(err, ErrInvalidTicket):
+					log.WithError(err).Warn("Autenticazione Kerberos fallita")
+					authn.recordAttempt(reasonInvalidTicket)
+					unauthorized()
+					return
+				case errors.Is(err, errCredentialsRejected), errors.Is(err, errGroupDenied):
+					log.WithError(err).Warn("Autorizzazione Kerberos fallita")
+					authn.recordAttempt(reasonGroupDenied)
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				case err != nil:
+					log.WithError(err).Error("Errore lettura gruppi per utente Kerberos")
+					authn.recordAttempt(reasonDirectoryError)
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+
+				r = r.WithContext(WithIdentity(r.Context(), id))
+				authn.recordAttempt("")
+
+				log.WithField("username", id.User).Info("Autenticazione Kerberos riuscita")
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Verifica che sia Basic Auth
+			if !strings.HasPrefix(authHeader, "Basic ") {
+				log.Warn("Tipo autenticazione non supportato")
+				authn.recordAttempt(reasonMalformed)
+				unauthorized()
+				return
+			}
+			if !adEnabled || authn.LDAP == nil || !methodAllowed(methods, MethodLDAP) {
+				rejectMethod(MethodLDAP)
+				return
+			}
+
+			// Decodifica credenziali Base64
+			encoded := strings.TrimPrefix(authHeader, "Basic ")
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				log.WithError(err).Warn("Errore decodifica credenziali")
+				authn.recordAttempt(reasonMalformed)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// Separa username e password
+			credentials := strings.SplitN(string(decoded), ":", 2)
+			if len(credentials) != 2 {
+				log.Warn("Formato credenziali invalido")
+				authn.recordAttempt(reasonMalformed)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			username := credentials[0]
+			password := credentials[1]
+
+			// Username o IP bloccati: il tentativo non arriva ad AD
+			ip := clientIP(r, trustedProxies)
+			if wait := authn.Limiter.Check(username, ip); wait > 0 {
+				log.WithFields(logrus.Fields{
+					"username":    username,
+					"client_ip":   ip,
+					"retry_after": wait.Round(time.Second).String(),
+				}).Warn("Login bloccato dopo troppi tentativi falliti")
+				authn.recordAttempt(reasonLockedOut)
+				tooManyAttempts(w, wait)
+				return
+			}
+
+			// Autentica contro AD e verifica gruppi
+			groups, err := authn.LDAP.Authenticate(username, password)
+			if err != nil {
+				fields := logrus.Fields{
+					"username":  username,
+					"client_ip": ip,
+					"error":     err.Error(),
+				}
+				switch {
+				case errors.Is(err, errCredentialsRejected):
+					// Solo le credenziali errate contano per il blocco
+					if lockout := authn.Limiter.Failure(username, ip); lockout > 0 {
+						fields["lockout"] = lockout.String()
+					}
+					authn.recordAttempt(reasonInvalidCredentials)
+				case errors.Is(err, errGroupDenied):
+					authn.Limiter.Success(username)
+					authn.recordAttempt(reasonGroupDenied)
+				default:
+					authn.recordAttempt(reasonDirectoryError)
+				}
+				log.WithFields(fields).Warn("Autenticazione o autorizzazione fallita")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			authn.Limiter.Success(username)
+			authn.recordAttempt("")
+
+			// Aggiungi l'identità al contesto per autorizzazione e audit
+			r = r.WithContext(WithIdentity(r.Context(), &Identity{
+				User:   username,
+				Groups: groups,
+				Method: MethodLDAP,
+			}))
+
+			log.WithField("username", username).Info("Autenticazione e autorizzazione riuscita")
+
+			// Passa al prossimo handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
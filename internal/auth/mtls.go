@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrCertUnmapped indica un certificato valido senza identità associata
+var ErrCertUnmapped = errors.New("certificato client senza identità associata")
+
+// certRule è una regola mtls.identities con il soggetto già interpretato
+type certRule struct {
+	config.CertIdentity
+	subject *ldap.DN
+}
+
+// CertAuthenticator associa i certificati client verificati dal server TLS
+// alle identità configurate in mtls.identities
+type CertAuthenticator struct {
+	mutex sync.RWMutex
+	rules []certRule
+}
+
+// NewCertAuthenticator crea l'autenticatore dalle regole già validate
+func NewCertAuthenticator(cfg *config.Config) (*CertAuthenticator, error) {
+	a := &CertAuthenticator{}
+	if err := a.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload sostituisce le regole di associazione
+func (a *CertAuthenticator) Reload(cfg *config.Config) error {
+	rules := make([]certRule, len(cfg.MTLS.Identities))
+	for i, id := range cfg.MTLS.Identities {
+		rules[i] = certRule{CertIdentity: id}
+		if id.Subject == "" {
+			continue
+		}
+		dn, err := ldap.ParseDN(id.Subject)
+		if err != nil {
+			return fmt.Errorf("mtls.identities[%d].subject non valido: %w", i, err)
+		}
+		rules[i].subject = dn
+	}
+
+	a.mutex.Lock()
+	a.rules = rules
+	a.mutex.Unlock()
+	return nil
+}
+
+// Authenticate restituisce l'identità del certificato secondo la prima regola
+// corrispondente. La catena deve essere già stata verificata dal server TLS.
+func (a *CertAuthenticator) Authenticate(cert *x509.Certificate) (*Identity, error) {
+	a.mutex.RLock()
+	rules := a.rules
+	a.mutex.RUnlock()
+
+	subject, _ := ldap.ParseDN(cert.Subject.String())
+	for _, rule := range rules {
+		if !rule.matches(cert, subject) {
+			continue
+		}
+		user := rule.User
+		if user == "" {
+			user = cert.Subject.CommonName
+		}
+		if user == "" {
+			return nil, fmt.Errorf("certificato %s senza CN: indicare user nella regola", cert.Subject)
+		}
+		return &Identity{User: user, Groups: rule.Groups, Method: MethodMTLS}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCertUnmapped, cert.Subject)
+}
+
+func (r certRule) matches(cert *x509.Certificate, subject *ldap.DN) bool {
+	switch {
+	case r.subject != nil:
+		return subject != nil && r.subject.EqualFold(subject)
+	case r.DNSName != "":
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, r.DNSName) {
+				return true
+			}
+		}
+	case r.URI != "":
+		for _, u := range cert.URIs {
+			if u.String() == r.URI {
+				return true
+			}
+		}
+	case r.Email != "":
+		for _, email := range cert.EmailAddresses {
+			if strings.EqualFold(email, r.Email) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCertAuthenticator_Mapping(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.MTLS.Identities = []config.CertIdentity{
+		{Subject: "cn=rag,ou=Services,o=Corp", Groups: []string{"ai-services"}},
+		{DNSName: "ci.corp.local", User: "ci-runner"},
+		{URI: "spiffe://corp.local/batch", User: "batch", Groups: []string{"batch"}},
+		{Email: "Ops@corp.local"},
+	}
+	a, err := NewCertAuthenticator(cfg)
+	if err != nil {
+		t.Fatalf("NewCertAuthenticator: %v", err)
+	}
+	spiffe, _ := url.Parse("spiffe://corp.local/batch")
+
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		user   string
+		groups int
+	}{
+		{"subject", &x509.Certificate{Subject: pkix.Name{CommonName: "rag", OrganizationalUnit: []string{"Services"}, Organization: []string{"Corp"}}}, "rag", 1},
+		{"dns SAN", &x509.Certificate{Subject: pkix.Name{CommonName: "ci"}, DNSNames: []string{"CI.corp.local"}}, "ci-runner", 0},
+		{"uri SAN", &x509.Certificate{URIs: []*url.URL{spiffe}}, "batch", 1},
+		{"email SAN", &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}, EmailAddresses: []string{"ops@corp.local"}}, "ops", 0},
+	}
+	for _, tt := range tests {
+		id, err := a.Authenticate(tt.cert)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if id.User != tt.user || len(id.Groups) != tt.groups || id.Method != MethodMTLS {
+			t.Errorf("%s: unexpected identity %+v", tt.name, id)
+		}
+	}
+
+	// Another subject under the same OU is not the same identity
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "rag-old", OrganizationalUnit: []string{"Services"}, Organization: []string{"Corp"}}}
+	if _, err := a.Authenticate(other); !errors.Is(err, ErrCertUnmapped) {
+		t.Errorf("Expected ErrCertUnmapped, got %v", err)
+	}
+}
+
+func TestAuthMiddleware_ClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	cfg := &config.Config{}
+	cfg.AD.Enabled = boolPtr(false)
+	cfg.MTLS.Enabled = true
+	cfg.MTLS.Identities = []config.CertIdentity{{Subject: "CN=aiconnect", Groups: []string{"ai-services"}}}
+	cfg.Auth.Routes = []config.AuthRoute{{PathPrefix: "/openai/", Methods: []string{MethodLDAP}}}
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	certs, _ := NewCertAuthenticator(cfg)
+	server := httptest.NewUnstartedServer(AuthMiddleware(cfg, log, Authenticators{Certs: certs})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := IdentityFromContext(r.Context())
+		io.WriteString(w, id.User+" "+id.Method)
+	})))
+	server.TLS = pki.serverTLS(false)
+	server.TLS.ClientAuth = tls.VerifyClientCertIfGiven
+	server.TLS.ClientCAs = pki.CAPool
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	clientCert, err := tls.LoadX509KeyPair(pki.ClientCert, pki.ClientKey)
+	if err != nil {
+		t.Fatalf("load client certificate: %v", err)
+	}
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pki.CAPool,
+			ServerName:   "localhost",
+			Certificates: certs,
+		}}}
+	}
+
+	get := func(client *http.Client, path string) (int, string) {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get(newClient(clientCert), "/v1/models"); code != http.StatusOK || body != "aiconnect mtls" {
+		t.Errorf("Expected authentication by certificate, got %d %q", code, body)
+	}
+	if code, _ := get(newClient(), "/v1/models"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without certificate, got %d", code)
+	}
+	// The route accepts only LDAP, so the certificate is ignored
+	if code, _ := get(newClient(clientCert), "/openai/v1/models"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 on LDAP-only route, got %d", code)
+	}
+
+	cfg.MTLS.Identities = []config.CertIdentity{{Subject: "CN=someone-else"}}
+	certs.Reload(cfg)
+	if code, _ := get(newClient(clientCert), "/v1/models"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for unmapped certificate, got %d", code)
+	}
+}
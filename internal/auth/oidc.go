@@ -0,0 +1,547 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 per RS256/ES256/PS256
+	_ "crypto/sha512" // SHA-384/512 per RS384/ES384/PS384 e successivi
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Errori di verifica dei token JWT
+var (
+	ErrInvalidToken = errors.New("token JWT non valido")
+	ErrTokenExpired = errors.New("token JWT scaduto")
+)
+
+// Limiti del client JWKS
+const (
+	oidcHTTPTimeout   = 10 * time.Second
+	jwksMinRefetch    = 30 * time.Second // intervallo minimo tra due download per kid sconosciuti
+	maxOIDCDocumentSz = 1 << 20
+	defaultClockSkew  = time.Minute
+)
+
+// OIDCClaims sono i dati dell'utente estratti da un token verificato
+type OIDCClaims struct {
+	Subject   string
+	Username  string
+	Groups    []string
+	ExpiresAt time.Time
+}
+
+// OIDCAuthenticator verifica token JWT emessi dall'identity provider
+// configurato. Le chiavi pubbliche (JWKS) sono scaricate alla prima richiesta,
+// tenute in cache e riscaricate alla scadenza o quando arriva un kid sconosciuto
+// (rotazione delle chiavi).
+type OIDCAuthenticator struct {
+	log *logrus.Logger
+
+	mutex  sync.RWMutex
+	cfg    *config.Config
+	client *http.Client
+	keys   *jwksCache
+
+	now func() time.Time
+}
+
+// NewOIDCAuthenticator crea l'autenticatore; non contatta l'identity provider
+// finché non arriva il primo token
+func NewOIDCAuthenticator(cfg *config.Config, log *logrus.Logger) (*OIDCAuthenticator, error) {
+	a := &OIDCAuthenticator{log: log, now: time.Now}
+	if err := a.configure(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// configure prepara client HTTP e cache delle chiavi. Va chiamato con il mutex
+// acquisito in scrittura.
+func (a *OIDCAuthenticator) configure(cfg *config.Config) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.OIDC.CAFile != "" {
+		pool, err := config.LoadCertPool(cfg.OIDC.CAFile)
+		if err != nil {
+			return fmt.Errorf("oidc.ca_file: %w", err)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	ttl := time.Duration(cfg.OIDC.JWKSCacheTTL) * time.Second
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	a.cfg = cfg
+	a.client = &http.Client{Transport: transport, Timeout: oidcHTTPTimeout}
+	a.keys = &jwksCache{
+		client:  a.client,
+		issuer:  strings.TrimSuffix(cfg.OIDC.Issuer, "/"),
+		jwksURL: cfg.OIDC.JWKSURL,
+		ttl:     ttl,
+		log:     a.log,
+
+		minRefetch: jwksMinRefetch,
+	}
+	return nil
+}
+
+// Reload applica una nuova configurazione scartando le chiavi in cache
+func (a *OIDCAuthenticator) Reload(cfg *config.Config) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.configure(cfg)
+}
+
+// Authenticate verifica firma e claim del token e l'appartenenza a un gruppo
+// autorizzato. Gli errori di gruppo sono errGroupDenied, gli altri indicano un
+// token non valido.
+func (a *OIDCAuthenticator) Authenticate(token string) (*OIDCClaims, error) {
+	a.mutex.RLock()
+	cfg, keys := a.cfg, a.keys
+	a.mutex.RUnlock()
+
+	payload, err := verifyJWT(token, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := a.validateClaims(cfg, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := cfg.OIDC.AllowedGroups
+	if len(allowed) == 0 {
+		allowed = cfg.AD.AllowedGroups
+	}
+	if err := authorizeGroups(allowed, a.log, claims.Username, claims.Groups); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateClaims controlla issuer, audience e validità temporale ed estrae utente e gruppi
+func (a *OIDCAuthenticator) validateClaims(cfg *config.Config, payload map[string]interface{}) (*OIDCClaims, error) {
+	if iss, _ := payload["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(cfg.OIDC.Issuer, "/") {
+		return nil, fmt.Errorf("%w: issuer %q inatteso", ErrInvalidToken, iss)
+	}
+	if !audienceContains(payload["aud"], cfg.OIDC.Audience) {
+		return nil, fmt.Errorf("%w: audience non valida", ErrInvalidToken)
+	}
+
+	now := a.now()
+	skew := time.Duration(cfg.OIDC.ClockSkew) * time.Second
+	if skew == 0 {
+		skew = defaultClockSkew
+	}
+	exp, ok := numericDate(payload["exp"])
+	if !ok {
+		return nil, fmt.Errorf("%w: claim exp mancante", ErrInvalidToken)
+	}
+	if now.After(exp.Add(skew)) {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := numericDate(payload["nbf"]); ok && now.Add(skew).Before(nbf) {
+		return nil, fmt.Errorf("%w: token non ancora valido", ErrInvalidToken)
+	}
+
+	claims := &OIDCClaims{ExpiresAt: exp}
+	claims.Subject, _ = payload["sub"].(string)
+	usernameClaim := cfg.OIDC.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+	claims.Username, _ = claimValue(payload, usernameClaim).(string)
+	if claims.Username == "" {
+		claims.Username = claims.Subject
+	}
+	if claims.Username == "" {
+		return nil, fmt.Errorf("%w: nessun claim con l'identità dell'utente", ErrInvalidToken)
+	}
+
+	groupsClaim := cfg.OIDC.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	switch groups := claimValue(payload, groupsClaim).(type) {
+	case string:
+		claims.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				claims.Groups = append(claims.Groups, s)
+			}
+		}
+	}
+	return claims, nil
+}
+
+// claimValue segue un percorso con il punto (es. "realm_access.roles")
+func claimValue(payload map[string]interface{}, path string) interface{} {
+	var value interface{} = payload
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[part]
+	}
+	return value
+}
+
+// audienceContains gestisce aud come stringa o come array
+func audienceContains(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDate converte un claim NumericDate (secondi dall'epoch)
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// jwtHeader è l'header JOSE di un token firmato
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyJWT verifica la firma del token e ne restituisce il payload
+func verifyJWT(token string, keys *jwksCache) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: formato non valido", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	hash, ok := jwtHash(header.Alg)
+	if !ok {
+		// "none" e gli algoritmi simmetrici non sono mai accettati
+		return nil, fmt.Errorf("%w: algoritmo %q non supportato", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: firma non decodificabile", ErrInvalidToken)
+	}
+
+	key, err := keys.get(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, hash, h.Sum(nil), signature); err != nil {
+		return nil, err
+	}
+
+	var payload map[string]interface{}
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: segmento non decodificabile", ErrInvalidToken)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: JSON non valido", ErrInvalidToken)
+	}
+	return nil
+}
+
+// jwtHash restituisce l'hash dell'algoritmo di firma asimmetrico
+func jwtHash(alg string) (crypto.Hash, bool) {
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		return crypto.SHA256, true
+	case "RS384", "PS384", "ES384":
+		return crypto.SHA384, true
+	case "RS512", "PS512", "ES512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+func verifySignature(alg string, key crypto.PublicKey, hash crypto.Hash, digest, signature []byte) error {
+	invalid := fmt.Errorf("%w: firma non valida", ErrInvalidToken)
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return invalid
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(pub, hash, digest, signature, nil)
+		}
+		if err != nil {
+			return invalid
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return invalid
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return invalid
+		}
+	default:
+		return invalid
+	}
+	return nil
+}
+
+// jwksCache scarica e memorizza le chiavi pubbliche dell'identity provider
+type jwksCache struct {
+	client  *http.Client
+	issuer  string
+	jwksURL string // vuoto: ricavato dal discovery
+	ttl     time.Duration
+	log     *logrus.Logger
+
+	// minRefetch limita i download per kid sconosciuti o con l'identity provider irraggiungibile
+	minRefetch time.Duration
+
+	mutex      sync.Mutex
+	keys       map[string]crypto.PublicKey
+	fetchedAt  time.Time
+	triedAt    time.Time
+	fetchErr   error         // esito dell'ultimo download
+	refreshing chan struct{} // chiuso al termine del download in corso
+}
+
+// get restituisce la chiave con il kid indicato, riscaricando il JWKS se
+// scaduto o se il kid non è noto (al massimo una volta ogni jwksMinRefetch).
+// Il download avviene fuori dal lock: con la chiave già nota si continua a
+// verificare con quella, solo i kid sconosciuti attendono il nuovo JWKS.
+func (c *jwksCache) get(kid string) (crypto.PublicKey, error) {
+	c.mutex.Lock()
+	now := time.Now()
+	key, known := c.lookup(kid)
+	stale := now.Sub(c.fetchedAt) >= c.ttl
+	done := c.refreshing
+	if done == nil && (!known || stale) && now.Sub(c.triedAt) >= c.minRefetch {
+		c.triedAt = now
+		done = make(chan struct{})
+		c.refreshing = done
+		go c.refresh(done)
+	}
+	c.mutex.Unlock()
+
+	if known {
+		return key, nil
+	}
+	if done != nil {
+		<-done
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if key, known = c.lookup(kid); known {
+			return key, nil
+		}
+		if c.fetchErr != nil {
+			return nil, c.fetchErr
+		}
+	}
+	return nil, fmt.Errorf("%w: chiave %q sconosciuta", ErrInvalidToken, kid)
+}
+
+// refresh scarica il JWKS e sostituisce le chiavi; in caso di errore restano
+// in uso quelle precedenti
+func (c *jwksCache) refresh(done chan struct{}) {
+	c.mutex.Lock()
+	jwksURL := c.jwksURL
+	c.mutex.Unlock()
+
+	keys, jwksURL, err := c.fetch(jwksURL)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		c.log.WithError(err).Warn("Impossibile aggiornare le chiavi JWKS")
+	} else {
+		c.keys = keys
+		c.fetchedAt = time.Now()
+		// Il discovery va ripetuto solo se fallisce il download: jwks_uri non cambia spesso
+		c.jwksURL = jwksURL
+	}
+	c.fetchErr = err
+	c.refreshing = nil
+	close(done)
+}
+
+// lookup cerca la chiave; senza kid è accettata solo se il JWKS ne ha una sola
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// fetch scarica il JWKS da jwksURL, ricavandolo dal discovery se vuoto, e
+// restituisce le chiavi di firma con l'URL usato
+func (c *jwksCache) fetch(jwksURL string) (map[string]crypto.PublicKey, string, error) {
+	if jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := c.getJSON(c.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, "", fmt.Errorf("errore discovery OIDC: %w", err)
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != c.issuer || discovery.JWKSURI == "" {
+			return nil, "", fmt.Errorf("errore discovery OIDC: issuer %q o jwks_uri non validi", discovery.Issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := c.getJSON(jwksURL, &set); err != nil {
+		return nil, "", fmt.Errorf("errore download JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			c.log.WithError(err).WithField("kid", k.Kid).Debug("Chiave JWKS ignorata")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, "", errors.New("JWKS senza chiavi di firma utilizzabili")
+	}
+	return keys, jwksURL, nil
+}
+
+func (c *jwksCache) getJSON(url string, v interface{}) error {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxOIDCDocumentSz)).Decode(v)
+}
+
+// jwk è una chiave pubblica in formato JSON Web Key (RSA o EC)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("esponente RSA non valido")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curva non supportata: %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("punto EC non valido")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("tipo di chiave non supportato: %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("valore base64url non valido")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// testIssuer is a local identity provider: discovery document, JWKS and token signing
+type testIssuer struct {
+	server *httptest.Server
+
+	mutex     sync.Mutex
+	keys      map[string]crypto.Signer
+	jwksHits  int
+	discovery int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	iss := &testIssuer{keys: make(map[string]crypto.Signer)}
+	iss.addRSAKey("rsa-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		iss.mutex.Lock()
+		iss.discovery++
+		iss.mutex.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   iss.server.URL,
+			"jwks_uri": iss.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		iss.mutex.Lock()
+		defer iss.mutex.Unlock()
+		iss.jwksHits++
+
+		var keys []map[string]string
+		for kid, signer := range iss.keys {
+			switch pub := signer.Public().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "RSA", "kid": kid, "use": "sig",
+					"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+				})
+			case *ecdsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "EC", "kid": kid, "crv": "P-256",
+					"x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32))),
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+func (iss *testIssuer) addRSAKey(kid string) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	iss.mutex.Lock()
+	iss.keys[kid] = key
+	iss.mutex.Unlock()
+}
+
+func (iss *testIssuer) addECKey(kid string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	iss.mutex.Lock()
+	iss.keys[kid] = key
+	iss.mutex.Unlock()
+}
+
+func (iss *testIssuer) hits() (discovery, jwks int) {
+	iss.mutex.Lock()
+	defer iss.mutex.Unlock()
+	return iss.discovery, iss.jwksHits
+}
+
+// claims returns a valid claim set for alice, to be adjusted by each test
+func (iss *testIssuer) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                iss.server.URL,
+		"aud":                []string{"aiconnect", "other"},
+		"sub":                "0f3c2a",
+		"preferred_username": "alice",
+		"groups":             []string{"ai-users"},
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+	}
+}
+
+// sign creates a compact JWS with the key identified by kid
+func (iss *testIssuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	iss.mutex.Lock()
+	signer := iss.keys[kid]
+	iss.mutex.Unlock()
+
+	alg := "RS256"
+	if _, ok := signer.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newOIDCTestConfig(issuer string) *config.Config {
+	cfg := &config.Config{}
+	cfg.AD.Enabled = boolPtr(false)
+	cfg.OIDC.Enabled = true
+	cfg.OIDC.Issuer = issuer
+	cfg.OIDC.Audience = "aiconnect"
+	cfg.OIDC.AllowedGroups = []string{"ai-users"}
+	return cfg
+}
+
+func newOIDCTestAuthenticator(t *testing.T, cfg *config.Config) *OIDCAuthenticator {
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+	a, err := NewOIDCAuthenticator(cfg, log)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return a
+}
+
+func TestOIDCAuthenticator_ValidToken(t *testing.T) {
+	iss := newTestIssuer(t)
+	iss.addECKey("ec-1")
+	a := newOIDCTestAuthenticator(t, newOIDCTestConfig(iss.server.URL))
+
+	for _, kid := range []string{"rsa-1", "ec-1"} {
+		claims, err := a.Authenticate(iss.sign(t, kid, iss.claims()))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", kid, err)
+		}
+		if claims.Username != "alice" || claims.Subject != "0f3c2a" || len(claims.Groups) != 1 {
+			t.Errorf("%s: unexpected claims %+v", kid, claims)
+		}
+	}
+
+	// Discovery and JWKS are fetched once and cached
+	if discovery, jwks := iss.hits(); discovery != 1 || jwks != 1 {
+		t.Errorf("Expected one discovery and one JWKS download, got %d and %d", discovery, jwks)
+	}
+}
+
+func TestOIDCAuthenticator_RejectsInvalidTokens(t *testing.T) {
+	iss := newTestIssuer(t)
+	a := newOIDCTestAuthenticator(t, newOIDCTestConfig(iss.server.URL))
+
+	tests := []struct {
+		name   string
+		mutate func(c map[string]interface{})
+		want   error
+	}{
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-5 * time.Minute).Unix() }, ErrTokenExpired},
+		{"missing exp", func(c map[string]interface{}) { delete(c, "exp") }, ErrInvalidToken},
+		{"not yet valid", func(c map[string]interface{}) { c["nbf"] = time.Now().Add(10 * time.Minute).Unix() }, ErrInvalidToken},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "someone-else" }, ErrInvalidToken},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, ErrInvalidToken},
+		{"group not allowed", func(c map[string]interface{}) { c["groups"] = []string{"ai-users-disabled"} }, errGroupDenied},
+		{"no groups", func(c map[string]interface{}) { delete(c, "groups") }, errGroupDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := iss.claims()
+			tt.mutate(claims)
+			if _, err := a.Authenticate(iss.sign(t, "rsa-1", claims)); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// Expiry within the clock skew is tolerated
+	claims := iss.claims()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	if _, err := a.Authenticate(iss.sign(t, "rsa-1", claims)); err != nil {
+		t.Errorf("Expected token within clock skew to be accepted, got %v", err)
+	}
+
+	// Tampered payload
+	token := iss.sign(t, "rsa-1", iss.claims())
+	parts := strings.Split(token, ".")
+	forged := iss.claims()
+	forged["preferred_username"] = "admin"
+	payload, _ := json.Marshal(forged)
+	if _, err := a.Authenticate(parts[0] + "." + b64(payload) + "." + parts[2]); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected invalid signature, got %v", err)
+	}
+
+	// Unsigned token
+	header := b64([]byte(`{"alg":"none","kid":"rsa-1"}`))
+	if _, err := a.Authenticate(header + "." + parts[1] + "."); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected alg none to be rejected, got %v", err)
+	}
+}
+
+func TestOIDCAuthenticator_KeyRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	a := newOIDCTestAuthenticator(t, newOIDCTestConfig(iss.server.URL))
+	a.keys.minRefetch = 0
+
+	if _, err := a.Authenticate(iss.sign(t, "rsa-1", iss.claims())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A token signed with a new key triggers a JWKS refresh
+	iss.addRSAKey("rsa-2")
+	if _, err := a.Authenticate(iss.sign(t, "rsa-2", iss.claims())); err != nil {
+		t.Fatalf("Expected rotated key to be fetched, got %v", err)
+	}
+	if _, jwks := iss.hits(); jwks != 2 {
+		t.Errorf("Expected 2 JWKS downloads, got %d", jwks)
+	}
+
+	// Unknown keys are refetched at most once per interval
+	a.keys.minRefetch = time.Hour
+	iss.addRSAKey("rsa-3")
+	for i := 0; i < 3; i++ {
+		if _, err := a.Authenticate(iss.sign(t, "rsa-3", iss.claims())); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Expected unknown key error, got %v", err)
+		}
+	}
+	if _, jwks := iss.hits(); jwks != 2 {
+		t.Errorf("Expected no further JWKS downloads, got %d", jwks)
+	}
+}
+
+func TestOIDCAuthenticator_RefreshDoesNotBlock(t *testing.T) {
+	iss := newTestIssuer(t)
+	a := newOIDCTestAuthenticator(t, newOIDCTestConfig(iss.server.URL))
+	a.keys.minRefetch = 0
+
+	if _, err := a.Authenticate(iss.sign(t, "rsa-1", iss.claims())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The JWKS endpoint hangs once the cache has expired
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	a.keys.mutex.Lock()
+	a.keys.jwksURL = slow.URL
+	a.keys.fetchedAt = time.Now().Add(-2 * time.Hour)
+	a.keys.mutex.Unlock()
+
+	// Tokens signed with a known key are verified while the refresh runs
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := a.Authenticate(iss.sign(t, "rsa-1", iss.claims())); err != nil {
+			t.Fatalf("Expected cached key to be used during refresh, got %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected verification without waiting for the JWKS download, took %v", elapsed)
+	}
+}
+
+func TestOIDCAuthenticator_NestedGroupsClaim(t *testing.T) {
+	iss := newTestIssuer(t)
+	cfg := newOIDCTestConfig(iss.server.URL)
+	cfg.OIDC.GroupsClaim = "realm_access.roles"
+	cfg.OIDC.UsernameClaim = "email"
+	cfg.OIDC.JWKSURL = iss.server.URL + "/keys"
+	a := newOIDCTestAuthenticator(t, cfg)
+
+	claims := iss.claims()
+	delete(claims, "groups")
+	claims["email"] = "alice@example.com"
+	claims["realm_access"] = map[string]interface{}{"roles": []string{"offline_access", "AI-Users"}}
+	got, err := a.Authenticate(iss.sign(t, "rsa-1", claims))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.Username != "alice@example.com" {
+		t.Errorf("Expected username from email claim, got %q", got.Username)
+	}
+
+	// With an explicit jwks_url discovery is skipped
+	if discovery, _ := iss.hits(); discovery != 0 {
+		t.Errorf("Expected no discovery request, got %d", discovery)
+	}
+}
+
+func TestAuthMiddleware_RouteMethods(t *testing.T) {
+	iss := newTestIssuer(t)
+	cfg := newOIDCTestConfig(iss.server.URL)
+	cfg.AD.Enabled = boolPtr(true)
+	cfg.Auth.Routes = []config.AuthRoute{
+		{PathPrefix: "/v1/", Methods: []string{MethodOIDC}},
+		{PathPrefix: "/ollama/", Methods: []string{MethodLDAP}},
+	}
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	ldapAuth := NewLDAPAuthenticator(cfg, log, nil)
+	ldapAuth.lookup = func(_ *config.Config, _ *ldapPool, username, password string) ([]string, error) {
+		return []string{"CN=ai-users,OU=Groups,DC=example,DC=com"}, nil
+	}
+	cfg.AD.AllowedGroups = []string{"ai-users"}
+
+	var gotUser string
+	handler := AuthMiddleware(cfg, log, Authenticators{
+		LDAP: ldapAuth,
+		OIDC: newOIDCTestAuthenticator(t, cfg),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = UserFromContext(r.Context())
+	}))
+
+	jwt := "Bearer " + iss.sign(t, "rsa-1", iss.claims())
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:secret"))
+	denied := iss.claims()
+	denied["groups"] = []string{"guests"}
+
+	tests := []struct {
+		path   string
+		header string
+		want   int
+		user   string
+	}{
+		{"/v1/chat/completions", jwt, http.StatusOK, "alice"},
+		{"/v1/chat/completions", basic, http.StatusUnauthorized, ""},
+		{"/v1/chat/completions", "Bearer " + iss.sign(t, "rsa-1", denied), http.StatusForbidden, ""},
+		{"/v1/chat/completions", "Bearer not-a-jwt", http.StatusUnauthorized, ""},
+		{"/ollama/api/chat", basic, http.StatusOK, "bob"},
+		{"/ollama/api/chat", jwt, http.StatusUnauthorized, ""},
+		// No rule: every enabled method is accepted
+		{"/vllm/v1/models", jwt, http.StatusOK, "alice"},
+		{"/vllm/v1/models", basic, http.StatusOK, "bob"},
+		// API keys are not configured
+		{"/vllm/v1/models", "Bearer aic_0123_secret", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		gotUser = ""
+		req := httptest.NewRequest("POST", tt.path, nil)
+		req.Header.Set("Authorization", tt.header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.want || gotUser != tt.user {
+			t.Errorf("%s with %.12s: expected %d as %q, got %d as %q", tt.path, tt.header, tt.want, tt.user, rr.Code, gotUser)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+// PolicyRequest descrive l'accesso da autorizzare. Backend e modello vuoti
+// indicano richieste che non li coinvolgono (es. elenchi di modelli): i limiti
+// corrispondenti non vengono applicati.
+type PolicyRequest struct {
+	User    string
+	Groups  []string
+	Path    string
+	Backend string
+	Model   string
+}
+
+// PolicyDecision è l'esito della valutazione
+type PolicyDecision struct {
+	Allowed bool
+	Policy  string // policy che ha consentito l'accesso
+	Reason  string // motivo del rifiuto, mostrato al client
+}
+
+// PolicyEngine valuta le policy di autorizzazione per gruppo
+type PolicyEngine struct {
+	policies []config.Policy
+}
+
+// NewPolicyEngine crea il motore dalle policy già validate da config.Validate
+func NewPolicyEngine(policies []config.Policy) *PolicyEngine {
+	named := make([]config.Policy, len(policies))
+	for i, p := range policies {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policies[%d]", i)
+		}
+		named[i] = p
+	}
+	return &PolicyEngine{policies: named}
+}
+
+// Enabled indica se ci sono policy da applicare
+func (e *PolicyEngine) Enabled() bool {
+	return e != nil && len(e.policies) > 0
+}
+
+// Authorize consente la richiesta se almeno una policy applicabile all'utente
+// ammette path, backend e modello. Senza policy tutto è consentito.
+func (e *PolicyEngine) Authorize(req PolicyRequest) PolicyDecision {
+	if !e.Enabled() {
+		return PolicyDecision{Allowed: true}
+	}
+
+	// Il motivo del rifiuto è il primo vincolo che nessuna policy soddisfa
+	var applicable, pathOK, backendOK bool
+	for _, p := range e.policies {
+		if !appliesTo(p, req.User, req.Groups) {
+			continue
+		}
+		applicable = true
+		if !matchPrefix(p.PathPrefixes, req.Path) {
+			continue
+		}
+		pathOK = true
+		if !matchBackend(p.Backends, req.Backend) {
+			continue
+		}
+		backendOK = true
+		if !matchModel(p.Models, req.Model) {
+			continue
+		}
+		return PolicyDecision{Allowed: true, Policy: p.Name}
+	}
+
+	switch {
+	case !applicable:
+		return PolicyDecision{Reason: "no access policy applies to your account"}
+	case !pathOK:
+		return PolicyDecision{Reason: fmt.Sprintf("path %s is not allowed for your groups", req.Path)}
+	case !backendOK:
+		return PolicyDecision{Reason: fmt.Sprintf("backend %s is not allowed for your groups", req.Backend)}
+	}
+	return PolicyDecision{Reason: fmt.Sprintf("model %q on backend %s is not allowed for your groups", req.Model, req.Backend)}
+}
+
+// appliesTo indica se la policy riguarda l'utente
+func appliesTo(p config.Policy, user string, groups []string) bool {
+	for _, u := range p.Users {
+		if user != "" && strings.EqualFold(u, user) {
+			return true
+		}
+	}
+	for _, allowed := range p.Groups {
+		if allowed == "*" && user != "" {
+			return true
+		}
+		for _, g := range groups {
+			if groupMatches(allowed, g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPrefix(prefixes []string, p string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchBackend(backends []string, backend string) bool {
+	if len(backends) == 0 || backend == "" {
+		return true
+	}
+	for _, b := range backends {
+		if b == backend {
+			return true
+		}
+	}
+	return false
+}
+
+func matchModel(patterns []string, model string) bool {
+	if len(patterns) == 0 || model == "" {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, model); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+func TestPolicyEngine_Authorize(t *testing.T) {
+	// Without policies every authenticated request is allowed
+	var none *PolicyEngine
+	if d := none.Authorize(PolicyRequest{User: "alice"}); !d.Allowed {
+		t.Error("Expected nil engine to allow everything")
+	}
+	if d := NewPolicyEngine(nil).Authorize(PolicyRequest{Backend: "openai", Model: "gpt-4o"}); !d.Allowed {
+		t.Error("Expected empty engine to allow everything")
+	}
+
+	e := NewPolicyEngine([]config.Policy{
+		{Groups: []string{"AI-Users"}, Backends: []string{"ollama", "vllm"}},
+		{Name: "research", Groups: []string{"Research"}, Models: []string{"gpt-4*", "o1*"}},
+	})
+	research := []string{"CN=Research,OU=Groups,DC=example,DC=com"}
+
+	d := e.Authorize(PolicyRequest{User: "bob", Groups: research, Backend: "openai", Model: "o1-mini"})
+	if !d.Allowed || d.Policy != "research" {
+		t.Errorf("Expected research policy to allow o1-mini, got %+v", d)
+	}
+	d = e.Authorize(PolicyRequest{User: "bob", Groups: []string{"CN=AI-Users,DC=example,DC=com"}, Backend: "vllm", Model: "llama3"})
+	if !d.Allowed || d.Policy != "policies[0]" {
+		t.Errorf("Expected unnamed policy to allow vLLM, got %+v", d)
+	}
+
+	// Requests without a model (listings) are only checked on path and backend
+	if d := e.Authorize(PolicyRequest{User: "bob", Groups: research, Backend: "openai"}); !d.Allowed {
+		t.Errorf("Expected listing to be allowed, got %+v", d)
+	}
+
+	// Group names match whole CNs only
+	d = e.Authorize(PolicyRequest{User: "eve", Groups: []string{"CN=AI-Users-Disabled,DC=example,DC=com"}, Backend: "ollama"})
+	if d.Allowed || d.Reason != "no access policy applies to your account" {
+		t.Errorf("Expected no applicable policy, got %+v", d)
+	}
+}
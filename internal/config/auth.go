@@ -0,0 +1,196 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// minSigningKeyLength è la lunghezza minima della chiave HMAC degli header di identità
+const minSigningKeyLength = 32
+
+// validateOIDC verifica la sezione oidc
+func validateOIDC(cfg *Config) error {
+	if !cfg.OIDC.Enabled {
+		return nil
+	}
+	issuer, err := url.Parse(strings.TrimSpace(cfg.OIDC.Issuer))
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+		return errors.New("oidc.issuer obbligatorio (URL http:// o https://)")
+	}
+	if strings.TrimSpace(cfg.OIDC.Audience) == "" {
+		return errors.New("oidc.audience obbligatorio")
+	}
+	if cfg.OIDC.JWKSURL != "" {
+		if u, err := url.Parse(cfg.OIDC.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("oidc.jwks_url non valido: %q", cfg.OIDC.JWKSURL)
+		}
+	}
+	if cfg.OIDC.CAFile != "" {
+		if _, err := LoadCertPool(cfg.OIDC.CAFile); err != nil {
+			return fmt.Errorf("oidc.ca_file: %w", err)
+		}
+	}
+	if len(cfg.OIDC.AllowedGroups) == 0 && len(cfg.AD.AllowedGroups) == 0 {
+		return errors.New("oidc.allowed_groups obbligatorio (oppure ad.allowed_groups)")
+	}
+	if cfg.OIDC.ClockSkew < 0 || cfg.OIDC.JWKSCacheTTL < 0 {
+		return errors.New("oidc.clock_skew e oidc.jwks_cache_ttl non possono essere negativi")
+	}
+	return nil
+}
+
+// validateKerberos verifica keytab e realm; i gruppi richiedono la sezione ad
+func validateKerberos(cfg *Config) error {
+	if !cfg.Kerberos.Enabled {
+		return nil
+	}
+	if cfg.AD.Enabled != nil && !*cfg.AD.Enabled {
+		return errors.New("kerberos richiede ad.enabled: i gruppi degli utenti sono letti da AD")
+	}
+	if strings.TrimSpace(cfg.Kerberos.Realm) == "" {
+		return errors.New("kerberos.realm obbligatorio")
+	}
+	if cfg.Kerberos.Keytab == "" {
+		return errors.New("kerberos.keytab obbligatorio")
+	}
+	if _, err := keytab.Load(cfg.Kerberos.Keytab); err != nil {
+		return fmt.Errorf("kerberos.keytab: %w", err)
+	}
+	if cfg.Kerberos.ClockSkew < 0 {
+		return errors.New("kerberos.clock_skew non può essere negativo")
+	}
+	return nil
+}
+
+// validateMTLS verifica la CA e le regole di associazione dei certificati client
+func validateMTLS(cfg *Config) error {
+	if !cfg.MTLS.Enabled {
+		return nil
+	}
+	if cfg.MTLS.CAFile == "" {
+		return errors.New("mtls.ca_file obbligatorio")
+	}
+	if _, err := LoadCertPool(cfg.MTLS.CAFile); err != nil {
+		return fmt.Errorf("mtls.ca_file: %w", err)
+	}
+	if len(cfg.MTLS.Identities) == 0 {
+		return errors.New("mtls.identities obbligatorio: i certificati senza associazione sono rifiutati")
+	}
+	for i, id := range cfg.MTLS.Identities {
+		criteria := 0
+		for _, v := range []string{id.Subject, id.DNSName, id.URI, id.Email} {
+			if v != "" {
+				criteria++
+			}
+		}
+		if criteria != 1 {
+			return fmt.Errorf("mtls.identities[%d]: indicare uno solo tra subject, dns_name, uri ed email", i)
+		}
+		if id.Subject != "" {
+			if _, err := ldap.ParseDN(id.Subject); err != nil {
+				return fmt.Errorf("mtls.identities[%d].subject non valido: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateAuthRoutes verifica le regole auth.routes
+func validateAuthRoutes(cfg *Config) error {
+	for i, route := range cfg.Auth.Routes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("auth.routes[%d].path_prefix deve iniziare con /", i)
+		}
+		if len(route.Methods) == 0 {
+			return fmt.Errorf("auth.routes[%d].methods obbligatorio", i)
+		}
+		for _, method := range route.Methods {
+			switch method {
+			case "ldap", "api_key", "oidc", "mtls", "kerberos":
+			default:
+				return fmt.Errorf("auth.routes[%d]: metodo di autenticazione sconosciuto %q (ldap, api_key, oidc, mtls, kerberos)", i, method)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePolicies verifica le policy di autorizzazione
+func validatePolicies(cfg *Config) error {
+	for i, p := range cfg.Policies {
+		name := p.Name
+		if name == "" {
+			name = fmt.Sprintf("policies[%d]", i)
+		}
+		if len(p.Groups) == 0 && len(p.Users) == 0 {
+			return fmt.Errorf("policy %s: indicare almeno un gruppo o un utente", name)
+		}
+		for _, prefix := range p.PathPrefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				return fmt.Errorf("policy %s: path_prefix %q deve iniziare con /", name, prefix)
+			}
+		}
+		for _, backend := range p.Backends {
+			switch backend {
+			case "ollama", "vllm", "openai":
+			default:
+				return fmt.Errorf("policy %s: backend non valido %q (ollama, vllm o openai)", name, backend)
+			}
+		}
+		for _, pattern := range p.Models {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return fmt.Errorf("policy %s: pattern modello non valido %q", name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// validateLoginProtection verifica le soglie della protezione dai login ripetuti
+func validateLoginProtection(cfg *Config) error {
+	lp := cfg.LoginProtection
+	if lp.MaxFailures < 0 || lp.IPMaxFailures < 0 || lp.Lockout < 0 || lp.MaxLockout < 0 || lp.Window < 0 {
+		return errors.New("login_protection: i valori non possono essere negativi")
+	}
+	if lp.MaxLockout > 0 && lp.MaxLockout < lp.Lockout {
+		return fmt.Errorf("login_protection.max_lockout (%d) inferiore a lockout (%d)", lp.MaxLockout, lp.Lockout)
+	}
+	if _, err := ParseTrustedProxies(lp.TrustedProxies); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseTrustedProxies converte login_protection.trusted_proxies in reti; un
+// indirizzo senza prefisso indica il solo host
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("login_protection.trusted_proxies: indirizzo non valido %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("login_protection.trusted_proxies: %w", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -20,8 +22,102 @@ type Config struct {
 		BaseDN        string   `yaml:"base_dn"`
 		AllowedGroups []string `yaml:"allowed_groups"`
 		PublicPaths   []string `yaml:"public_paths"`
+
+		// Cache degli esiti LDAP in secondi (-1 disabilita) e connessioni riusabili
+		CacheTTL         int `yaml:"cache_ttl"`
+		NegativeCacheTTL int `yaml:"negative_cache_ttl"`
+		PoolSize         int `yaml:"pool_size"`
+
+		// TLS verso il server LDAP: ldaps:// nell'URL oppure StartTLS su ldap://
+		StartTLS      bool   `yaml:"start_tls"`
+		CAFile        string `yaml:"ca_file"`         // bundle PEM della CA interna
+		ClientCert    string `yaml:"client_cert"`     // certificato client PEM (opzionale)
+		ClientKey     string `yaml:"client_key"`      // chiave del certificato client
+		ServerName    string `yaml:"server_name"`     // nome atteso nel certificato, se diverso dall'host dell'URL
+		MinTLSVersion string `yaml:"min_tls_version"` // 1.2 (default) o 1.3
+
+		// Ricerca utente e gruppi: i default sono per Active Directory, per
+		// OpenLDAP usare ad esempio user_attribute "uid"
+		UserAttribute  string `yaml:"user_attribute"`  // attributo con lo username (default sAMAccountName)
+		UserFilter     string `yaml:"user_filter"`     // filtro aggiuntivo, es. "(objectClass=person)"
+		GroupAttribute string `yaml:"group_attribute"` // attributo con i DN dei gruppi (default memberOf)
+		NestedGroups   bool   `yaml:"nested_groups"`   // gruppi annidati via LDAP_MATCHING_RULE_IN_CHAIN (solo AD)
 	} `yaml:"ad"`
 
+	// API key personali o di servizio, accettate come token Bearer in alternativa a Basic
+	APIKeys struct {
+		Enabled bool   `yaml:"enabled"`
+		File    string `yaml:"file"` // file JSON con gli hash delle chiavi
+	} `yaml:"api_keys"`
+
+	// Token JWT (access token) emessi dall'identity provider OIDC, accettati come
+	// Bearer; i gruppi del claim indicato sono confrontati con allowed_groups
+	OIDC struct {
+		Enabled       bool     `yaml:"enabled"`
+		Issuer        string   `yaml:"issuer"`         // deve coincidere con il claim iss
+		Audience      string   `yaml:"audience"`       // valore atteso nel claim aud
+		JWKSURL       string   `yaml:"jwks_url"`       // default: jwks_uri dal discovery dell'issuer
+		CAFile        string   `yaml:"ca_file"`        // CA dell'identity provider, se interna
+		UsernameClaim string   `yaml:"username_claim"` // default preferred_username (poi sub)
+		GroupsClaim   string   `yaml:"groups_claim"`   // default groups; percorsi annidati con il punto, es. realm_access.roles
+		AllowedGroups []string `yaml:"allowed_groups"` // default: ad.allowed_groups
+		ClockSkew     int      `yaml:"clock_skew"`     // tolleranza su exp e nbf (secondi)
+		JWKSCacheTTL  int      `yaml:"jwks_cache_ttl"` // durata della cache delle chiavi (secondi)
+	} `yaml:"oidc"`
+
+	// Single sign-on Kerberos per i client nel dominio (Authorization: Negotiate):
+	// il ticket è verificato con il keytab del servizio e i gruppi sono letti da
+	// AD con la ricerca LDAP della sezione ad
+	Kerberos struct {
+		Enabled          bool   `yaml:"enabled"`
+		Keytab           string `yaml:"keytab"`            // keytab con la chiave del servizio
+		ServicePrincipal string `yaml:"service_principal"` // es. HTTP/aiconnect.corp.local (default: quello del ticket)
+		Realm            string `yaml:"realm"`             // realm degli utenti accettati, es. CORP.LOCAL
+		ClockSkew        int    `yaml:"clock_skew"`        // tolleranza sull'orario dei ticket (secondi)
+	} `yaml:"kerberos"`
+
+	// Certificati client (mTLS) emessi dalla PKI interna: il server HTTPS li
+	// richiede senza imporli e quelli verificati sono associati a un'identità
+	// secondo identities
+	MTLS struct {
+		Enabled    bool           `yaml:"enabled"`
+		CAFile     string         `yaml:"ca_file"` // CA che emettono i certificati client
+		Identities []CertIdentity `yaml:"identities"`
+	} `yaml:"mtls"`
+
+	// Metodi di autenticazione accettati per path: le regole sono valutate in
+	// ordine, senza corrispondenze valgono tutti i metodi abilitati
+	Auth struct {
+		Routes []AuthRoute `yaml:"routes"`
+	} `yaml:"auth"`
+
+	// Protezione dai tentativi di login ripetuti: dopo max_failures errori per
+	// username (ip_max_failures per IP client) le richieste ricevono 429 per
+	// lockout secondi, raddoppiati a ogni ulteriore errore fino a max_lockout
+	LoginProtection struct {
+		Enabled       *bool `yaml:"enabled"` // default true
+		MaxFailures   int   `yaml:"max_failures"`
+		IPMaxFailures int   `yaml:"ip_max_failures"`
+		Lockout       int   `yaml:"lockout"`
+		MaxLockout    int   `yaml:"max_lockout"`
+		Window        int   `yaml:"window"` // secondi senza errori dopo cui il conteggio si azzera
+		// IP o CIDR dei reverse proxy (es. ingress davanti alla modalità
+		// plain): per le loro richieste l'IP client è letto da X-Forwarded-For
+		TrustedProxies []string `yaml:"trusted_proxies"`
+	} `yaml:"login_protection"`
+
+	// Policy di autorizzazione per gruppo, valutate dopo l'autenticazione: una
+	// richiesta è consentita se almeno una policy dell'utente la ammette.
+	// Senza policy ogni utente autenticato accede a tutto.
+	Policies []Policy `yaml:"policies"`
+
+	// Identità autenticata inoltrata ai backend negli header X-Forwarded-*; con
+	// signing_key gli header sono firmati (HMAC-SHA256) e i backend raggiungibili
+	// anche direttamente possono verificarli
+	IdentityHeaders struct {
+		SigningKey string `yaml:"signing_key"` // almeno 32 caratteri
+	} `yaml:"identity_headers"`
+
 	Backends struct {
 		OllamaServers  []string `yaml:"ollama_servers"`
 		VLLMServers    []string `yaml:"vllm_servers"`
@@ -29,10 +125,62 @@ type Config struct {
 		OpenAIAPIKey   string   `yaml:"openai_api_key"`
 	} `yaml:"backends"`
 
+	// Routing dell'endpoint unificato /v1: le regole sono valutate in ordine,
+	// poi si consultano gli inventari live dei pool
+	Routing struct {
+		ModelBackends []ModelRoute `yaml:"model_backends"`
+		// OllamaNative serve le richieste /v1 verso Ollama traducendole nell'API nativa
+		OllamaNative bool `yaml:"ollama_native"`
+	} `yaml:"routing"`
+
+	// Strategia di load balancing per pool
+	LoadBalancing struct {
+		Ollama BalancerConfig `yaml:"ollama"`
+		VLLM   BalancerConfig `yaml:"vllm"`
+	} `yaml:"load_balancing"`
+
+	// Retry trasparente verso un altro server del pool (Ollama/vLLM) in caso di
+	// errore di connessione o risposta 502/503, finché nulla è stato inviato al client
+	Retry struct {
+		MaxAttempts  int   `yaml:"max_attempts"`   // tentativi totali, 1 disabilita il retry
+		MaxBodyBytes int64 `yaml:"max_body_bytes"` // limite dei body in memoria: oltre, 413 sulle richieste di inferenza e nessun retry sulle altre
+	} `yaml:"retry"`
+
+	// Server HTTPS: certificati ottenuti via ACME (Let's Encrypt o una CA
+	// privata), letti da file (static) oppure HTTP in chiaro (plain) dietro un
+	// ingress che termina TLS
 	HTTPS struct {
-		Domain   string `yaml:"domain"`
-		CacheDir string `yaml:"cache_dir"`
-		Port     int    `yaml:"port"`
+		Mode     string   `yaml:"mode"`      // acme (default), static o plain
+		Domain   string   `yaml:"domain"`    // nome principale del servizio
+		Domains  []string `yaml:"domains"`   // nomi aggiuntivi (SAN) per cui ottenere certificati ACME
+		CacheDir string   `yaml:"cache_dir"` // certificati e account ACME
+		Port     int      `yaml:"port"`      // default 443, 8080 in modalità plain
+		CertFile string   `yaml:"cert_file"` // static: catena PEM, riletta quando cambia
+		KeyFile  string   `yaml:"key_file"`  // static: chiave privata PEM
+
+		// Directory ACME privata (es. step-ca, Smallstep, Vault) per le reti
+		// senza accesso a Let's Encrypt
+		ACME struct {
+			DirectoryURL string `yaml:"directory_url"` // default Let's Encrypt
+			Email        string `yaml:"email"`         // contatto dell'account
+			CAFile       string `yaml:"ca_file"`       // CA del server ACME, se interna
+			EABKeyID     string `yaml:"eab_key_id"`    // External Account Binding, se richiesto dalla CA
+			EABHMACKey   string `yaml:"eab_hmac_key"`  // chiave HMAC dell'EAB in base64url
+		} `yaml:"acme"`
+
+		// Listener HTTP in chiaro: risponde alle challenge ACME HTTP-01 e
+		// reindirizza tutto il resto su HTTPS
+		HTTPListener struct {
+			Enabled bool `yaml:"enabled"`
+			Port    int  `yaml:"port"` // default 80
+		} `yaml:"http_listener"`
+
+		// Header Strict-Transport-Security sulle risposte del server principale
+		HSTS struct {
+			MaxAge            int  `yaml:"max_age"` // secondi, 0 disabilita l'header
+			IncludeSubdomains bool `yaml:"include_subdomains"`
+			Preload           bool `yaml:"preload"`
+		} `yaml:"hsts"`
 	} `yaml:"https"`
 
 	Monitoring struct {
@@ -40,6 +188,12 @@ type Config struct {
 		MetricsPort         int `yaml:"metrics_port"`
 	} `yaml:"monitoring"`
 
+	// Arresto ordinato su SIGTERM o SIGINT: le nuove connessioni sono rifiutate
+	// e le richieste in corso, compresi gli stream, attese fino a drain_timeout
+	Shutdown struct {
+		DrainTimeout int `yaml:"drain_timeout"` // secondi, poi le connessioni rimaste vengono chiuse
+	} `yaml:"shutdown"`
+
 	Logging struct {
 		Level  string `yaml:"level"`
 		Format string `yaml:"format"`
@@ -57,20 +211,99 @@ type Config struct {
 	} `yaml:"mdns"`
 }
 
-// Load carica la configurazione dal file YAML specificato
+// BalancerConfig configura la selezione dei server di un pool
+type BalancerConfig struct {
+	// weighted_least_load (default), round_robin, least_connections, p2c,
+	// consistent_hash o static_weights
+	Strategy       string         `yaml:"strategy"`
+	InflightWeight float64        `yaml:"inflight_weight"` // peso di ogni richiesta in corso, sommato al carico del polling
+	HashKey        string         `yaml:"hash_key"`        // consistent_hash: user (default) o model
+	Weights        map[string]int `yaml:"weights"`         // static_weights: URL server -> peso (default 1)
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// CircuitBreakerConfig configura il circuit breaker di ogni server del pool,
+// alimentato sia dagli errori del proxy sia dagli health check
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int     `yaml:"consecutive_failures"` // errori consecutivi che aprono il circuito
+	ErrorRateThreshold  float64 `yaml:"error_rate_threshold"` // frazione di errori nella finestra (0-1) che apre il circuito
+	MinRequests         int     `yaml:"min_requests"`         // esiti minimi nella finestra per valutare il tasso
+	Window              int     `yaml:"window"`               // finestra del tasso di errore (secondi)
+	Cooldown            int     `yaml:"cooldown"`             // permanenza in open prima del half-open (secondi)
+	HalfOpenProbes      int     `yaml:"half_open_probes"`     // richieste di prova in half-open, e successi per richiudere
+}
+
+// AuthRoute limita i metodi di autenticazione accettati sotto un prefisso di path
+type AuthRoute struct {
+	PathPrefix string   `yaml:"path_prefix"`
+	Methods    []string `yaml:"methods"` // ldap, api_key, oidc, mtls, kerberos
+}
+
+// CertIdentity associa i certificati client con il soggetto o il SAN indicato
+// (uno solo dei criteri) a un utente e ai suoi gruppi
+type CertIdentity struct {
+	Subject string   `yaml:"subject"`  // DN completo, es. "CN=rag,OU=Services,O=Corp"
+	DNSName string   `yaml:"dns_name"` // SAN DNS
+	URI     string   `yaml:"uri"`      // SAN URI, es. "spiffe://corp.local/rag"
+	Email   string   `yaml:"email"`    // SAN email
+	User    string   `yaml:"user"`     // default: CN del certificato
+	Groups  []string `yaml:"groups"`
+}
+
+// Policy consente a gruppi o utenti l'accesso a path, backend e modelli; le
+// liste vuote non pongono limiti
+type Policy struct {
+	Name         string   `yaml:"name"`
+	Groups       []string `yaml:"groups"`        // DN o nomi di gruppo, "*" per ogni utente autenticato
+	Users        []string `yaml:"users"`         // username o titolari di API key
+	PathPrefixes []string `yaml:"path_prefixes"` // es. "/openai/"
+	Backends     []string `yaml:"backends"`      // ollama, vllm, openai
+	Models       []string `yaml:"models"`        // pattern glob sul nome del modello, es. "gpt-4*"
+}
+
+// ModelRoute associa un pattern di nome modello (glob, es. "gpt-4*") a un backend
+type ModelRoute struct {
+	Pattern string `yaml:"pattern"`
+	Backend string `yaml:"backend"` // ollama, vllm o openai
+}
+
+// Load carica la configurazione dal file YAML specificato. Nei valori sono
+// espansi i riferimenti ${VAR}, i campi *_file sono letti dai file indicati e
+// le variabili AICONNECT_* sovrascrivono i valori del file.
 func Load(path string) (*Config, error) {
+	cfg, _, err := LoadWithSources(path)
+	return cfg, err
+}
+
+// LoadWithSources carica la configurazione come Load e indica l'origine di ogni valore
+func LoadWithSources(path string) (*Config, Sources, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("errore lettura file config: %w", err)
+		return nil, nil, fmt.Errorf("errore lettura file config: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("errore parsing YAML: %w", err)
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("errore parsing YAML: %w", err)
+	sources := make(Sources)
+	if len(doc.Content) > 0 {
+		if err := resolveNode(doc.Content[0], reflect.TypeOf(cfg), "", sources); err != nil {
+			return nil, nil, fmt.Errorf("errore configurazione: %w", err)
+		}
+		if err := doc.Decode(&cfg); err != nil {
+			return nil, nil, fmt.Errorf("errore parsing YAML: %w", err)
+		}
+	}
+	if err := applyEnvOverrides(reflect.ValueOf(&cfg).Elem(), "", sources); err != nil {
+		return nil, nil, fmt.Errorf("errore variabili d'ambiente: %w", err)
 	}
 
 	applyDefaults(&cfg)
-	return &cfg, nil
+	return &cfg, sources, nil
 }
 
 func applyDefaults(cfg *Config) {
@@ -79,8 +312,32 @@ func applyDefaults(cfg *Config) {
 		defaultEnabled := true
 		cfg.AD.Enabled = &defaultEnabled
 	}
+	if cfg.AD.CacheTTL == 0 {
+		cfg.AD.CacheTTL = 300
+	}
+	if cfg.AD.NegativeCacheTTL == 0 {
+		cfg.AD.NegativeCacheTTL = 30
+	}
+	if cfg.AD.PoolSize == 0 {
+		cfg.AD.PoolSize = 4
+	}
+	if cfg.AD.UserAttribute == "" {
+		cfg.AD.UserAttribute = "sAMAccountName"
+	}
+	if cfg.AD.GroupAttribute == "" {
+		cfg.AD.GroupAttribute = "memberOf"
+	}
+	if cfg.HTTPS.Mode == "" {
+		cfg.HTTPS.Mode = HTTPSModeACME
+	}
 	if cfg.HTTPS.Port == 0 {
 		cfg.HTTPS.Port = 443
+		if cfg.HTTPS.Mode == HTTPSModePlain {
+			cfg.HTTPS.Port = 8080
+		}
+	}
+	if cfg.HTTPS.HTTPListener.Port == 0 {
+		cfg.HTTPS.HTTPListener.Port = 80
 	}
 	if cfg.Monitoring.HealthCheckInterval == 0 {
 		cfg.Monitoring.HealthCheckInterval = 30
@@ -88,6 +345,79 @@ func applyDefaults(cfg *Config) {
 	if cfg.Monitoring.MetricsPort == 0 {
 		cfg.Monitoring.MetricsPort = 9090
 	}
+	if cfg.Shutdown.DrainTimeout == 0 {
+		cfg.Shutdown.DrainTimeout = 30
+	}
+	for _, lb := range []*BalancerConfig{&cfg.LoadBalancing.Ollama, &cfg.LoadBalancing.VLLM} {
+		if lb.Strategy == "" {
+			lb.Strategy = "weighted_least_load"
+		}
+		if lb.InflightWeight == 0 {
+			lb.InflightWeight = 10
+		}
+		cb := &lb.CircuitBreaker
+		if cb.ConsecutiveFailures == 0 {
+			cb.ConsecutiveFailures = 3
+		}
+		if cb.ErrorRateThreshold == 0 {
+			cb.ErrorRateThreshold = 0.5
+		}
+		if cb.MinRequests == 0 {
+			cb.MinRequests = 10
+		}
+		if cb.Window == 0 {
+			cb.Window = 60
+		}
+		if cb.Cooldown == 0 {
+			cb.Cooldown = 30
+		}
+		if cb.HalfOpenProbes == 0 {
+			cb.HalfOpenProbes = 1
+		}
+	}
+	if cfg.OIDC.UsernameClaim == "" {
+		cfg.OIDC.UsernameClaim = "preferred_username"
+	}
+	if cfg.OIDC.GroupsClaim == "" {
+		cfg.OIDC.GroupsClaim = "groups"
+	}
+	if cfg.OIDC.ClockSkew == 0 {
+		cfg.OIDC.ClockSkew = 60
+	}
+	if cfg.OIDC.JWKSCacheTTL == 0 {
+		cfg.OIDC.JWKSCacheTTL = 3600
+	}
+	if cfg.LoginProtection.Enabled == nil {
+		defaultEnabled := true
+		cfg.LoginProtection.Enabled = &defaultEnabled
+	}
+	if cfg.LoginProtection.MaxFailures == 0 {
+		cfg.LoginProtection.MaxFailures = 5
+	}
+	if cfg.LoginProtection.IPMaxFailures == 0 {
+		cfg.LoginProtection.IPMaxFailures = 20
+	}
+	if cfg.LoginProtection.Lockout == 0 {
+		cfg.LoginProtection.Lockout = 30
+	}
+	if cfg.LoginProtection.MaxLockout == 0 {
+		cfg.LoginProtection.MaxLockout = 900
+	}
+	if cfg.LoginProtection.Window == 0 {
+		cfg.LoginProtection.Window = 900
+	}
+	if cfg.Kerberos.ClockSkew == 0 {
+		cfg.Kerberos.ClockSkew = 300
+	}
+	if cfg.APIKeys.File == "" {
+		cfg.APIKeys.File = "/var/lib/aiconnect/api_keys.json"
+	}
+	if cfg.Retry.MaxAttempts == 0 {
+		cfg.Retry.MaxAttempts = 3
+	}
+	if cfg.Retry.MaxBodyBytes == 0 {
+		cfg.Retry.MaxBodyBytes = 10 << 20
+	}
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
 	}
@@ -122,14 +452,50 @@ func Validate(cfg *Config) error {
 	}
 	applyDefaults(cfg)
 
-	if strings.TrimSpace(cfg.HTTPS.Domain) == "" {
-		return errors.New("https.domain obbligatorio")
+	if err := validateHTTPS(cfg); err != nil {
+		return err
 	}
-	if strings.TrimSpace(cfg.HTTPS.CacheDir) == "" {
-		return errors.New("https.cache_dir obbligatorio")
+
+	pools := map[string]BalancerConfig{"ollama": cfg.LoadBalancing.Ollama, "vllm": cfg.LoadBalancing.VLLM}
+	for _, name := range []string{"ollama", "vllm"} {
+		lb := pools[name]
+		switch lb.Strategy {
+		case "weighted_least_load", "round_robin", "least_connections", "p2c", "static_weights":
+		case "consistent_hash":
+			if lb.HashKey != "" && lb.HashKey != "user" && lb.HashKey != "model" {
+				return fmt.Errorf("load_balancing.%s.hash_key non valida: %q (user o model)", name, lb.HashKey)
+			}
+		default:
+			return fmt.Errorf("load_balancing.%s.strategy non valida: %q", name, lb.Strategy)
+		}
+		if lb.InflightWeight < 0 {
+			return fmt.Errorf("load_balancing.%s.inflight_weight non valido", name)
+		}
+		for server, weight := range lb.Weights {
+			if weight <= 0 {
+				return fmt.Errorf("load_balancing.%s.weights[%s] deve essere positivo", name, server)
+			}
+		}
+		cb := lb.CircuitBreaker
+		if cb.ConsecutiveFailures < 1 || cb.MinRequests < 1 || cb.HalfOpenProbes < 1 {
+			return fmt.Errorf("load_balancing.%s.circuit_breaker: consecutive_failures, min_requests e half_open_probes devono essere almeno 1", name)
+		}
+		if cb.ErrorRateThreshold <= 0 || cb.ErrorRateThreshold > 1 {
+			return fmt.Errorf("load_balancing.%s.circuit_breaker.error_rate_threshold deve essere tra 0 e 1", name)
+		}
+		if cb.Window < 1 || cb.Cooldown < 1 {
+			return fmt.Errorf("load_balancing.%s.circuit_breaker: window e cooldown devono essere almeno 1 secondo", name)
+		}
+	}
+
+	if cfg.Retry.MaxAttempts < 1 {
+		return errors.New("retry.max_attempts deve essere almeno 1")
 	}
-	if cfg.HTTPS.Port <= 0 {
-		return errors.New("https.port non valido")
+	if cfg.Retry.MaxBodyBytes < 0 {
+		return errors.New("retry.max_body_bytes non valido")
+	}
+	if cfg.Shutdown.DrainTimeout < 0 {
+		return errors.New("shutdown.drain_timeout non può essere negativo")
 	}
 
 	adEnabled := cfg.AD.Enabled == nil || *cfg.AD.Enabled
@@ -143,17 +509,70 @@ func Validate(cfg *Config) error {
 		if len(cfg.AD.AllowedGroups) == 0 {
 			return errors.New("ad.allowed_groups obbligatorio (quando AD è abilitato)")
 		}
+		if cfg.AD.CacheTTL < -1 || cfg.AD.NegativeCacheTTL < -1 {
+			return errors.New("ad.cache_ttl e ad.negative_cache_ttl devono essere positivi o -1 (cache disabilitata)")
+		}
+		if cfg.AD.PoolSize < 0 {
+			return errors.New("ad.pool_size non valido")
+		}
+		if err := validateLDAPTLS(cfg); err != nil {
+			return err
+		}
+		if err := validateLDAPSearch(cfg); err != nil {
+			return err
+		}
 		// BindDN/BindPassword possono essere opzionali in alcune configurazioni (anonymous bind),
 		// ma in ambienti AD tipici servono; li lasciamo configurabili nel wizard.
 	}
 
-	if len(cfg.Backends.OllamaServers) == 0 && len(cfg.Backends.VLLMServers) == 0 && strings.TrimSpace(cfg.Backends.OpenAIEndpoint) == "" {
-		return errors.New("almeno un backend deve essere configurato (ollama_servers, vllm_servers o openai_endpoint)")
+	if err := validateOIDC(cfg); err != nil {
+		return err
+	}
+	if err := validateKerberos(cfg); err != nil {
+		return err
+	}
+	if err := validateMTLS(cfg); err != nil {
+		return err
+	}
+	if err := validateAuthRoutes(cfg); err != nil {
+		return err
+	}
+	if err := validatePolicies(cfg); err != nil {
+		return err
+	}
+	if err := validateLoginProtection(cfg); err != nil {
+		return err
+	}
+	if key := cfg.IdentityHeaders.SigningKey; key != "" && len(key) < minSigningKeyLength {
+		return fmt.Errorf("identity_headers.signing_key troppo corta (minimo %d caratteri)", minSigningKeyLength)
+	}
+
+	// Con la discovery mDNS attiva i backend possono arrivare solo dalla rete
+	if !cfg.MDNS.DiscoveryEnabled && len(cfg.Backends.OllamaServers) == 0 && len(cfg.Backends.VLLMServers) == 0 && strings.TrimSpace(cfg.Backends.OpenAIEndpoint) == "" {
+		return errors.New("almeno un backend deve essere configurato (ollama_servers, vllm_servers o openai_endpoint) oppure mdns.discovery_enabled")
 	}
 	if strings.TrimSpace(cfg.Backends.OpenAIEndpoint) != "" && strings.TrimSpace(cfg.Backends.OpenAIAPIKey) == "" {
 		return errors.New("openai_api_key obbligatoria quando openai_endpoint è configurato")
 	}
 
+	for i, route := range cfg.Routing.ModelBackends {
+		if strings.TrimSpace(route.Pattern) == "" {
+			return fmt.Errorf("routing.model_backends[%d].pattern obbligatorio", i)
+		}
+		if _, err := path.Match(route.Pattern, ""); err != nil {
+			return fmt.Errorf("routing.model_backends[%d].pattern non valido: %w", i, err)
+		}
+		switch route.Backend {
+		case "ollama", "vllm":
+		case "openai":
+			if strings.TrimSpace(cfg.Backends.OpenAIEndpoint) == "" {
+				return fmt.Errorf("routing.model_backends[%d] punta a openai ma openai_endpoint non è configurato", i)
+			}
+		default:
+			return fmt.Errorf("routing.model_backends[%d].backend non valido: %q (ollama, vllm o openai)", i, route.Backend)
+		}
+	}
+
 	if IsPlaceholderConfig(cfg) {
 		return errors.New("config sembra un esempio non compilato (placeholder)")
 	}
@@ -1,9 +1,22 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
 )
 
 func TestLoad_ADEnabledDefaultsToTrue(t *testing.T) {
@@ -169,3 +182,566 @@ https:
 		}
 	}
 }
+
+func TestValidate_DiscoveryWithoutStaticBackends(t *testing.T) {
+	cfg := &Config{}
+	disabled := false
+	cfg.AD.Enabled = &disabled
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error without backends and without mDNS discovery")
+	}
+
+	cfg.MDNS.DiscoveryEnabled = true
+	if err := Validate(cfg); err != nil {
+		t.Errorf("Expected config to be valid with mDNS discovery only, got: %v", err)
+	}
+}
+
+func TestValidate_RoutingModelBackends(t *testing.T) {
+	newCfg := func(routes ...ModelRoute) *Config {
+		cfg := &Config{}
+		disabled := false
+		cfg.AD.Enabled = &disabled
+		cfg.HTTPS.Domain = "test.example.com"
+		cfg.HTTPS.CacheDir = "/tmp/test-cache"
+		cfg.Backends.OllamaServers = []string{"http://ollama1:11434"}
+		cfg.Routing.ModelBackends = routes
+		return cfg
+	}
+
+	if err := Validate(newCfg(ModelRoute{Pattern: "llama*", Backend: "ollama"})); err != nil {
+		t.Errorf("Expected valid routing, got: %v", err)
+	}
+	if err := Validate(newCfg(ModelRoute{Pattern: "llama*", Backend: "unknown"})); err == nil {
+		t.Error("Expected error for unknown backend")
+	}
+	if err := Validate(newCfg(ModelRoute{Pattern: "[", Backend: "ollama"})); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+	if err := Validate(newCfg(ModelRoute{Pattern: "gpt-*", Backend: "openai"})); err == nil {
+		t.Error("Expected error for openai route without openai_endpoint")
+	}
+}
+
+func TestValidate_Retry(t *testing.T) {
+	cfg := &Config{}
+	disabled := false
+	cfg.AD.Enabled = &disabled
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.OllamaServers = []string{"http://ollama1:11434"}
+
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+	if cfg.Retry.MaxAttempts != 3 {
+		t.Errorf("Expected default max_attempts 3, got %d", cfg.Retry.MaxAttempts)
+	}
+	if cfg.Retry.MaxBodyBytes != 10<<20 {
+		t.Errorf("Expected default max_body_bytes 10MiB, got %d", cfg.Retry.MaxBodyBytes)
+	}
+
+	cfg.Retry.MaxAttempts = -1
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for negative max_attempts")
+	}
+}
+
+func TestValidate_Shutdown(t *testing.T) {
+	cfg := &Config{}
+	disabled := false
+	cfg.AD.Enabled = &disabled
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.OllamaServers = []string{"http://ollama1:11434"}
+
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+	if cfg.Shutdown.DrainTimeout != 30 {
+		t.Errorf("Expected default drain_timeout 30, got %d", cfg.Shutdown.DrainTimeout)
+	}
+
+	cfg.Shutdown.DrainTimeout = -1
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for negative drain_timeout")
+	}
+}
+
+func TestValidate_LoadBalancing(t *testing.T) {
+	cfg := &Config{}
+	disabled := false
+	cfg.AD.Enabled = &disabled
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.OllamaServers = []string{"http://ollama1:11434"}
+
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+	if cfg.LoadBalancing.Ollama.Strategy != "weighted_least_load" || cfg.LoadBalancing.VLLM.InflightWeight != 10 {
+		t.Errorf("Unexpected defaults: %+v", cfg.LoadBalancing)
+	}
+
+	cfg.LoadBalancing.VLLM.Strategy = "least_connections"
+	if err := Validate(cfg); err != nil {
+		t.Errorf("Expected least_connections to be valid, got: %v", err)
+	}
+
+	cfg.LoadBalancing.VLLM.Strategy = "consistent_hash"
+	cfg.LoadBalancing.VLLM.HashKey = "session"
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for unknown hash_key")
+	}
+	cfg.LoadBalancing.VLLM.HashKey = "model"
+
+	cfg.LoadBalancing.Ollama.Strategy = "static_weights"
+	cfg.LoadBalancing.Ollama.Weights = map[string]int{"http://ollama1:11434": 0}
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for non-positive weight")
+	}
+
+	cfg.LoadBalancing.Ollama.Strategy = "random"
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
+
+func TestValidate_CircuitBreaker(t *testing.T) {
+	cfg := &Config{}
+	disabled := false
+	cfg.AD.Enabled = &disabled
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+	cb := cfg.LoadBalancing.Ollama.CircuitBreaker
+	if cb.ConsecutiveFailures != 3 || cb.ErrorRateThreshold != 0.5 || cb.Cooldown != 30 || cb.HalfOpenProbes != 1 {
+		t.Errorf("Unexpected circuit breaker defaults: %+v", cb)
+	}
+
+	cfg.LoadBalancing.VLLM.CircuitBreaker.ErrorRateThreshold = 1.5
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for error_rate_threshold above 1")
+	}
+	cfg.LoadBalancing.VLLM.CircuitBreaker.ErrorRateThreshold = 0.2
+
+	cfg.LoadBalancing.VLLM.CircuitBreaker.Cooldown = -1
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for negative cooldown")
+	}
+}
+
+func TestValidate_LDAPTLS(t *testing.T) {
+	newConfig := func() *Config {
+		cfg := &Config{}
+		cfg.AD.LDAPURL = "ldaps://dc1.corp.local:636"
+		cfg.AD.BaseDN = "DC=corp,DC=local"
+		cfg.AD.AllowedGroups = []string{"CN=AI-Users,DC=example,DC=com"}
+		cfg.HTTPS.Domain = "test.example.com"
+		cfg.HTTPS.CacheDir = "/tmp/test-cache"
+		cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+		return cfg
+	}
+
+	cfg := newConfig()
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+	tlsCfg, err := LDAPTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if tlsCfg.ServerName != "dc1.corp.local" || tlsCfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Unexpected TLS defaults: server name %q, min version %x", tlsCfg.ServerName, tlsCfg.MinVersion)
+	}
+
+	badCA := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(badCA, []byte("not a certificate"), 0o600)
+
+	tests := []struct {
+		name   string
+		mutate func(cfg *Config)
+	}{
+		{"start_tls with ldaps", func(cfg *Config) { cfg.AD.StartTLS = true }},
+		{"unsupported scheme", func(cfg *Config) { cfg.AD.LDAPURL = "http://ad.example.com" }},
+		{"missing CA file", func(cfg *Config) { cfg.AD.CAFile = "/nonexistent/ca.pem" }},
+		{"invalid CA file", func(cfg *Config) { cfg.AD.CAFile = badCA }},
+		{"client cert without key", func(cfg *Config) { cfg.AD.ClientCert = badCA }},
+		{"invalid client cert", func(cfg *Config) { cfg.AD.ClientCert, cfg.AD.ClientKey = badCA, badCA }},
+		{"invalid TLS version", func(cfg *Config) { cfg.AD.MinTLSVersion = "1.4" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig()
+			tt.mutate(cfg)
+			if err := Validate(cfg); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestValidate_LDAPSearch(t *testing.T) {
+	cfg := &Config{}
+	cfg.AD.LDAPURL = "ldap://ldap.corp.local"
+	cfg.AD.BaseDN = "dc=corp,dc=local"
+	cfg.AD.AllowedGroups = []string{"cn=ai-users,ou=groups,dc=corp,dc=local", "Developers"}
+	cfg.AD.UserFilter = "(objectClass=inetOrgPerson)"
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+	if cfg.AD.UserAttribute != "sAMAccountName" || cfg.AD.GroupAttribute != "memberOf" {
+		t.Errorf("Unexpected defaults: %q %q", cfg.AD.UserAttribute, cfg.AD.GroupAttribute)
+	}
+
+	cfg.AD.UserFilter = "(objectClass=person"
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for malformed user_filter")
+	}
+	cfg.AD.UserFilter = ""
+
+	cfg.AD.UserAttribute = "uid)(cn"
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for invalid user_attribute")
+	}
+	cfg.AD.UserAttribute = "uid"
+
+	cfg.AD.AllowedGroups = []string{"CN=AI-Users,,DC=corp"}
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for malformed group DN")
+	}
+}
+
+func TestValidate_OIDCAndAuthRoutes(t *testing.T) {
+	newConfig := func() *Config {
+		cfg := &Config{}
+		cfg.AD.Enabled = boolPtr(false)
+		cfg.OIDC.Enabled = true
+		cfg.OIDC.Issuer = "https://login.corp.local/realms/ai"
+		cfg.OIDC.Audience = "aiconnect"
+		cfg.OIDC.AllowedGroups = []string{"ai-users"}
+		cfg.Auth.Routes = []AuthRoute{{PathPrefix: "/v1/", Methods: []string{"oidc", "api_key"}}}
+		cfg.HTTPS.Domain = "test.example.com"
+		cfg.HTTPS.CacheDir = "/tmp/test-cache"
+		cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+		return cfg
+	}
+
+	cfg := newConfig()
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+	if cfg.OIDC.GroupsClaim != "groups" || cfg.OIDC.UsernameClaim != "preferred_username" || cfg.OIDC.ClockSkew != 60 {
+		t.Errorf("Unexpected OIDC defaults: %+v", cfg.OIDC)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(cfg *Config)
+	}{
+		{"missing issuer", func(cfg *Config) { cfg.OIDC.Issuer = "" }},
+		{"issuer without scheme", func(cfg *Config) { cfg.OIDC.Issuer = "login.corp.local" }},
+		{"missing audience", func(cfg *Config) { cfg.OIDC.Audience = "" }},
+		{"no allowed groups", func(cfg *Config) { cfg.OIDC.AllowedGroups = nil }},
+		{"route without slash", func(cfg *Config) { cfg.Auth.Routes[0].PathPrefix = "v1/" }},
+		{"route without methods", func(cfg *Config) { cfg.Auth.Routes[0].Methods = nil }},
+		{"unknown method", func(cfg *Config) { cfg.Auth.Routes[0].Methods = []string{"saml"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig()
+			tt.mutate(cfg)
+			if err := Validate(cfg); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestValidate_Policies(t *testing.T) {
+	cfg := &Config{}
+	cfg.AD.Enabled = boolPtr(false)
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+	cfg.Policies = []Policy{
+		{Name: "everyone", Groups: []string{"*"}, Backends: []string{"ollama", "vllm"}},
+		{Name: "research", Groups: []string{"CN=Research,DC=corp,DC=local"}, PathPrefixes: []string{"/openai/"}, Models: []string{"gpt-4*"}},
+	}
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+
+	invalid := []Policy{
+		{Name: "nobody", Backends: []string{"ollama"}},
+		{Groups: []string{"*"}, Backends: []string{"anthropic"}},
+		{Groups: []string{"*"}, Models: []string{"gpt-[4"}},
+		{Groups: []string{"*"}, PathPrefixes: []string{"openai/"}},
+	}
+	for _, p := range invalid {
+		cfg.Policies = []Policy{p}
+		if err := Validate(cfg); err == nil {
+			t.Errorf("Expected validation error for %+v", p)
+		}
+	}
+}
+
+func TestValidate_IdentitySigningKey(t *testing.T) {
+	cfg := &Config{}
+	cfg.AD.Enabled = boolPtr(false)
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+
+	cfg.IdentityHeaders.SigningKey = "too-short"
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for short signing key")
+	}
+	cfg.IdentityHeaders.SigningKey = strings.Repeat("x", 32)
+	if err := Validate(cfg); err != nil {
+		t.Errorf("Expected valid config, got: %v", err)
+	}
+}
+
+func TestValidate_LoginProtection(t *testing.T) {
+	cfg := &Config{}
+	cfg.AD.Enabled = boolPtr(false)
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+
+	cfg.LoginProtection.Lockout = 60
+	cfg.LoginProtection.MaxLockout = 30
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for max_lockout below lockout")
+	}
+	cfg.LoginProtection.MaxLockout = 600
+	cfg.LoginProtection.MaxFailures = -1
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for negative max_failures")
+	}
+	cfg.LoginProtection.MaxFailures = 5
+	cfg.LoginProtection.TrustedProxies = []string{"10.0.0.0/8", "ingress.local"}
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for hostname in trusted_proxies")
+	}
+	cfg.LoginProtection.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.10", "fd00::/8"}
+	if err := Validate(cfg); err != nil {
+		t.Errorf("Expected valid config, got: %v", err)
+	}
+}
+
+func TestValidate_HTTPS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestKeyPair(t, certFile, keyFile, "aiconnect.corp.local")
+
+	newConfig := func() *Config {
+		cfg := &Config{}
+		cfg.AD.Enabled = boolPtr(false)
+		cfg.Backends.OllamaServers = []string{"http://ollama1:11434"}
+		return cfg
+	}
+
+	// Private ACME directory with external account binding and extra names
+	cfg := newConfig()
+	cfg.HTTPS.Domain = "aiconnect.corp.local"
+	cfg.HTTPS.Domains = []string{"ai.corp.local"}
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.HTTPS.ACME.DirectoryURL = "https://ca.corp.local/acme/acme/directory"
+	cfg.HTTPS.ACME.EABKeyID = "kid-1"
+	cfg.HTTPS.ACME.EABHMACKey = "c2VjcmV0LWhtYWMta2V5LWZvci1lYWI"
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid acme config, got: %v", err)
+	}
+	if cfg.HTTPS.Mode != HTTPSModeACME || cfg.HTTPS.Port != 443 {
+		t.Errorf("Expected acme on 443 by default, got %s on %d", cfg.HTTPS.Mode, cfg.HTTPS.Port)
+	}
+	if names := ServerNames(cfg); len(names) != 2 || names[1] != "ai.corp.local" {
+		t.Errorf("Unexpected server names: %v", names)
+	}
+	if cfg.HTTPS.HTTPListener.Port != 80 {
+		t.Errorf("Expected default http_listener port 80, got %d", cfg.HTTPS.HTTPListener.Port)
+	}
+	if got := HSTSHeader(cfg); got != "" {
+		t.Errorf("Expected HSTS disabled by default, got %q", got)
+	}
+
+	// HTTP listener for HTTP-01 challenges and HSTS with preload
+	cfg.HTTPS.HTTPListener.Enabled = true
+	cfg.HTTPS.HSTS.MaxAge = 63072000
+	cfg.HTTPS.HSTS.IncludeSubdomains = true
+	cfg.HTTPS.HSTS.Preload = true
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid http_listener and hsts, got: %v", err)
+	}
+	if got := HSTSHeader(cfg); got != "max-age=63072000; includeSubDomains; preload" {
+		t.Errorf("Unexpected HSTS header: %q", got)
+	}
+
+	// Static certificate without domain
+	cfg = newConfig()
+	cfg.HTTPS.Mode = HTTPSModeStatic
+	cfg.HTTPS.CertFile = certFile
+	cfg.HTTPS.KeyFile = keyFile
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid static config, got: %v", err)
+	}
+
+	// Plain HTTP defaults to port 8080
+	cfg = newConfig()
+	cfg.HTTPS.Mode = HTTPSModePlain
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid plain config, got: %v", err)
+	}
+	if cfg.HTTPS.Port != 8080 {
+		t.Errorf("Expected default plain port 8080, got %d", cfg.HTTPS.Port)
+	}
+
+	invalid := map[string]func(cfg *Config){
+		"unknown mode":      func(cfg *Config) { cfg.HTTPS.Mode = "self-signed" },
+		"acme without name": func(cfg *Config) { cfg.HTTPS.Domain = "" },
+		"acme wildcard":     func(cfg *Config) { cfg.HTTPS.Domains = []string{"*.corp.local"} },
+		"acme http dir":     func(cfg *Config) { cfg.HTTPS.ACME.DirectoryURL = "http://ca.corp.local/directory" },
+		"eab without key":   func(cfg *Config) { cfg.HTTPS.ACME.EABKeyID = "kid-1" },
+		"eab bad key":       func(cfg *Config) { cfg.HTTPS.ACME.EABKeyID = "kid-1"; cfg.HTTPS.ACME.EABHMACKey = "not base64!" },
+		"acme with files":   func(cfg *Config) { cfg.HTTPS.CertFile = certFile; cfg.HTTPS.KeyFile = keyFile },
+		"static no key":     func(cfg *Config) { cfg.HTTPS.Mode = HTTPSModeStatic; cfg.HTTPS.CertFile = certFile },
+		"static mismatch": func(cfg *Config) {
+			cfg.HTTPS.Mode = HTTPSModeStatic
+			cfg.HTTPS.CertFile = certFile
+			cfg.HTTPS.KeyFile = certFile
+		},
+		"plain with mtls": func(cfg *Config) {
+			cfg.HTTPS.Mode = HTTPSModePlain
+			cfg.MTLS.Enabled = true
+		},
+		"plain with http listener": func(cfg *Config) {
+			cfg.HTTPS.Mode = HTTPSModePlain
+			cfg.HTTPS.HTTPListener.Enabled = true
+		},
+		"http listener on https port": func(cfg *Config) {
+			cfg.HTTPS.HTTPListener.Enabled = true
+			cfg.HTTPS.HTTPListener.Port = 443
+		},
+		"negative hsts": func(cfg *Config) { cfg.HTTPS.HSTS.MaxAge = -1 },
+		"hsts preload too short": func(cfg *Config) {
+			cfg.HTTPS.HSTS.MaxAge = 3600
+			cfg.HTTPS.HSTS.IncludeSubdomains = true
+			cfg.HTTPS.HSTS.Preload = true
+		},
+	}
+	for name, mutate := range invalid {
+		cfg := newConfig()
+		cfg.HTTPS.Domain = "aiconnect.corp.local"
+		cfg.HTTPS.CacheDir = "/tmp/test-cache"
+		mutate(cfg)
+		if err := Validate(cfg); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestValidate_MTLS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Corp Client CA"},
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	caFile := filepath.Join(t.TempDir(), "clients-ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+
+	cfg := &Config{}
+	cfg.AD.Enabled = boolPtr(false)
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+	cfg.MTLS.Enabled = true
+	cfg.MTLS.CAFile = caFile
+	cfg.MTLS.Identities = []CertIdentity{
+		{Subject: "CN=rag,OU=Services,O=Corp", Groups: []string{"ai-services"}},
+		{URI: "spiffe://corp.local/batch", User: "batch"},
+	}
+	cfg.Auth.Routes = []AuthRoute{{PathPrefix: "/v1/", Methods: []string{"mtls", "api_key"}}}
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+
+	invalid := [][]CertIdentity{
+		nil,
+		{{User: "nobody"}},
+		{{Subject: "CN=rag", DNSName: "rag.corp.local"}},
+		{{Subject: "not a dn"}},
+	}
+	for _, ids := range invalid {
+		cfg.MTLS.Identities = ids
+		if err := Validate(cfg); err == nil {
+			t.Errorf("Expected validation error for identities %+v", ids)
+		}
+	}
+
+	cfg.MTLS.Identities = []CertIdentity{{DNSName: "rag.corp.local"}}
+	cfg.MTLS.CAFile = ""
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error without mtls.ca_file")
+	}
+}
+
+func TestValidate_Kerberos(t *testing.T) {
+	kt := keytab.New()
+	kt.AddEntry("HTTP/aiconnect.corp.local", "CORP.LOCAL", "secret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	b, _ := kt.Marshal()
+	keytabFile := filepath.Join(t.TempDir(), "aiconnect.keytab")
+	os.WriteFile(keytabFile, b, 0o600)
+
+	cfg := &Config{}
+	cfg.AD.Enabled = boolPtr(true)
+	cfg.AD.LDAPURL = "ldaps://dc1.corp.local:636"
+	cfg.AD.BaseDN = "DC=corp,DC=local"
+	cfg.AD.AllowedGroups = []string{"CN=AI-Users,DC=corp,DC=local"}
+	cfg.HTTPS.Domain = "test.example.com"
+	cfg.HTTPS.CacheDir = "/tmp/test-cache"
+	cfg.Backends.VLLMServers = []string{"http://vllm1:8000"}
+	cfg.Kerberos.Enabled = true
+	cfg.Kerberos.Keytab = keytabFile
+	cfg.Kerberos.Realm = "CORP.LOCAL"
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("Expected valid config, got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(cfg *Config)
+	}{
+		{"missing realm", func(cfg *Config) { cfg.Kerberos.Realm = "" }},
+		{"missing keytab", func(cfg *Config) { cfg.Kerberos.Keytab = filepath.Join(t.TempDir(), "missing.keytab") }},
+		{"AD disabled", func(cfg *Config) { cfg.AD.Enabled = boolPtr(false) }},
+	}
+	for _, tt := range tests {
+		c := *cfg
+		tt.mutate(&c)
+		if err := Validate(&c); err == nil {
+			t.Errorf("%s: expected validation error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix è il prefisso delle variabili d'ambiente che sovrascrivono i campi
+// della configurazione: ad.bind_password diventa AICONNECT_AD_BIND_PASSWORD,
+// con il suffisso _FILE il valore è letto dal file indicato
+const EnvPrefix = "AICONNECT_"
+
+// Origini dei valori riportate da Sources
+const (
+	SourceDefault = "default"     // valore di default o campo non impostato
+	SourceConfig  = "config"      // file YAML
+	SourceEnv     = "env"         // variabile AICONNECT_*, o ${VAR} nel file YAML
+	SourceSecret  = "secret-file" // file indicato da un campo *_file o da una variabile AICONNECT_*_FILE
+)
+
+// secretFileSuffix indica nel file YAML il campo letto da un file, es. bind_password_file
+const secretFileSuffix = "_file"
+
+// Sources registra da dove proviene ogni valore, per percorso YAML (es.
+// ad.bind_password o policies[0].groups), con il dettaglio dell'origine
+// (variabile o file) dopo il tipo
+type Sources map[string]string
+
+// Get restituisce l'origine del campo; i campi senza origine propria ereditano
+// quella dell'elemento che li contiene (es. una lista sovrascritta per intero)
+func (s Sources) Get(path string) string {
+	for {
+		if src, ok := s[path]; ok {
+			return src
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return SourceDefault
+		}
+		path = path[:i]
+	}
+}
+
+// set registra l'origine del campo, che sostituisce quella dei suoi elementi
+func (s Sources) set(path, kind, detail string) {
+	for p := range s {
+		if strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			delete(s, p)
+		}
+	}
+	if detail != "" {
+		kind += " " + detail
+	}
+	s[path] = kind
+}
+
+// envRef riconosce ${VAR} e ${VAR:-default} nei valori del file YAML
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate sostituisce i riferimenti a variabili d'ambiente nel valore e
+// restituisce i nomi delle variabili usate. Una variabile non definita e senza
+// default è un errore: un segreto mancante non deve diventare una stringa vuota.
+func interpolate(value string) (string, []string, error) {
+	var vars []string
+	var missing string
+	result := envRef.ReplaceAllStringFunc(value, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		vars = append(vars, m[1])
+		if v, ok := os.LookupEnv(m[1]); ok {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if missing == "" {
+			missing = m[1]
+		}
+		return ""
+	})
+	if missing != "" {
+		return "", nil, fmt.Errorf("variabile d'ambiente %s non definita", missing)
+	}
+	return result, vars, nil
+}
+
+// readSecretFile legge un segreto montato come file, senza il newline finale
+func readSecretFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("errore lettura file segreto: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// yamlFields associa i nomi YAML ai campi della struct
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// resolveNode prepara il documento YAML prima della decodifica nel tipo t:
+// espande ${VAR} nei valori, sostituisce i campi *_file con il contenuto del
+// file e registra l'origine di ogni valore
+func resolveNode(node *yaml.Node, t reflect.Type, path string, sources Sources) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if f, ok := fields[key.Value]; ok {
+				if err := resolveNode(value, f.Type, joinPath(path, key.Value), sources); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Campo *_file: il valore del campo corrispondente è nel file indicato
+			name := strings.TrimSuffix(key.Value, secretFileSuffix)
+			f, ok := fields[name]
+			if name == key.Value || !ok || f.Type.Kind() != reflect.String {
+				continue
+			}
+			fieldPath := joinPath(path, name)
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == name {
+					return fmt.Errorf("%s: impostati sia %s che %s", fieldPath, name, key.Value)
+				}
+			}
+			file, _, err := interpolate(value.Value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", joinPath(path, key.Value), err)
+			}
+			secret, err := readSecretFile(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", joinPath(path, key.Value), err)
+			}
+			key.Value = name
+			node.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: secret}
+			sources.set(fieldPath, SourceSecret, file)
+		}
+		return nil
+
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct:
+		for i, item := range node.Content {
+			if err := resolveNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), sources); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Valore semplice, lista o mappa: espande le variabili in tutti gli scalari
+	var used []string
+	if err := interpolateNode(node, &used); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(used) > 0 {
+		sources.set(path, SourceEnv, "${"+strings.Join(used, "}, ${")+"}")
+	} else {
+		sources.set(path, SourceConfig, "")
+	}
+	return nil
+}
+
+func interpolateNode(node *yaml.Node, used *[]string) error {
+	if node.Kind != yaml.ScalarNode {
+		for _, child := range node.Content {
+			if err := interpolateNode(child, used); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	value, vars, err := interpolate(node.Value)
+	if err != nil {
+		return err
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	*used = append(*used, vars...)
+	node.Value = value
+	// Uno scalare non quotato viene reinterpretato (es. ${PORT} come numero)
+	if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) == 0 {
+		node.Tag = ""
+	}
+	return nil
+}
+
+// envName restituisce la variabile che sovrascrive il campo al percorso indicato
+func envName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// applyEnvOverrides sovrascrive i campi con le variabili AICONNECT_*. Le
+// strutture annidate sono percorse campo per campo; liste, mappe e liste di
+// strutture si sovrascrivono per intero.
+func applyEnvOverrides(v reflect.Value, path string, sources Sources) error {
+	for name, f := range yamlFields(v.Type()) {
+		field := v.FieldByIndex(f.Index)
+		fieldPath := joinPath(path, name)
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(field, fieldPath, sources); err != nil {
+				return err
+			}
+			continue
+		}
+
+		env := envName(fieldPath)
+		value, set := os.LookupEnv(env)
+		file, fromFile := os.LookupEnv(env + "_FILE")
+		switch {
+		case set && fromFile:
+			return fmt.Errorf("impostate sia %s che %s_FILE", env, env)
+		case fromFile:
+			secret, err := readSecretFile(file)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", env, err)
+			}
+			value = secret
+			sources.set(fieldPath, SourceSecret, file)
+		case set:
+			sources.set(fieldPath, SourceEnv, env)
+		default:
+			continue
+		}
+		if err := setFromEnv(field, value); err != nil {
+			return fmt.Errorf("%s: valore non valido: %w", env, err)
+		}
+	}
+	return nil
+}
+
+// setFromEnv imposta il campo dal valore testuale: le stringhe sono usate così
+// come sono, le liste di stringhe accettano valori separati da virgola, gli
+// altri tipi (e le liste in forma [..]) sono interpretati come YAML
+func setFromEnv(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+		return nil
+	}
+
+	target := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), target.Interface()); err != nil {
+		return err
+	}
+	field.Set(target.Elem())
+	return nil
+}
+
+// Field è un valore della configurazione con la sua origine, per "aiconnect config dump"
+type Field struct {
+	Path   string
+	Value  string
+	Source string
+	Secret bool // da mascherare nell'output
+}
+
+// secretFields sono i campi con credenziali, mai mostrati in chiaro
+var secretFields = map[string]bool{
+	"bind_password":  true,
+	"openai_api_key": true,
+	"signing_key":    true,
+	"eab_hmac_key":   true,
+}
+
+// Fields elenca tutti i valori della configurazione con la loro origine
+func Fields(cfg *Config, sources Sources) []Field {
+	var fields []Field
+	collectFields(reflect.ValueOf(cfg).Elem(), "", sources, &fields)
+	return fields
+}
+
+func collectFields(v reflect.Value, path string, sources Sources, out *[]Field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		fieldPath := joinPath(path, name)
+
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			collectFields(field, fieldPath, sources, out)
+			continue
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct && field.Len() > 0:
+			for j := 0; j < field.Len(); j++ {
+				collectFields(field.Index(j), fmt.Sprintf("%s[%d]", fieldPath, j), sources, out)
+			}
+			continue
+		}
+
+		*out = append(*out, Field{
+			Path:   fieldPath,
+			Value:  formatValue(field),
+			Source: sources.Get(fieldPath),
+			Secret: secretFields[name],
+		})
+	}
+}
+
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return ""
+		}
+		return formatValue(v.Elem())
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Slice, reflect.Map:
+		if v.Len() == 0 && v.Kind() == reflect.Map {
+			return "{}"
+		}
+		if v.Len() == 0 {
+			return "[]"
+		}
+		if items, ok := v.Interface().([]string); ok {
+			return "[" + strings.Join(items, ", ") + "]"
+		}
+		data, err := yaml.Marshal(v.Interface())
+		if err != nil {
+			return fmt.Sprint(v.Interface())
+		}
+		// Forma compatta su una riga
+		var node yaml.Node
+		if yaml.Unmarshal(data, &node) == nil && len(node.Content) > 0 {
+			node.Content[0].Style = yaml.FlowStyle
+			if flow, err := yaml.Marshal(node.Content[0]); err == nil {
+				return strings.TrimSpace(string(flow))
+			}
+		}
+		return strings.TrimSpace(string(data))
+	}
+	return fmt.Sprint(v.Interface())
+}
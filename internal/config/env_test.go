@@ -0,0 +1,147 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_EnvAndSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "bind_password")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	keyFile := filepath.Join(dir, "openai_api_key")
+	if err := os.WriteFile(keyFile, []byte("sk-from-file"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	content := `
+ad:
+  ldap_url: "ldaps://${AD_HOST}:636"
+  bind_dn: "CN=svc,DC=example,DC=com"
+  bind_password_file: "` + secretFile + `"
+  base_dn: "${AD_BASE_DN:-DC=example,DC=com}"
+  allowed_groups:
+    - "CN=AI-Users,DC=example,DC=com"
+
+backends:
+  ollama_servers:
+    - "http://ollama1:11434"
+
+policies:
+  - name: "everyone"
+    groups: ["*"]
+
+https:
+  domain: "test.example.com"
+  port: ${HTTPS_PORT}
+`
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	t.Setenv("AD_HOST", "dc1.example.com")
+	t.Setenv("HTTPS_PORT", "8443")
+	t.Setenv("AICONNECT_BACKENDS_OLLAMA_SERVERS", "http://a:11434, http://b:11434")
+	t.Setenv("AICONNECT_BACKENDS_OPENAI_API_KEY_FILE", keyFile)
+	t.Setenv("AICONNECT_LOGIN_PROTECTION_ENABLED", "false")
+	t.Setenv("AICONNECT_ROUTING_MODEL_BACKENDS", `[{pattern: "gpt-*", backend: openai}]`)
+	t.Setenv("AICONNECT_LOGGING_LEVEL", "debug")
+
+	cfg, sources, err := LoadWithSources(file)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.AD.LDAPURL != "ldaps://dc1.example.com:636" {
+		t.Errorf("Expected interpolated ldap_url, got %q", cfg.AD.LDAPURL)
+	}
+	if cfg.AD.BaseDN != "DC=example,DC=com" {
+		t.Errorf("Expected default from ${VAR:-default}, got %q", cfg.AD.BaseDN)
+	}
+	if cfg.AD.BindPassword != "s3cret" {
+		t.Errorf("Expected password from file without newline, got %q", cfg.AD.BindPassword)
+	}
+	if cfg.HTTPS.Port != 8443 {
+		t.Errorf("Expected interpolated port 8443, got %d", cfg.HTTPS.Port)
+	}
+	if len(cfg.Backends.OllamaServers) != 2 || cfg.Backends.OllamaServers[1] != "http://b:11434" {
+		t.Errorf("Expected servers from env, got %v", cfg.Backends.OllamaServers)
+	}
+	if cfg.Backends.OpenAIAPIKey != "sk-from-file" {
+		t.Errorf("Expected API key from _FILE variable, got %q", cfg.Backends.OpenAIAPIKey)
+	}
+	if cfg.LoginProtection.Enabled == nil || *cfg.LoginProtection.Enabled {
+		t.Error("Expected login protection disabled from env")
+	}
+	if len(cfg.Routing.ModelBackends) != 1 || cfg.Routing.ModelBackends[0].Backend != "openai" {
+		t.Errorf("Expected model routes from env, got %+v", cfg.Routing.ModelBackends)
+	}
+
+	wantSources := map[string]string{
+		"ad.ldap_url":                  "env ${AD_HOST}",
+		"ad.bind_dn":                   "config",
+		"ad.bind_password":             "secret-file " + secretFile,
+		"backends.ollama_servers":      "env AICONNECT_BACKENDS_OLLAMA_SERVERS",
+		"backends.openai_api_key":      "secret-file " + keyFile,
+		"routing.model_backends[0]":    "env AICONNECT_ROUTING_MODEL_BACKENDS",
+		"policies[0].name":             "config",
+		"logging.level":                "env AICONNECT_LOGGING_LEVEL",
+		"logging.format":               "default",
+		"login_protection.max_lockout": "default",
+	}
+	for path, want := range wantSources {
+		if got := sources.Get(path); got != want {
+			t.Errorf("Source of %s: expected %q, got %q", path, want, got)
+		}
+	}
+
+	// Secrets are masked in the dump
+	for _, f := range Fields(cfg, sources) {
+		if f.Path == "ad.bind_password" && !f.Secret {
+			t.Error("Expected bind_password to be marked as secret")
+		}
+		if f.Path == "backends.ollama_servers" && f.Value != "[http://a:11434, http://b:11434]" {
+			t.Errorf("Unexpected list formatting: %q", f.Value)
+		}
+	}
+}
+
+func TestLoad_EnvErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		file := filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return file
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		want    string
+	}{
+		{"undefined variable", "ad:\n  bind_password: \"${UNDEFINED_SECRET}\"\n", nil, "UNDEFINED_SECRET"},
+		{"value and file", "ad:\n  bind_password: x\n  bind_password_file: /dev/null\n", nil, "bind_password_file"},
+		{"missing secret file", "ad:\n  bind_password_file: /nonexistent/secret\n", nil, "file segreto"},
+		{"invalid number", "https:\n  domain: x\n", map[string]string{"AICONNECT_HTTPS_PORT": "abc"}, "AICONNECT_HTTPS_PORT"},
+		{"env and env file", "https:\n  domain: x\n", map[string]string{"AICONNECT_HTTPS_DOMAIN": "a", "AICONNECT_HTTPS_DOMAIN_FILE": "/dev/null"}, "AICONNECT_HTTPS_DOMAIN_FILE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(write(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// validateLDAPSearch verifica filtro utente e gruppi autorizzati della sezione ad
+func validateLDAPSearch(cfg *Config) error {
+	if strings.ContainsAny(cfg.AD.UserAttribute, "()=*\\ ") {
+		return fmt.Errorf("ad.user_attribute non valido: %q", cfg.AD.UserAttribute)
+	}
+	if strings.ContainsAny(cfg.AD.GroupAttribute, "()=*\\ ") {
+		return fmt.Errorf("ad.group_attribute non valido: %q", cfg.AD.GroupAttribute)
+	}
+	if filter := strings.TrimSpace(cfg.AD.UserFilter); filter != "" {
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			return fmt.Errorf("ad.user_filter non valido: %w", err)
+		}
+	}
+
+	// Un gruppo può essere un DN completo oppure solo il nome (CN)
+	for _, group := range cfg.AD.AllowedGroups {
+		if !strings.Contains(group, "=") {
+			continue
+		}
+		if _, err := ldap.ParseDN(group); err != nil {
+			return fmt.Errorf("ad.allowed_groups: DN non valido %q: %w", group, err)
+		}
+	}
+	return nil
+}
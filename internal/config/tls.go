@@ -0,0 +1,325 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modalità del server HTTPS (https.mode)
+const (
+	HTTPSModeACME   = "acme"   // certificati ottenuti e rinnovati via ACME
+	HTTPSModeStatic = "static" // certificato e chiave da file
+	HTTPSModePlain  = "plain"  // HTTP in chiaro, TLS terminato da un ingress
+)
+
+// hstsPreloadMinAge è il max_age minimo accettato dalla lista di preload HSTS (un anno)
+const hstsPreloadMinAge = 31536000
+
+// certFileRecheck è l'intervallo minimo tra due controlli di modifica dei file del certificato
+const certFileRecheck = 5 * time.Second
+
+// tlsVersions associa le versioni accettate in configurazione alle costanti di crypto/tls
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converte una versione TLS ("1.2", "1.3"); vuoto vale TLS 1.2
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("versione TLS non valida: %q (1.0, 1.1, 1.2 o 1.3)", version)
+	}
+	return v, nil
+}
+
+// LoadCertPool legge un bundle PEM di certificati CA
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("errore lettura CA %s: %w", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("nessun certificato PEM valido in %s", file)
+	}
+	return pool, nil
+}
+
+// LDAPTLSConfig costruisce la configurazione TLS per ldaps:// e StartTLS. Se
+// server_name non è impostato il certificato viene verificato sull'host di ldap_url.
+func LDAPTLSConfig(cfg *Config) (*tls.Config, error) {
+	u, err := url.Parse(cfg.AD.LDAPURL)
+	if err != nil {
+		return nil, fmt.Errorf("ad.ldap_url non valido: %w", err)
+	}
+
+	minVersion, err := ParseTLSVersion(cfg.AD.MinTLSVersion)
+	if err != nil {
+		return nil, fmt.Errorf("ad.min_tls_version: %w", err)
+	}
+
+	tlsCfg := &tls.Config{
+		ServerName: cfg.AD.ServerName,
+		MinVersion: minVersion,
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = u.Hostname()
+		if host, _, err := net.SplitHostPort(u.Host); err == nil {
+			tlsCfg.ServerName = host
+		}
+	}
+
+	if cfg.AD.CAFile != "" {
+		pool, err := LoadCertPool(cfg.AD.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ad.ca_file: %w", err)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if (cfg.AD.ClientCert == "") != (cfg.AD.ClientKey == "") {
+		return nil, errors.New("ad.client_cert e ad.client_key vanno impostati insieme")
+	}
+	if cfg.AD.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.AD.ClientCert, cfg.AD.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("ad.client_cert: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// validateLDAPTLS verifica le opzioni TLS della sezione ad
+func validateLDAPTLS(cfg *Config) error {
+	u, err := url.Parse(strings.TrimSpace(cfg.AD.LDAPURL))
+	if err != nil {
+		return fmt.Errorf("ad.ldap_url non valido: %w", err)
+	}
+	switch u.Scheme {
+	case "ldap":
+	case "ldaps":
+		if cfg.AD.StartTLS {
+			return errors.New("ad.start_tls non è compatibile con ldaps:// (la connessione è già cifrata)")
+		}
+	default:
+		return fmt.Errorf("ad.ldap_url: schema %q non supportato (ldap:// o ldaps://)", u.Scheme)
+	}
+	_, err = LDAPTLSConfig(cfg)
+	return err
+}
+
+// ServerNames restituisce i nomi del servizio: https.domain seguito da https.domains
+func ServerNames(cfg *Config) []string {
+	var names []string
+	for _, name := range append([]string{cfg.HTTPS.Domain}, cfg.HTTPS.Domains...) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ParseEABKey decodifica la chiave HMAC dell'External Account Binding, che le
+// CA forniscono in base64url con o senza padding
+func ParseEABKey(key string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(key), "="))
+	if err != nil {
+		return nil, fmt.Errorf("https.acme.eab_hmac_key non valida (base64url): %w", err)
+	}
+	return decoded, nil
+}
+
+// validateHTTPS verifica la sezione https secondo la modalità
+func validateHTTPS(cfg *Config) error {
+	if cfg.HTTPS.Port <= 0 || cfg.HTTPS.Port > 65535 {
+		return errors.New("https.port non valido")
+	}
+
+	switch cfg.HTTPS.Mode {
+	case HTTPSModeACME:
+		names := ServerNames(cfg)
+		if len(names) == 0 {
+			return errors.New("https.domain obbligatorio")
+		}
+		for _, name := range names {
+			// autocert usa le challenge TLS-ALPN-01 e HTTP-01, che non ammettono wildcard
+			if strings.Contains(name, "*") {
+				return fmt.Errorf("https.domains: %q non valido, i certificati wildcard richiedono mode static", name)
+			}
+		}
+		if strings.TrimSpace(cfg.HTTPS.CacheDir) == "" {
+			return errors.New("https.cache_dir obbligatorio")
+		}
+		if cfg.HTTPS.CertFile != "" || cfg.HTTPS.KeyFile != "" {
+			return errors.New("https.cert_file e https.key_file si usano solo con mode static")
+		}
+		acme := cfg.HTTPS.ACME
+		if acme.DirectoryURL != "" {
+			if u, err := url.Parse(acme.DirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("https.acme.directory_url non valido: %q (URL https://)", acme.DirectoryURL)
+			}
+		}
+		if acme.CAFile != "" {
+			if _, err := LoadCertPool(acme.CAFile); err != nil {
+				return fmt.Errorf("https.acme.ca_file: %w", err)
+			}
+		}
+		if (acme.EABKeyID == "") != (acme.EABHMACKey == "") {
+			return errors.New("https.acme.eab_key_id e https.acme.eab_hmac_key vanno impostati insieme")
+		}
+		if acme.EABHMACKey != "" {
+			if _, err := ParseEABKey(acme.EABHMACKey); err != nil {
+				return err
+			}
+		}
+
+	case HTTPSModeStatic:
+		if cfg.HTTPS.CertFile == "" || cfg.HTTPS.KeyFile == "" {
+			return errors.New("https.cert_file e https.key_file obbligatori con mode static")
+		}
+		if _, err := tls.LoadX509KeyPair(cfg.HTTPS.CertFile, cfg.HTTPS.KeyFile); err != nil {
+			return fmt.Errorf("https.cert_file: %w", err)
+		}
+
+	case HTTPSModePlain:
+		if cfg.MTLS.Enabled {
+			return errors.New("mtls richiede https.mode acme o static: in modalità plain la connessione TLS termina sull'ingress")
+		}
+
+	default:
+		return fmt.Errorf("https.mode non valido: %q (acme, static o plain)", cfg.HTTPS.Mode)
+	}
+
+	if listener := cfg.HTTPS.HTTPListener; listener.Enabled {
+		if cfg.HTTPS.Mode == HTTPSModePlain {
+			return errors.New("https.http_listener non è utilizzabile con mode plain: il server principale è già HTTP")
+		}
+		if listener.Port <= 0 || listener.Port > 65535 || listener.Port == cfg.HTTPS.Port {
+			return errors.New("https.http_listener.port non valido (deve essere diverso da https.port)")
+		}
+	}
+
+	hsts := cfg.HTTPS.HSTS
+	if hsts.MaxAge < 0 {
+		return errors.New("https.hsts.max_age non può essere negativo")
+	}
+	// Requisiti della lista di preload dei browser (hstspreload.org)
+	if hsts.Preload && (hsts.MaxAge < hstsPreloadMinAge || !hsts.IncludeSubdomains) {
+		return fmt.Errorf("https.hsts.preload richiede include_subdomains e max_age di almeno %d secondi", hstsPreloadMinAge)
+	}
+	return nil
+}
+
+// HSTSHeader restituisce il valore dell'header Strict-Transport-Security,
+// vuoto se HSTS è disabilitato
+func HSTSHeader(cfg *Config) string {
+	hsts := cfg.HTTPS.HSTS
+	if hsts.MaxAge <= 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.Itoa(hsts.MaxAge)
+	if hsts.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if hsts.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// CertFile fornisce il certificato del server letto da file e lo rilegge
+// quando certificato o chiave cambiano (es. rinnovo da certbot o
+// cert-manager), senza riavvio. Se i nuovi file non sono validi resta in uso
+// il certificato precedente e l'errore viene passato a report.
+type CertFile struct {
+	certFile string
+	keyFile  string
+	report   func(err error)
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	stamp     string // data di modifica e dimensione dei due file
+	checkedAt time.Time
+}
+
+// LoadCertFile carica certificato e chiave; report riceve l'esito delle
+// riletture successive (nil se il nuovo certificato è in uso)
+func LoadCertFile(certFile, keyFile string, report func(err error)) (*CertFile, error) {
+	c := &CertFile{certFile: certFile, keyFile: keyFile, report: report}
+	c.stamp = c.stat()
+	c.checkedAt = time.Now()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("https.cert_file: %w", err)
+	}
+	c.cert = &cert
+	return c, nil
+}
+
+// GetCertificate implementa tls.Config.GetCertificate
+func (c *CertFile) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.refresh()
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, nil
+}
+
+// refresh rilegge i file se sono cambiati dall'ultimo controllo
+func (c *CertFile) refresh() {
+	c.mutex.RLock()
+	fresh := time.Since(c.checkedAt) < certFileRecheck
+	c.mutex.RUnlock()
+	if fresh {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if time.Since(c.checkedAt) < certFileRecheck {
+		return
+	}
+	c.checkedAt = time.Now()
+	stamp := c.stat()
+	if stamp == c.stamp {
+		return
+	}
+
+	// Lo stato dei file viene registrato anche se non sono validi (es. chiave
+	// non ancora aggiornata): la rilettura avviene alla modifica successiva
+	c.stamp = stamp
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		c.report(fmt.Errorf("https.cert_file: %w", err))
+		return
+	}
+	c.cert = &cert
+	c.report(nil)
+}
+
+func (c *CertFile) stat() string {
+	var stamp string
+	for _, file := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(file); err == nil {
+			stamp += fmt.Sprintf("%d/%d;", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamp
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestKeyPair writes a self-signed server certificate and its key as PEM
+func writeTestKeyPair(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func TestCertFile_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestKeyPair(t, certFile, keyFile, "old.example.com")
+
+	var reports []error
+	certs, err := LoadCertFile(certFile, keyFile, func(err error) { reports = append(reports, err) })
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	commonName := func() string {
+		t.Helper()
+		cert, err := certs.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		parsed, _ := x509.ParseCertificate(cert.Certificate[0])
+		return parsed.Subject.CommonName
+	}
+	expire := func() {
+		certs.mutex.Lock()
+		certs.checkedAt = time.Time{}
+		certs.mutex.Unlock()
+	}
+
+	if got := commonName(); got != "old.example.com" {
+		t.Fatalf("Expected initial certificate, got %s", got)
+	}
+
+	// Renewed files are picked up on the next handshake after the recheck interval
+	writeTestKeyPair(t, certFile, keyFile, "new.example.com")
+	if got := commonName(); got != "old.example.com" {
+		t.Errorf("Expected files not to be rechecked before the interval, got %s", got)
+	}
+	expire()
+	if got := commonName(); got != "new.example.com" {
+		t.Errorf("Expected renewed certificate, got %s", got)
+	}
+	if len(reports) != 1 || reports[0] != nil {
+		t.Errorf("Expected one successful reload report, got %v", reports)
+	}
+
+	// A broken file is reported and the previous certificate stays in use
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	expire()
+	if got := commonName(); got != "new.example.com" {
+		t.Errorf("Expected previous certificate after a failed reload, got %s", got)
+	}
+	if len(reports) != 2 || reports[1] == nil {
+		t.Errorf("Expected a reload error, got %v", reports)
+	}
+}
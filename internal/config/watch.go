@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultWatchInterval è l'intervallo di controllo delle modifiche al file
+const DefaultWatchInterval = 5 * time.Second
+
+// Watcher rilegge la configurazione alla ricezione di SIGHUP o quando il file
+// cambia. Solo una configurazione che supera Validate viene passata ad apply:
+// se il file non è valido, o apply lo rifiuta, resta in uso quella precedente.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	apply    func(cfg *Config) error
+	report   func(err error) // esito di ogni reload, nil se applicato
+
+	mutex   sync.Mutex // serializza i reload
+	modTime time.Time
+	size    int64
+
+	signals chan os.Signal
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewWatcher crea il watcher del file path; report riceve l'esito di ogni
+// reload avviato da segnale o da modifica del file
+func NewWatcher(path string, interval time.Duration, apply func(cfg *Config) error, report func(err error)) *Watcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	return &Watcher{
+		path:     path,
+		interval: interval,
+		apply:    apply,
+		report:   report,
+	}
+}
+
+// Start avvia l'ascolto di SIGHUP e il controllo periodico del file
+func (w *Watcher) Start() {
+	w.mutex.Lock()
+	w.modTime, w.size, _ = w.stat()
+	w.mutex.Unlock()
+
+	w.signals = make(chan os.Signal, 1)
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	signal.Notify(w.signals, syscall.SIGHUP)
+
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.signals:
+				w.report(w.Reload())
+			case <-ticker.C:
+				if w.changed() {
+					w.report(w.Reload())
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop termina l'ascolto; SIGHUP torna al comportamento di default
+func (w *Watcher) Stop() {
+	if w.stop == nil {
+		return
+	}
+	signal.Stop(w.signals)
+	close(w.stop)
+	<-w.done
+	w.stop = nil
+}
+
+// Reload carica e valida il file e applica la nuova configurazione
+func (w *Watcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// Lo stato del file viene registrato anche se il reload fallisce, così un
+	// file non valido non viene riletto a ogni controllo ma solo alla modifica successiva
+	w.modTime, w.size, _ = w.stat()
+
+	cfg, err := Load(w.path)
+	if err != nil {
+		return err
+	}
+	if err := Validate(cfg); err != nil {
+		return fmt.Errorf("configurazione non valida: %w", err)
+	}
+	return w.apply(cfg)
+}
+
+// changed indica se il file è cambiato dall'ultimo caricamento
+func (w *Watcher) changed() bool {
+	modTime, size, err := w.stat()
+	if err != nil {
+		// File assente durante una sostituzione: si attende quello nuovo
+		return false
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return !modTime.Equal(w.modTime) || size != w.size
+}
+
+func (w *Watcher) stat() (time.Time, int64, error) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return info.ModTime(), info.Size(), nil
+}
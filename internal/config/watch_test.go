@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const watchTestConfig = `
+ad:
+  enabled: false
+
+backends:
+  ollama_servers:
+    - "%s"
+
+https:
+  domain: "test.example.com"
+  cache_dir: "/tmp/test-cache"
+
+logging:
+  level: "%s"
+`
+
+func writeWatchTestConfig(t *testing.T, file, server, level string) {
+	t.Helper()
+	content := strings.Replace(strings.Replace(watchTestConfig, "%s", server, 1), "%s", level, 1)
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeWatchTestConfig(t, file, "http://ollama1:11434", "info")
+
+	applied := make(chan *Config, 1)
+	results := make(chan error, 1)
+	w := NewWatcher(file, 10*time.Millisecond, func(cfg *Config) error {
+		applied <- cfg
+		return nil
+	}, func(err error) {
+		results <- err
+	})
+	w.Start()
+	defer w.Stop()
+
+	wait := func() (*Config, error) {
+		t.Helper()
+		select {
+		case err := <-results:
+			select {
+			case cfg := <-applied:
+				return cfg, err
+			default:
+				return nil, err
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("reload not triggered")
+			return nil, nil
+		}
+	}
+
+	// A file change is picked up by the periodic check
+	writeWatchTestConfig(t, file, "http://ollama2:11434", "debug")
+	cfg, err := wait()
+	if err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+	if cfg.Backends.OllamaServers[0] != "http://ollama2:11434" || cfg.Logging.Level != "debug" {
+		t.Errorf("Expected new values, got %v %s", cfg.Backends.OllamaServers, cfg.Logging.Level)
+	}
+
+	// An invalid file is rejected without reaching apply
+	if err := os.WriteFile(file, []byte("backends: [\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if cfg, err := wait(); err == nil || cfg != nil {
+		t.Errorf("Expected rejected reload, got cfg=%v err=%v", cfg, err)
+	}
+
+	// SIGHUP reloads even when the file did not change
+	writeWatchTestConfig(t, file, "http://ollama3:11434", "info")
+	if _, err := wait(); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("Failed to send SIGHUP: %v", err)
+	}
+	cfg, err = wait()
+	if err != nil || cfg.Backends.OllamaServers[0] != "http://ollama3:11434" {
+		t.Errorf("Expected reload on SIGHUP, got cfg=%v err=%v", cfg, err)
+	}
+}
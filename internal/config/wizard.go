@@ -108,15 +108,34 @@ func RunWizard(opts WizardOptions) (*Config, error) {
 			}
 		}
 
-		cfg.HTTPS.Domain, err = askString(reader, "HTTPS domain (LetsEncrypt)", "aiconnect.example.com", true)
+		cfg.HTTPS.Mode, err = askString(reader, "Certificati HTTPS (acme, static o plain dietro un ingress)", HTTPSModeACME, true)
 		if err != nil {
 			return nil, err
 		}
-		cfg.HTTPS.CacheDir, err = askString(reader, "Cache dir certificati", "/var/cache/aiconnect/autocert", true)
-		if err != nil {
-			return nil, err
+		defaultPort := 443
+		switch cfg.HTTPS.Mode {
+		case HTTPSModeStatic:
+			cfg.HTTPS.CertFile, err = askString(reader, "File certificato (PEM)", "/etc/aiconnect/tls/tls.crt", true)
+			if err != nil {
+				return nil, err
+			}
+			cfg.HTTPS.KeyFile, err = askString(reader, "File chiave privata (PEM)", "/etc/aiconnect/tls/tls.key", true)
+			if err != nil {
+				return nil, err
+			}
+		case HTTPSModePlain:
+			defaultPort = 8080
+		default:
+			cfg.HTTPS.Domain, err = askString(reader, "HTTPS domain (LetsEncrypt)", "aiconnect.example.com", true)
+			if err != nil {
+				return nil, err
+			}
+			cfg.HTTPS.CacheDir, err = askString(reader, "Cache dir certificati", "/var/cache/aiconnect/autocert", true)
+			if err != nil {
+				return nil, err
+			}
 		}
-		cfg.HTTPS.Port, err = askInt(reader, "Porta HTTPS", 443)
+		cfg.HTTPS.Port, err = askInt(reader, "Porta HTTPS", defaultPort)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,657 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Balancer è l'interfaccia comune dei pool di server (Ollama, vLLM) usata dal proxy
+type Balancer interface {
+	DynamicPool
+
+	// Configure imposta la strategia di selezione e il circuit breaker del pool
+	Configure(cfg config.BalancerConfig) error
+	// SetCircuitObserver registra chi riceve le transizioni dei circuit breaker
+	SetCircuitObserver(observer CircuitObserver)
+	// Start avvia il monitoraggio periodico dei server, Stop lo arresta
+	Start()
+	Stop()
+	// SetStaticServers sostituisce i server da configurazione mantenendo lo stato di quelli rimasti
+	SetStaticServers(servers []string)
+
+	// Select sceglie un server per la richiesta secondo la strategia configurata
+	Select(req SelectRequest) (string, error)
+	SelectServer() (string, error)
+	SelectServerForModel(model string, exclude ...string) (string, error)
+
+	// ReportFailure e ReportSuccess segnalano l'esito di una richiesta del proxy
+	ReportFailure(serverURL string, err error)
+	ReportSuccess(serverURL string)
+	// Acquire e Release delimitano una richiesta inoltrata al server
+	Acquire(serverURL string)
+	Release(serverURL string)
+
+	HasModel(model string) bool
+	HasUnknownInventory() bool
+	Models() []string
+	GetMetrics() map[string]*ServerMetrics
+}
+
+// Entrambi i pool implementano Balancer
+var (
+	_ Balancer = (*OllamaLoadBalancer)(nil)
+	_ Balancer = (*VLLMLoadBalancer)(nil)
+)
+
+// SelectRequest descrive la richiesta per cui scegliere un server
+type SelectRequest struct {
+	Model   string   // modello richiesto, vuoto per qualsiasi server
+	User    string   // utente autenticato (chiave per consistent hashing)
+	Exclude []string // server da scartare (es. già falliti durante un retry)
+}
+
+// ServerMetrics contiene le metriche di carico di un server
+type ServerMetrics struct {
+	URL          string
+	CPUPercent   float64
+	RAMPercent   float64
+	GPUCount     int
+	GPUAvgUtil   float64
+	GPUAvgMemory float64
+	Available    bool
+	LastCheck    time.Time
+	ErrorCount   int
+	TotalWeight  float64 // Carico totale calcolato
+	LoadSource   string  // origine delle metriche di carico (json, prometheus), vuoto se assenti
+	// Metriche del motore vLLM (formato Prometheus)
+	RequestsRunning int
+	RequestsWaiting int
+	KVCacheUsage    float64 // occupazione KV cache GPU in percentuale
+	// Richieste attualmente inoltrate dal proxy al server
+	ActiveRequests int
+	// Stato del circuit breaker
+	Circuit CircuitState
+	// Inventario modelli serviti (da /api/tags o /v1/models)
+	Models        []string
+	ModelSizes    map[string]int64 // dimensione in byte per modello, se nota
+	ModelsUpdated time.Time
+	// Residenza Ollama da /api/ps e VRAM riportata dall'agent (0 se sconosciuta)
+	LoadedModels []string
+	VRAMUsed     int64 // byte di VRAM occupati dai modelli caricati
+	VRAMTotal    int64
+	VRAMFree     int64
+}
+
+// pool contiene lo stato e la logica comuni ai load balancer Ollama e vLLM;
+// i tipi concreti forniscono solo l'health check e l'inventario modelli
+type pool struct {
+	label           string // nome del backend nei log (Ollama, vLLM)
+	servers         []string
+	static          map[string]bool // server da configurazione, mai rimossi da mDNS
+	discovered      map[string]bool // server annunciati via mDNS, mai rimossi da un reload
+	metrics         map[string]*ServerMetrics
+	mutex           sync.RWMutex
+	log             *logrus.Logger
+	checkInterval   time.Duration
+	strategy        Strategy
+	breakers        map[string]*breaker
+	breakerSettings breakerSettings
+	observer        CircuitObserver
+
+	stop chan struct{} // chiuso da Stop per terminare il polling
+	wg   sync.WaitGroup
+
+	check       func(serverURL string)
+	fetchModels func(client *http.Client, serverURL string) ([]modelInfo, error)
+}
+
+// newPool inizializza lo stato comune con la strategia di default
+func newPool(label string, servers []string, checkInterval int, log *logrus.Logger) *pool {
+	p := &pool{
+		label:           label,
+		servers:         servers,
+		static:          make(map[string]bool),
+		discovered:      make(map[string]bool),
+		metrics:         make(map[string]*ServerMetrics),
+		log:             log,
+		checkInterval:   time.Duration(checkInterval) * time.Second,
+		strategy:        newWeightedLeastLoad(defaultInflightWeight),
+		breakers:        make(map[string]*breaker),
+		breakerSettings: defaultBreakerSettings(),
+	}
+
+	// Inizializza metriche per ogni server
+	for _, server := range servers {
+		p.static[server] = true
+		p.metrics[server] = &ServerMetrics{
+			URL:       server,
+			Available: true,
+		}
+	}
+
+	return p
+}
+
+// Configure imposta la strategia di selezione e il circuit breaker del pool.
+// Lo stato dei circuit breaker esistenti viene mantenuto.
+func (p *pool) Configure(cfg config.BalancerConfig) error {
+	strategy, err := NewStrategy(cfg)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.strategy = strategy
+	p.breakerSettings = newBreakerSettings(cfg.CircuitBreaker)
+	p.log.WithField("strategy", strategy.Name()).Debug("Strategia load balancer " + p.label + " configurata")
+	return nil
+}
+
+// Start avvia il monitoraggio periodico dei server
+func (p *pool) Start() {
+	// Controllo iniziale
+	p.checkAllServers()
+
+	// Avvia polling periodico
+	stop := make(chan struct{})
+	p.mutex.Lock()
+	p.stop = stop
+	p.mutex.Unlock()
+
+	ticker := time.NewTicker(p.checkInterval)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.checkAllServers()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	p.log.WithFields(logrus.Fields{
+		"interval": p.checkInterval,
+		"strategy": p.strategyName(),
+	}).Info("Load balancer " + p.label + " avviato")
+}
+
+// Stop arresta il monitoraggio periodico e attende il controllo in corso
+func (p *pool) Stop() {
+	p.mutex.Lock()
+	stop := p.stop
+	p.stop = nil
+	p.mutex.Unlock()
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	p.wg.Wait()
+	p.log.Info("Load balancer " + p.label + " arrestato")
+}
+
+// strategyName restituisce il nome della strategia corrente
+func (p *pool) strategyName() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.strategy.Name()
+}
+
+// checkAllServers controlla lo stato di tutti i server
+func (p *pool) checkAllServers() {
+	var wg sync.WaitGroup
+
+	for _, server := range p.serverList() {
+		wg.Add(1)
+		go func(serverURL string) {
+			defer wg.Done()
+			p.check(serverURL)
+			p.refreshModels(serverURL)
+		}(server)
+	}
+
+	wg.Wait()
+}
+
+// handleServerError gestisce errori di comunicazione con il server
+func (p *pool) handleServerError(serverURL string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	metrics, ok := p.metrics[serverURL]
+	if !ok {
+		// Server rimosso nel frattempo (es. nodo mDNS perso)
+		return
+	}
+	metrics.ErrorCount++
+	metrics.LastCheck = time.Now()
+
+	from, changed := p.breakerFor(serverURL).failure(metrics.LastCheck, p.breakerSettings)
+	if changed {
+		p.transition(metrics, from, CircuitOpen)
+	}
+	p.log.WithFields(logrus.Fields{
+		"server":      serverURL,
+		"error_count": metrics.ErrorCount,
+		"error":       err.Error(),
+	}).Debug("Errore comunicazione server " + p.label)
+}
+
+// recordSuccess registra un health check riuscito. Va chiamato con il mutex
+// acquisito; il server torna disponibile solo se il circuito non è aperto.
+func (p *pool) recordSuccess(serverURL string, metrics *ServerMetrics) {
+	b := p.breakerFor(serverURL)
+	p.applySuccess(metrics, b, b.healthy)
+}
+
+// applySuccess applica l'esito positivo registrato da record sul breaker
+func (p *pool) applySuccess(metrics *ServerMetrics, b *breaker, record func(time.Time, breakerSettings) (CircuitState, bool)) {
+	metrics.ErrorCount = 0
+
+	if from, changed := record(time.Now(), p.breakerSettings); changed {
+		p.transition(metrics, from, b.state)
+	}
+	metrics.Available = b.state != CircuitOpen
+}
+
+// breakerFor restituisce il circuit breaker del server, creandolo se assente
+func (p *pool) breakerFor(serverURL string) *breaker {
+	b, ok := p.breakers[serverURL]
+	if !ok {
+		b = &breaker{}
+		p.breakers[serverURL] = b
+	}
+	return b
+}
+
+// allow indica se il server può essere selezionato secondo il suo circuit breaker
+func (p *pool) allow(metrics *ServerMetrics, now time.Time) bool {
+	ok, from, changed := p.breakerFor(metrics.URL).allow(now, p.breakerSettings)
+	if changed {
+		p.transition(metrics, from, CircuitHalfOpen)
+	}
+	return ok && metrics.Available
+}
+
+// transition applica un cambio di stato del circuit breaker, lo registra nei
+// log e lo notifica all'observer
+func (p *pool) transition(metrics *ServerMetrics, from, to CircuitState) {
+	metrics.Circuit = to
+	metrics.Available = to != CircuitOpen
+
+	entry := p.log.WithFields(logrus.Fields{
+		"server":      metrics.URL,
+		"from":        from.String(),
+		"to":          to.String(),
+		"error_count": metrics.ErrorCount,
+	})
+	switch to {
+	case CircuitOpen:
+		entry.WithField("cooldown", p.breakerSettings.cooldown).Warn("Server " + p.label + " marcato non disponibile (circuit breaker aperto)")
+	case CircuitHalfOpen:
+		entry.Info("Circuit breaker server " + p.label + " in half-open, invio richieste di prova")
+	default:
+		entry.Info("Server " + p.label + " di nuovo disponibile (circuit breaker chiuso)")
+	}
+
+	if p.observer != nil {
+		p.observer(metrics.URL, from, to)
+	}
+}
+
+// SetCircuitObserver registra la funzione notificata a ogni transizione di stato dei circuit breaker
+func (p *pool) SetCircuitObserver(observer CircuitObserver) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.observer = observer
+}
+
+// SelectServer seleziona il server migliore tra tutti quelli disponibili
+func (p *pool) SelectServer() (string, error) {
+	return p.Select(SelectRequest{})
+}
+
+// SelectServerForModel seleziona il server migliore tra quelli che servono il
+// modello richiesto. Con model vuoto considera tutti i server disponibili.
+// I server in exclude (es. già falliti durante un retry) vengono scartati.
+func (p *pool) SelectServerForModel(model string, exclude ...string) (string, error) {
+	return p.Select(SelectRequest{Model: model, Exclude: exclude})
+}
+
+// Select filtra i server disponibili (modello, esclusioni) e applica la strategia
+func (p *pool) Select(req SelectRequest) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Trova server disponibili: i circuiti aperti sono esclusi, quelli in
+	// half-open accettano solo le richieste di prova
+	now := time.Now()
+	var availableServers []*ServerMetrics
+	for _, metrics := range p.metrics {
+		if p.allow(metrics, now) {
+			availableServers = append(availableServers, metrics)
+		}
+	}
+
+	if len(availableServers) == 0 {
+		return "", fmt.Errorf("nessun server %s disponibile", p.label)
+	}
+
+	// I server con inventario non ancora letto (appena aggiunti o con
+	// /api/tags e /v1/models sempre falliti) restano candidati: il modello è
+	// assente solo se tutti gli inventari sono noti e nessuno lo contiene
+	if req.Model != "" {
+		var withModel, unknown []*ServerMetrics
+		for _, m := range availableServers {
+			switch {
+			case hasModel(m.Models, req.Model):
+				withModel = append(withModel, m)
+			case m.ModelsUpdated.IsZero():
+				unknown = append(unknown, m)
+			}
+		}
+		if len(withModel) == 0 && len(unknown) == 0 {
+			return "", modelNotFoundError(p.label, req.Model)
+		}
+		if len(withModel) == 0 {
+			withModel = unknown
+		}
+		availableServers = withModel
+	}
+
+	if len(req.Exclude) > 0 {
+		excluded := make(map[string]bool, len(req.Exclude))
+		for _, u := range req.Exclude {
+			excluded[u] = true
+		}
+		var remaining []*ServerMetrics
+		for _, m := range availableServers {
+			if !excluded[m.URL] {
+				remaining = append(remaining, m)
+			}
+		}
+		if len(remaining) == 0 {
+			return "", fmt.Errorf("nessun altro server %s disponibile", p.label)
+		}
+		availableServers = remaining
+	}
+
+	availableServers = preferResident(availableServers, req.Model)
+
+	// Ordine stabile: la mappa dei server non ha ordine di iterazione
+	sort.Slice(availableServers, func(i, j int) bool {
+		return availableServers[i].URL < availableServers[j].URL
+	})
+
+	selected := p.strategy.Select(availableServers, req)
+	p.breakerFor(selected.URL).admit()
+	p.log.WithFields(logrus.Fields{
+		"server":   selected.URL,
+		"strategy": p.strategy.Name(),
+		"weight":   selected.TotalWeight,
+		"active":   selected.ActiveRequests,
+	}).Debug("Server " + p.label + " selezionato")
+	return selected.URL, nil
+}
+
+// ReportFailure segnala un errore rilevato dal proxy su un server: conta come
+// un errore di polling, così un nodo guasto viene escluso senza attendere i
+// successivi health check
+func (p *pool) ReportFailure(serverURL string, err error) {
+	p.handleServerError(serverURL, err)
+}
+
+// ReportSuccess segnala una risposta valida del server al proxy: alimenta il
+// tasso di errore e chiude il circuito dopo le richieste di prova in half-open
+func (p *pool) ReportSuccess(serverURL string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if metrics, ok := p.metrics[serverURL]; ok {
+		b := p.breakerFor(serverURL)
+		p.applySuccess(metrics, b, b.success)
+	}
+}
+
+// Acquire registra l'inizio di una richiesta inoltrata al server
+func (p *pool) Acquire(serverURL string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if metrics, ok := p.metrics[serverURL]; ok {
+		metrics.ActiveRequests++
+	}
+}
+
+// Release registra la fine di una richiesta inoltrata al server
+func (p *pool) Release(serverURL string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if metrics, ok := p.metrics[serverURL]; ok && metrics.ActiveRequests > 0 {
+		metrics.ActiveRequests--
+	}
+}
+
+// refreshModels aggiorna l'inventario dei modelli serviti da un server
+func (p *pool) refreshModels(serverURL string) {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	inventory, err := p.fetchModels(client, serverURL)
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
+			"server": serverURL,
+			"error":  err.Error(),
+		}).Debug("Impossibile aggiornare inventario modelli " + p.label)
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	metrics, ok := p.metrics[serverURL]
+	if !ok {
+		return
+	}
+	models := make([]string, 0, len(inventory))
+	sizes := make(map[string]int64)
+	for _, m := range inventory {
+		models = append(models, m.Name)
+		if m.Size > 0 {
+			sizes[normalizeModelName(m.Name)] = m.Size
+		}
+	}
+	metrics.Models = models
+	metrics.ModelSizes = sizes
+	metrics.ModelsUpdated = time.Now()
+
+	p.log.WithFields(logrus.Fields{
+		"server": serverURL,
+		"models": len(models),
+	}).Debug("Inventario modelli " + p.label + " aggiornato")
+}
+
+// HasModel indica se almeno un server disponibile serve il modello
+func (p *pool) HasModel(model string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, m := range p.metrics {
+		if m.Available && hasModel(m.Models, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasUnknownInventory indica se un server disponibile non ha ancora un
+// inventario (appena aggiunto o con /api/tags e /v1/models sempre falliti),
+// quindi potrebbe servire qualunque modello
+func (p *pool) HasUnknownInventory() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, m := range p.metrics {
+		if m.Available && m.ModelsUpdated.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// Models restituisce l'unione dei modelli serviti dai server disponibili
+func (p *pool) Models() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	var models []string
+	for _, m := range p.metrics {
+		if !m.Available {
+			continue
+		}
+		for _, model := range m.Models {
+			if !seen[model] {
+				seen[model] = true
+				models = append(models, model)
+			}
+		}
+	}
+	sort.Strings(models)
+	return models
+}
+
+// AddServer aggiunge un server al pool a runtime (es. nodo scoperto via mDNS)
+func (p *pool) AddServer(serverURL string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.discovered[serverURL] = true
+	if _, exists := p.metrics[serverURL]; exists {
+		return
+	}
+
+	p.servers = append(p.servers, serverURL)
+	p.metrics[serverURL] = &ServerMetrics{
+		URL:       serverURL,
+		Available: true,
+	}
+
+	p.log.WithField("server", serverURL).Info("Server " + p.label + " aggiunto al pool")
+}
+
+// RemoveServer rimuove un server aggiunto a runtime. I server statici
+// da configurazione non vengono mai rimossi.
+func (p *pool) RemoveServer(serverURL string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.discovered, serverURL)
+	if p.static[serverURL] {
+		return
+	}
+	p.removeServer(serverURL)
+}
+
+// SetStaticServers sostituisce i server da configurazione, ad esempio dopo un
+// reload: quelli nuovi vengono aggiunti e quelli tolti rimossi. Metriche,
+// inventario modelli e circuit breaker dei server rimasti sono mantenuti, i
+// nodi scoperti via mDNS non vengono toccati, anche se tolti dalla configurazione.
+func (p *pool) SetStaticServers(servers []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	static := make(map[string]bool, len(servers))
+	for _, serverURL := range servers {
+		static[serverURL] = true
+		if _, exists := p.metrics[serverURL]; exists {
+			continue
+		}
+		p.servers = append(p.servers, serverURL)
+		p.metrics[serverURL] = &ServerMetrics{
+			URL:       serverURL,
+			Available: true,
+		}
+		p.log.WithField("server", serverURL).Info("Server " + p.label + " aggiunto al pool")
+	}
+	for serverURL := range p.static {
+		if !static[serverURL] && !p.discovered[serverURL] {
+			p.removeServer(serverURL)
+		}
+	}
+	p.static = static
+}
+
+// removeServer elimina il server e il suo stato. Va chiamato con il mutex
+// acquisito in scrittura.
+func (p *pool) removeServer(serverURL string) {
+	if _, exists := p.metrics[serverURL]; !exists {
+		return
+	}
+
+	delete(p.metrics, serverURL)
+	delete(p.breakers, serverURL)
+	for i, s := range p.servers {
+		if s == serverURL {
+			p.servers = append(p.servers[:i:i], p.servers[i+1:]...)
+			break
+		}
+	}
+
+	p.log.WithField("server", serverURL).Info("Server " + p.label + " rimosso dal pool")
+}
+
+// SetServerAvailable imposta la disponibilità di un server in base a
+// un health check esterno (es. registry mDNS)
+func (p *pool) SetServerAvailable(serverURL string, available bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	metrics, ok := p.metrics[serverURL]
+	if !ok {
+		return
+	}
+	metrics.Available = available
+	if available {
+		metrics.ErrorCount = 0
+	}
+}
+
+// serverList restituisce una copia della lista server corrente
+func (p *pool) serverList() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	servers := make([]string, len(p.servers))
+	copy(servers, p.servers)
+	return servers
+}
+
+// GetMetrics restituisce le metriche correnti (per debugging/monitoring)
+func (p *pool) GetMetrics() map[string]*ServerMetrics {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	// Copia per evitare race conditions
+	result := make(map[string]*ServerMetrics)
+	for k, v := range p.metrics {
+		metricsCopy := *v
+		result[k] = &metricsCopy
+	}
+
+	return result
+}
@@ -0,0 +1,241 @@
+package loadbalancer
+
+import (
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+// CircuitState è lo stato del circuit breaker di un server
+type CircuitState int
+
+const (
+	// CircuitClosed: il server riceve traffico normalmente
+	CircuitClosed CircuitState = iota
+	// CircuitOpen: il server è escluso dalla selezione fino al cooldown
+	CircuitOpen
+	// CircuitHalfOpen: il server riceve solo un numero limitato di richieste di prova
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	}
+	return "closed"
+}
+
+// CircuitObserver riceve le transizioni di stato dei circuit breaker di un
+// pool. Viene chiamato con il lock del pool acquisito: non deve richiamare il
+// load balancer.
+type CircuitObserver func(serverURL string, from, to CircuitState)
+
+// breakerBuckets è il numero di intervalli in cui è divisa la finestra del tasso di errore
+const breakerBuckets = 10
+
+// breakerSettings sono i parametri del circuit breaker con le durate già convertite
+type breakerSettings struct {
+	consecutiveFailures int
+	errorRateThreshold  float64
+	minRequests         int
+	window              time.Duration
+	cooldown            time.Duration
+	halfOpenProbes      int
+}
+
+// defaultBreakerSettings corrisponde ai default di config.CircuitBreakerConfig
+func defaultBreakerSettings() breakerSettings {
+	return newBreakerSettings(config.CircuitBreakerConfig{})
+}
+
+// newBreakerSettings converte la configurazione, usando i default per i valori non impostati
+func newBreakerSettings(cfg config.CircuitBreakerConfig) breakerSettings {
+	s := breakerSettings{
+		consecutiveFailures: cfg.ConsecutiveFailures,
+		errorRateThreshold:  cfg.ErrorRateThreshold,
+		minRequests:         cfg.MinRequests,
+		window:              time.Duration(cfg.Window) * time.Second,
+		cooldown:            time.Duration(cfg.Cooldown) * time.Second,
+		halfOpenProbes:      cfg.HalfOpenProbes,
+	}
+	if s.consecutiveFailures <= 0 {
+		s.consecutiveFailures = 3
+	}
+	if s.errorRateThreshold <= 0 {
+		s.errorRateThreshold = 0.5
+	}
+	if s.minRequests <= 0 {
+		s.minRequests = 10
+	}
+	if s.window <= 0 {
+		s.window = 60 * time.Second
+	}
+	if s.cooldown <= 0 {
+		s.cooldown = 30 * time.Second
+	}
+	if s.halfOpenProbes <= 0 {
+		s.halfOpenProbes = 1
+	}
+	return s
+}
+
+// breakerBucket conta gli esiti di un intervallo della finestra
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// breaker è il circuit breaker di un singolo server. Non ha lock propri: è
+// sempre usato con il mutex del pool acquisito.
+type breaker struct {
+	state       CircuitState
+	consecutive int
+	changedAt   time.Time // ultima transizione, riferimento per il cooldown
+	buckets     [breakerBuckets]breakerBucket
+
+	// Half-open: prove in corso e successi ottenuti
+	probes    int
+	successes int
+}
+
+// allow indica se il server può ricevere una richiesta. Scaduto il cooldown
+// il circuito passa in half-open; le prove sono limitate a halfOpenProbes in
+// corso. Una prova senza esito (es. richiesta annullata) libera il posto dopo
+// un altro cooldown.
+func (b *breaker) allow(now time.Time, s breakerSettings) (ok bool, from CircuitState, changed bool) {
+	from = b.state
+	switch b.state {
+	case CircuitClosed:
+		return true, from, false
+	case CircuitOpen:
+		if now.Sub(b.changedAt) < s.cooldown {
+			return false, from, false
+		}
+		b.setState(CircuitHalfOpen, now)
+		changed = true
+	case CircuitHalfOpen:
+		if b.probes > 0 && now.Sub(b.changedAt) >= s.cooldown {
+			b.probes = 0
+			b.changedAt = now
+		}
+	}
+	return b.probes < s.halfOpenProbes, from, changed
+}
+
+// admit registra l'invio di una richiesta di prova in half-open
+func (b *breaker) admit() {
+	if b.state == CircuitHalfOpen {
+		b.probes++
+	}
+}
+
+// success registra una risposta valida ricevuta dal proxy; in half-open
+// conta come prova riuscita
+func (b *breaker) success(now time.Time, s breakerSettings) (from CircuitState, changed bool) {
+	from = b.state
+	b.consecutive = 0
+
+	switch b.state {
+	case CircuitClosed:
+		b.bucket(now, s).successes++
+		return from, false
+	case CircuitOpen:
+		// Un health check riuscito prima del cooldown non riapre il traffico
+		if now.Sub(b.changedAt) < s.cooldown {
+			return from, false
+		}
+		b.setState(CircuitHalfOpen, now)
+	}
+
+	if b.probes > 0 {
+		b.probes--
+	}
+	b.successes++
+	if b.successes >= s.halfOpenProbes {
+		b.setState(CircuitClosed, now)
+	}
+	return from, b.state != from
+}
+
+// healthy registra un health check riuscito. Scaduto il cooldown il server
+// torna selezionabile in half-open, ma solo le risposte del proxy chiudono il
+// circuito: un nodo che risponde a /api/tags o /metrics può ancora fallire
+// l'inferenza.
+func (b *breaker) healthy(now time.Time, s breakerSettings) (from CircuitState, changed bool) {
+	from = b.state
+	switch b.state {
+	case CircuitClosed:
+		return b.success(now, s)
+	case CircuitOpen:
+		if now.Sub(b.changedAt) >= s.cooldown {
+			b.setState(CircuitHalfOpen, now)
+			return from, true
+		}
+	}
+	return from, false
+}
+
+// failure registra un errore; apre il circuito oltre le soglie configurate
+func (b *breaker) failure(now time.Time, s breakerSettings) (from CircuitState, changed bool) {
+	from = b.state
+	b.consecutive++
+
+	switch b.state {
+	case CircuitClosed:
+		b.bucket(now, s).failures++
+		if b.consecutive >= s.consecutiveFailures || b.errorRateExceeded(now, s) {
+			b.setState(CircuitOpen, now)
+			return from, true
+		}
+		return from, false
+	case CircuitHalfOpen:
+		b.setState(CircuitOpen, now)
+		return from, true
+	}
+
+	// Già aperto: il server è ancora guasto, il cooldown riparte
+	b.changedAt = now
+	return from, false
+}
+
+// errorRateExceeded valuta il tasso di errore sugli esiti nella finestra
+func (b *breaker) errorRateExceeded(now time.Time, s breakerSettings) bool {
+	var successes, failures int
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < s.window {
+			successes += bk.successes
+			failures += bk.failures
+		}
+	}
+	total := successes + failures
+	return total >= s.minRequests && float64(failures)/float64(total) >= s.errorRateThreshold
+}
+
+// bucket restituisce l'intervallo della finestra corrispondente a now
+func (b *breaker) bucket(now time.Time, s breakerSettings) *breakerBucket {
+	width := s.window / breakerBuckets
+	if width <= 0 {
+		width = time.Nanosecond
+	}
+	start := now.Truncate(width)
+	bk := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bk.start.Equal(start) {
+		*bk = breakerBucket{start: start}
+	}
+	return bk
+}
+
+// setState cambia stato azzerando i contatori della fase precedente
+func (b *breaker) setState(state CircuitState, now time.Time) {
+	b.state = state
+	b.changedAt = now
+	b.probes = 0
+	b.successes = 0
+	if state == CircuitClosed {
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+}
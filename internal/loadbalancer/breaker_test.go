@@ -0,0 +1,163 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+func TestPool_CircuitBreakerLifecycle(t *testing.T) {
+	server := "http://vllm1:8000"
+	lb := NewVLLMLoadBalancer([]string{server}, 30, newTestLogger())
+
+	var mu sync.Mutex
+	var transitions []string
+	lb.SetCircuitObserver(func(url string, from, to CircuitState) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, from.String()+"->"+to.String())
+	})
+	lb.mutex.Lock()
+	lb.breakerSettings.cooldown = 50 * time.Millisecond
+	lb.mutex.Unlock()
+
+	for i := 0; i < 3; i++ {
+		lb.ReportFailure(server, fmt.Errorf("connection refused"))
+	}
+	if _, err := lb.SelectServer(); err == nil {
+		t.Fatal("Expected open circuit to exclude the server")
+	}
+
+	// A successful poll during the cooldown does not bring the server back
+	lb.mutex.Lock()
+	lb.recordSuccess(server, lb.metrics[server])
+	lb.mutex.Unlock()
+	if m := lb.GetMetrics()[server]; m.Available || m.Circuit != CircuitOpen {
+		t.Fatalf("Expected circuit to stay open during cooldown, got %v", m.Circuit)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	// Half-open: a single probe request is let through
+	if _, err := lb.SelectServer(); err != nil {
+		t.Fatalf("Expected probe request in half-open, got %v", err)
+	}
+	if _, err := lb.SelectServer(); err == nil {
+		t.Error("Expected only one concurrent probe in half-open")
+	}
+
+	lb.ReportSuccess(server)
+	if m := lb.GetMetrics()[server]; !m.Available || m.Circuit != CircuitClosed {
+		t.Errorf("Expected circuit closed after successful probe, got %v", m.Circuit)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"closed->open", "open->half_open", "half_open->closed"}
+	if fmt.Sprint(transitions) != fmt.Sprint(want) {
+		t.Errorf("Expected transitions %v, got %v", want, transitions)
+	}
+}
+
+func TestPool_CircuitBreakerHalfOpenFailure(t *testing.T) {
+	server := "http://ollama1:11434"
+	lb := NewOllamaLoadBalancer([]string{server}, 30, newTestLogger())
+	lb.mutex.Lock()
+	lb.breakerSettings.cooldown = 20 * time.Millisecond
+	lb.mutex.Unlock()
+
+	for i := 0; i < 3; i++ {
+		lb.ReportFailure(server, fmt.Errorf("status code: 502"))
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if _, err := lb.SelectServer(); err != nil {
+		t.Fatalf("Expected probe request, got %v", err)
+	}
+	lb.ReportFailure(server, fmt.Errorf("status code: 502"))
+
+	if m := lb.GetMetrics()[server]; m.Available || m.Circuit != CircuitOpen {
+		t.Errorf("Expected failed probe to reopen the circuit, got %v", m.Circuit)
+	}
+}
+
+func TestPool_CircuitBreakerErrorRate(t *testing.T) {
+	server := "http://vllm1:8000"
+	lb := NewVLLMLoadBalancer([]string{server}, 30, newTestLogger())
+	err := lb.Configure(config.BalancerConfig{CircuitBreaker: config.CircuitBreakerConfig{
+		ConsecutiveFailures: 100,
+		ErrorRateThreshold:  0.5,
+		MinRequests:         6,
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Alternating outcomes never hit the consecutive limit, but the error rate does
+	for i := 0; i < 2; i++ {
+		lb.ReportSuccess(server)
+		lb.ReportFailure(server, fmt.Errorf("status code: 503"))
+	}
+	if m := lb.GetMetrics()[server]; !m.Available {
+		t.Fatal("Expected server available below min_requests")
+	}
+	lb.ReportSuccess(server)
+	lb.ReportFailure(server, fmt.Errorf("status code: 503"))
+
+	if m := lb.GetMetrics()[server]; m.Available || m.Circuit != CircuitOpen {
+		t.Errorf("Expected 50%% error rate to open the circuit, got %v", m.Circuit)
+	}
+}
+
+func TestPool_CircuitBreakerHealthCheckIsNotProbe(t *testing.T) {
+	server := "http://ollama1:11434"
+	lb := NewOllamaLoadBalancer([]string{server}, 30, newTestLogger())
+	lb.mutex.Lock()
+	lb.breakerSettings.cooldown = 20 * time.Millisecond
+	lb.mutex.Unlock()
+
+	poll := func() {
+		lb.mutex.Lock()
+		defer lb.mutex.Unlock()
+		lb.recordSuccess(server, lb.metrics[server])
+	}
+
+	for i := 0; i < 3; i++ {
+		lb.ReportFailure(server, fmt.Errorf("status code: 500"))
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// After the cooldown a passing health check makes the server eligible
+	// again, but only as half-open: it does not replace a real probe
+	poll()
+	poll()
+	if m := lb.GetMetrics()[server]; !m.Available || m.Circuit != CircuitHalfOpen {
+		t.Fatalf("Expected half-open after health checks, got %v", m.Circuit)
+	}
+	if _, err := lb.SelectServer(); err != nil {
+		t.Fatalf("Expected probe request, got %v", err)
+	}
+	poll()
+	if _, err := lb.SelectServer(); err == nil {
+		t.Error("Expected health check not to free the in-flight probe slot")
+	}
+
+	// The proxied probe still fails: the circuit opens again
+	lb.ReportFailure(server, fmt.Errorf("status code: 500"))
+	if m := lb.GetMetrics()[server]; m.Available || m.Circuit != CircuitOpen {
+		t.Fatalf("Expected circuit reopened after failed probe, got %v", m.Circuit)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	poll()
+	if _, err := lb.SelectServer(); err != nil {
+		t.Fatalf("Expected probe request, got %v", err)
+	}
+	lb.ReportSuccess(server)
+	if m := lb.GetMetrics()[server]; !m.Available || m.Circuit != CircuitClosed {
+		t.Errorf("Expected circuit closed after successful proxied probe, got %v", m.Circuit)
+	}
+}
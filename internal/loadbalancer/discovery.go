@@ -0,0 +1,59 @@
+package loadbalancer
+
+import (
+	"github.com/fzanti/aiconnect/internal/mdns"
+	"github.com/fzanti/aiconnect/internal/registry"
+	"github.com/sirupsen/logrus"
+)
+
+// DynamicPool è un pool di server che può essere modificato a runtime
+type DynamicPool interface {
+	AddServer(serverURL string)
+	RemoveServer(serverURL string)
+	SetServerAvailable(serverURL string, available bool)
+}
+
+// WatchRegistry collega un pool agli eventi del registry mDNS: i nodi del tipo
+// indicato vengono aggiunti, rimossi e marcati disponibili/non disponibili
+// affiancandosi ai server statici da configurazione.
+func WatchRegistry(reg *registry.Registry, nodeType registry.NodeType, pool DynamicPool, log *logrus.Logger) {
+	reg.OnEvent(func(e registry.Event) {
+		if e.Node == nil || e.Node.Type != nodeType {
+			return
+		}
+		syncNode(reg, e.Node, pool, log)
+	})
+
+	// Nodi già presenti nel registry prima della sottoscrizione
+	for _, node := range reg.GetNodesByType(nodeType) {
+		syncNode(reg, node, pool, log)
+	}
+}
+
+// syncNode allinea il pool allo stato corrente del nodo nel registry.
+// Gli eventi sono consegnati in modo asincrono e possono arrivare fuori
+// ordine, quindi si usa lo stato del registry invece del tipo di evento.
+func syncNode(reg *registry.Registry, node *registry.Node, pool DynamicPool, log *logrus.Logger) {
+	serverURL := mdns.GetServiceURL(node)
+
+	current, exists := reg.GetNode(node.Host, node.Port)
+	if !exists {
+		pool.RemoveServer(serverURL)
+		return
+	}
+
+	pool.AddServer(serverURL)
+
+	switch current.Status {
+	case registry.NodeStatusHealthy:
+		pool.SetServerAvailable(serverURL, true)
+	case registry.NodeStatusUnreachable:
+		pool.SetServerAvailable(serverURL, false)
+	}
+
+	log.WithFields(logrus.Fields{
+		"server": serverURL,
+		"type":   current.Type,
+		"status": current.Status,
+	}).Debug("Pool sincronizzato con registry mDNS")
+}
@@ -0,0 +1,171 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/registry"
+)
+
+// waitFor attende che la condizione diventi vera (gli eventi del registry sono asincroni)
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met before timeout")
+}
+
+func TestOllamaLoadBalancer_AddRemoveServer(t *testing.T) {
+	lb := NewOllamaLoadBalancer([]string{"http://static:11434"}, 30, newTestLogger())
+
+	lb.AddServer("http://dynamic:11434")
+	lb.AddServer("http://dynamic:11434") // idempotente
+
+	if len(lb.servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(lb.servers))
+	}
+	if !lb.metrics["http://dynamic:11434"].Available {
+		t.Error("Expected dynamic server to be initially available")
+	}
+
+	lb.RemoveServer("http://dynamic:11434")
+	if _, exists := lb.metrics["http://dynamic:11434"]; exists {
+		t.Error("Expected dynamic server to be removed")
+	}
+
+	// Static servers are never removed
+	lb.RemoveServer("http://static:11434")
+	if len(lb.servers) != 1 || lb.servers[0] != "http://static:11434" {
+		t.Errorf("Expected static server to be kept, got %v", lb.servers)
+	}
+}
+
+func TestOllamaLoadBalancer_SetStaticServers(t *testing.T) {
+	lb := NewOllamaLoadBalancer([]string{"http://a:11434", "http://b:11434"}, 30, newTestLogger())
+	lb.AddServer("http://dynamic:11434")
+	lb.Acquire("http://a:11434")
+	lb.metrics["http://a:11434"].Models = []string{"llama3"}
+
+	lb.SetStaticServers([]string{"http://a:11434", "http://c:11434"})
+
+	if _, exists := lb.metrics["http://b:11434"]; exists {
+		t.Error("Expected removed static server to leave the pool")
+	}
+	if _, exists := lb.metrics["http://c:11434"]; !exists {
+		t.Error("Expected new static server to join the pool")
+	}
+	if _, exists := lb.metrics["http://dynamic:11434"]; !exists {
+		t.Error("Expected discovered server to be kept")
+	}
+
+	// Servers still configured keep their state
+	m := lb.metrics["http://a:11434"]
+	if m.ActiveRequests != 1 || !lb.HasModel("llama3") {
+		t.Errorf("Expected metrics of kept server to be preserved, got %+v", m)
+	}
+
+	// The new static server is protected from mDNS removals
+	lb.RemoveServer("http://c:11434")
+	if _, exists := lb.metrics["http://c:11434"]; !exists {
+		t.Error("Expected new static server to survive RemoveServer")
+	}
+}
+
+func TestVLLMLoadBalancer_SetServerAvailable(t *testing.T) {
+	lb := NewVLLMLoadBalancer([]string{"http://vllm1:8000"}, 30, newTestLogger())
+
+	lb.SetServerAvailable("http://vllm1:8000", false)
+	if _, err := lb.SelectServer(); err == nil {
+		t.Error("Expected error with no available servers")
+	}
+
+	lb.SetServerAvailable("http://vllm1:8000", true)
+	server, err := lb.SelectServer()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server != "http://vllm1:8000" {
+		t.Errorf("Expected vllm1, got %s", server)
+	}
+
+	// Unknown servers are ignored
+	lb.SetServerAvailable("http://unknown:8000", true)
+}
+
+func TestWatchRegistry(t *testing.T) {
+	reg := registry.NewRegistry()
+
+	// Node already present before subscription
+	reg.AddNode(&registry.Node{Name: "early", Type: registry.NodeTypeOllama, Host: "10.0.0.1", Port: 11434})
+
+	lb := NewOllamaLoadBalancer([]string{"http://static:11434"}, 30, newTestLogger())
+	WatchRegistry(reg, registry.NodeTypeOllama, lb, newTestLogger())
+
+	hasServer := func(url string) bool {
+		lb.mutex.RLock()
+		defer lb.mutex.RUnlock()
+		_, ok := lb.metrics[url]
+		return ok
+	}
+	isAvailable := func(url string) bool {
+		lb.mutex.RLock()
+		defer lb.mutex.RUnlock()
+		m, ok := lb.metrics[url]
+		return ok && m.Available
+	}
+
+	if !hasServer("http://10.0.0.1:11434") {
+		t.Fatal("Expected pre-existing node to be added")
+	}
+
+	// Discovered node
+	reg.AddNode(&registry.Node{Name: "late", Type: registry.NodeTypeOllama, Host: "10.0.0.2", Port: 11434})
+	waitFor(t, func() bool { return hasServer("http://10.0.0.2:11434") })
+
+	// Nodes of other types are ignored
+	reg.AddNode(&registry.Node{Name: "vllm", Type: registry.NodeTypeVLLM, Host: "10.0.0.3", Port: 8000})
+
+	// Health transitions
+	reg.UpdateNodeStatus("10.0.0.2", 11434, registry.NodeStatusHealthy)
+	reg.IncrementErrorCount("10.0.0.2", 11434, 1)
+	waitFor(t, func() bool { return !isAvailable("http://10.0.0.2:11434") })
+
+	reg.UpdateNodeStatus("10.0.0.2", 11434, registry.NodeStatusHealthy)
+	waitFor(t, func() bool { return isAvailable("http://10.0.0.2:11434") })
+
+	// Lost node
+	reg.RemoveNode("10.0.0.2", 11434)
+	waitFor(t, func() bool { return !hasServer("http://10.0.0.2:11434") })
+
+	if hasServer("http://10.0.0.3:8000") {
+		t.Error("vLLM node should not be added to Ollama pool")
+	}
+	if !hasServer("http://static:11434") {
+		t.Error("Static server should still be in the pool")
+	}
+}
+
+func TestOllamaLoadBalancer_SetStaticServersKeepsDiscovered(t *testing.T) {
+	lb := NewOllamaLoadBalancer([]string{"http://a:11434", "http://b:11434"}, 30, newTestLogger())
+
+	// b is configured and also announced via mDNS
+	lb.AddServer("http://b:11434")
+	lb.Acquire("http://b:11434")
+
+	lb.SetStaticServers([]string{"http://a:11434"})
+	m, exists := lb.metrics["http://b:11434"]
+	if !exists || m.ActiveRequests != 1 {
+		t.Fatalf("Expected discovered server to keep its state after leaving the configuration, got %+v", m)
+	}
+
+	// No longer static: the mDNS removal now takes it out of the pool
+	lb.RemoveServer("http://b:11434")
+	if _, exists := lb.metrics["http://b:11434"]; exists {
+		t.Error("Expected server to leave the pool once no longer announced")
+	}
+}
@@ -0,0 +1,156 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Origine delle metriche di carico di un server
+const (
+	LoadSourceJSON       = "json"       // agent tools/ollama-metrics
+	LoadSourcePrometheus = "prometheus" // /metrics nativo di vLLM
+)
+
+// Pesi del punteggio vLLM, nella stessa scala di CPU/RAM/GPU in percentuale:
+// una richiesta in coda pesa più di una in esecuzione perché indica che il
+// server ha già saturato i batch
+const (
+	vllmRunningWeight = 10.0
+	vllmWaitingWeight = 25.0
+	vllmKVCacheWeight = 1.5
+)
+
+// maxMetricsBody limita la lettura di /metrics
+const maxMetricsBody = 4 << 20
+
+// errNoVLLMMetrics indica un /metrics Prometheus senza metriche vLLM
+var errNoVLLMMetrics = errors.New("nessuna metrica vllm:* in /metrics")
+
+// agentMetrics è il formato JSON dell'agent tools/ollama-metrics
+type agentMetrics struct {
+	CPUPercent   float64 `json:"cpu_percent"`
+	RAMPercent   float64 `json:"ram_percent"`
+	GPUCount     int     `json:"gpu_count"`
+	GPUAvgUtil   float64 `json:"gpu_avg_utilization_percent"`
+	GPUAvgMemory float64 `json:"gpu_avg_memory_percent"`
+	GPUs         []struct {
+		MemoryUsedMB  float64 `json:"memory_used_mb"`
+		MemoryTotalMB float64 `json:"memory_total_mb"`
+	} `json:"gpus"`
+}
+
+// weight calcola il peso totale: CPU + RAM + (GPU util * 1.5) + (GPU mem * 1.5).
+// GPU ha peso maggiore perché più critica per inferenza AI
+func (a agentMetrics) weight() float64 {
+	gpuWeight := 0.0
+	if a.GPUCount > 0 {
+		gpuWeight = (a.GPUAvgUtil * 1.5) + (a.GPUAvgMemory * 1.5)
+	}
+	return a.CPUPercent + a.RAMPercent + gpuWeight
+}
+
+// vram restituisce VRAM totale e libera in byte sommando tutte le GPU
+func (a agentMetrics) vram() (total, free int64) {
+	for _, g := range a.GPUs {
+		total += int64(g.MemoryTotalMB * (1 << 20))
+		free += int64((g.MemoryTotalMB - g.MemoryUsedMB) * (1 << 20))
+	}
+	return total, free
+}
+
+// vllmEngineMetrics sono le metriche del motore vLLM, sommate su tutti i modelli serviti
+type vllmEngineMetrics struct {
+	Running        float64 // vllm:num_requests_running
+	Waiting        float64 // vllm:num_requests_waiting (+ swapped)
+	KVCachePercent float64 // vllm:gpu_cache_usage_perc o vllm:kv_cache_usage_perc, 0-100
+}
+
+// weight calcola il peso da coda, batch in esecuzione e occupazione della KV cache
+func (v vllmEngineMetrics) weight() float64 {
+	return v.Running*vllmRunningWeight + v.Waiting*vllmWaitingWeight + v.KVCachePercent*vllmKVCacheWeight
+}
+
+// isJSONMetrics distingue il formato JSON dell'agent dal testo Prometheus
+func isJSONMetrics(contentType string, body []byte) bool {
+	return strings.HasPrefix(contentType, "application/json") || bytes.HasPrefix(bytes.TrimSpace(body), []byte("{"))
+}
+
+// parseAgentMetrics decodifica il JSON dell'agent tools/ollama-metrics
+func parseAgentMetrics(body []byte) (agentMetrics, error) {
+	var data agentMetrics
+	err := json.Unmarshal(body, &data)
+	return data, err
+}
+
+// parseVLLMPrometheus estrae le metriche di carico dal formato di esposizione Prometheus di vLLM
+func parseVLLMPrometheus(body []byte) (vllmEngineMetrics, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return vllmEngineMetrics{}, fmt.Errorf("parsing metriche Prometheus: %w", err)
+	}
+
+	var out vllmEngineMetrics
+	var found bool
+	sum := func(name string) (float64, bool) {
+		family, ok := families[name]
+		if !ok {
+			return 0, false
+		}
+		total := 0.0
+		for _, m := range family.GetMetric() {
+			total += metricValue(m)
+		}
+		return total, true
+	}
+
+	if v, ok := sum("vllm:num_requests_running"); ok {
+		out.Running, found = v, true
+	}
+	if v, ok := sum("vllm:num_requests_waiting"); ok {
+		out.Waiting, found = v, true
+	}
+	if v, ok := sum("vllm:num_requests_swapped"); ok {
+		out.Waiting += v
+		found = true
+	}
+	// Rinominata in vllm:kv_cache_usage_perc nelle versioni V1 del motore
+	for _, name := range []string{"vllm:gpu_cache_usage_perc", "vllm:kv_cache_usage_perc"} {
+		family, ok := families[name]
+		if !ok {
+			continue
+		}
+		// Con più modelli si considera la cache più piena
+		for _, m := range family.GetMetric() {
+			if v := metricValue(m) * 100; v > out.KVCachePercent {
+				out.KVCachePercent = v
+			}
+		}
+		found = true
+		break
+	}
+
+	if !found {
+		return vllmEngineMetrics{}, errNoVLLMMetrics
+	}
+	return out, nil
+}
+
+// metricValue restituisce il valore di un campione gauge, counter o untyped
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	case m.GetUntyped() != nil:
+		return m.GetUntyped().GetValue()
+	}
+	return 0
+}
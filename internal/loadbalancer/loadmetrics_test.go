@@ -0,0 +1,106 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const vllmPrometheusSample = `# HELP vllm:num_requests_running Number of requests currently running on GPU.
+# TYPE vllm:num_requests_running gauge
+vllm:num_requests_running{model_name="llama3"} 3.0
+vllm:num_requests_running{model_name="mistral"} 1.0
+# HELP vllm:num_requests_waiting Number of requests waiting to be processed.
+# TYPE vllm:num_requests_waiting gauge
+vllm:num_requests_waiting{model_name="llama3"} 2.0
+# HELP vllm:gpu_cache_usage_perc GPU KV-cache usage. 1 means 100 percent usage.
+# TYPE vllm:gpu_cache_usage_perc gauge
+vllm:gpu_cache_usage_perc{model_name="llama3"} 0.4
+vllm:gpu_cache_usage_perc{model_name="mistral"} 0.1
+# HELP vllm:prompt_tokens_total Number of prefill tokens processed.
+# TYPE vllm:prompt_tokens_total counter
+vllm:prompt_tokens_total{model_name="llama3"} 12345.0
+`
+
+func TestParseVLLMPrometheus(t *testing.T) {
+	m, err := parseVLLMPrometheus([]byte(vllmPrometheusSample))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m.Running != 4 || m.Waiting != 2 {
+		t.Errorf("Expected 4 running and 2 waiting, got %v and %v", m.Running, m.Waiting)
+	}
+	// The fullest cache across models is used
+	if m.KVCachePercent != 40 {
+		t.Errorf("Expected KV cache 40%%, got %v", m.KVCachePercent)
+	}
+	if want := 4*vllmRunningWeight + 2*vllmWaitingWeight + 40*vllmKVCacheWeight; m.weight() != want {
+		t.Errorf("Expected weight %v, got %v", want, m.weight())
+	}
+
+	// Newer engines expose kv_cache_usage_perc instead
+	m, err = parseVLLMPrometheus([]byte("vllm:kv_cache_usage_perc 0.25\n"))
+	if err != nil || m.KVCachePercent != 25 {
+		t.Errorf("Expected KV cache 25%%, got %v (err %v)", m.KVCachePercent, err)
+	}
+
+	// Prometheus text without vLLM metrics is not a load report
+	if _, err := parseVLLMPrometheus([]byte("process_cpu_seconds_total 1.5\n")); err != errNoVLLMMetrics {
+		t.Errorf("Expected errNoVLLMMetrics, got %v", err)
+	}
+	if _, err := parseVLLMPrometheus([]byte("not { valid")); err == nil {
+		t.Error("Expected parse error for invalid exposition format")
+	}
+}
+
+func TestVLLMLoadBalancer_CheckServer_PrometheusMetrics(t *testing.T) {
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(http.StatusOK)
+		case "/metrics":
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			w.Write([]byte(vllmPrometheusSample))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer busy.Close()
+	idle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(http.StatusOK)
+		case "/metrics":
+			w.Write([]byte("vllm:num_requests_running 0\nvllm:num_requests_waiting 0\nvllm:gpu_cache_usage_perc 0\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idle.Close()
+
+	lb := NewVLLMLoadBalancer([]string{busy.URL, idle.URL}, 30, newTestLogger())
+	lb.checkServer(busy.URL)
+	lb.checkServer(idle.URL)
+
+	m := lb.GetMetrics()[busy.URL]
+	if m.LoadSource != LoadSourcePrometheus {
+		t.Errorf("Expected prometheus load source, got %q", m.LoadSource)
+	}
+	if m.RequestsRunning != 4 || m.RequestsWaiting != 2 || m.KVCacheUsage != 40 {
+		t.Errorf("Unexpected engine metrics: running %d, waiting %d, kv %v", m.RequestsRunning, m.RequestsWaiting, m.KVCacheUsage)
+	}
+	if m.TotalWeight <= 0 {
+		t.Errorf("Expected positive weight for busy server, got %v", m.TotalWeight)
+	}
+
+	// An idle server has weight 0 but valid metrics: least-load must prefer it
+	for i := 0; i < 3; i++ {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if server != idle.URL {
+			t.Errorf("Expected idle server, got %s", server)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrModelNotFound indica che nessun server disponibile serve il modello richiesto
+var ErrModelNotFound = errors.New("modello non disponibile")
+
+// modelInfo descrive un modello dell'inventario di un server
+type modelInfo struct {
+	Name string
+	Size int64 // byte, 0 se non riportata (vLLM, OpenAI)
+}
+
+// fetchOllamaModels legge l'elenco dei modelli installati da /api/tags
+func fetchOllamaModels(client *http.Client, serverURL string) ([]modelInfo, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/api/tags", serverURL))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+			Size  int64  `json:"size"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	models := make([]modelInfo, 0, len(data.Models))
+	for _, m := range data.Models {
+		name := m.Name
+		if name == "" {
+			name = m.Model
+		}
+		if name != "" {
+			models = append(models, modelInfo{Name: name, Size: m.Size})
+		}
+	}
+	return models, nil
+}
+
+// fetchOpenAIModels legge l'elenco dei modelli serviti da /v1/models (vLLM e API compatibili OpenAI)
+func fetchOpenAIModels(client *http.Client, serverURL string) ([]modelInfo, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/v1/models", serverURL))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	models := make([]modelInfo, 0, len(data.Data))
+	for _, m := range data.Data {
+		if m.ID != "" {
+			models = append(models, modelInfo{Name: m.ID})
+		}
+	}
+	return models, nil
+}
+
+// normalizeModelName rende equivalenti "llama3" e "llama3:latest" (convenzione Ollama)
+func normalizeModelName(model string) string {
+	return strings.TrimSuffix(strings.TrimSpace(model), ":latest")
+}
+
+// hasModel verifica se un inventario contiene il modello richiesto
+func hasModel(models []string, model string) bool {
+	want := normalizeModelName(model)
+	for _, m := range models {
+		if normalizeModelName(m) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// modelNotFoundError costruisce l'errore restituito quando nessun server serve il modello
+func modelNotFoundError(backend, model string) error {
+	return fmt.Errorf("%w: %q su nessun server %s", ErrModelNotFound, model, backend)
+}
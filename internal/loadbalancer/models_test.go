@@ -0,0 +1,179 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHasModel(t *testing.T) {
+	models := []string{"llama3:latest", "mistral:7b", "meta-llama/Llama-3-8B"}
+
+	tests := []struct {
+		model    string
+		expected bool
+	}{
+		{"llama3", true},
+		{"llama3:latest", true},
+		{"mistral:7b", true},
+		{"mistral", false},
+		{"meta-llama/Llama-3-8B", true},
+		{"llama3:70b", false},
+	}
+
+	for _, tt := range tests {
+		if got := hasModel(models, tt.model); got != tt.expected {
+			t.Errorf("hasModel(%q) = %v, expected %v", tt.model, got, tt.expected)
+		}
+	}
+}
+
+func TestOllamaLoadBalancer_RefreshModels(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"models": []map[string]interface{}{
+				{"name": "llama3:latest", "model": "llama3:latest"},
+				{"name": "llama3:70b", "model": "llama3:70b"},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	lb := NewOllamaLoadBalancer([]string{mockServer.URL}, 30, newTestLogger())
+	lb.refreshModels(mockServer.URL)
+
+	metrics := lb.GetMetrics()[mockServer.URL]
+	if len(metrics.Models) != 2 {
+		t.Fatalf("Expected 2 models, got %v", metrics.Models)
+	}
+	if metrics.ModelsUpdated.IsZero() {
+		t.Error("Expected ModelsUpdated to be set")
+	}
+	if !lb.HasModel("llama3:70b") {
+		t.Error("Expected llama3:70b to be available")
+	}
+}
+
+func TestVLLMLoadBalancer_RefreshModels(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"data": []map[string]interface{}{
+				{"id": "meta-llama/Llama-3-8B-Instruct", "object": "model"},
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	lb := NewVLLMLoadBalancer([]string{mockServer.URL}, 30, newTestLogger())
+	lb.refreshModels(mockServer.URL)
+
+	models := lb.Models()
+	if len(models) != 1 || models[0] != "meta-llama/Llama-3-8B-Instruct" {
+		t.Errorf("Unexpected models: %v", models)
+	}
+}
+
+func TestOllamaLoadBalancer_SelectServerForModel(t *testing.T) {
+	servers := []string{"http://server1:11434", "http://server2:11434"}
+	lb := NewOllamaLoadBalancer(servers, 30, newTestLogger())
+
+	// server1 is less loaded but only server2 has the 70b model
+	lb.mutex.Lock()
+	lb.metrics["http://server1:11434"] = &ServerMetrics{
+		URL:           "http://server1:11434",
+		TotalWeight:   10.0,
+		Available:     true,
+		LastCheck:     time.Now(),
+		Models:        []string{"llama3:latest"},
+		ModelsUpdated: time.Now(),
+	}
+	lb.metrics["http://server2:11434"] = &ServerMetrics{
+		URL:           "http://server2:11434",
+		TotalWeight:   100.0,
+		Available:     true,
+		LastCheck:     time.Now(),
+		Models:        []string{"llama3:latest", "llama3:70b"},
+		ModelsUpdated: time.Now(),
+	}
+	lb.mutex.Unlock()
+
+	server, err := lb.SelectServerForModel("llama3:70b")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server != "http://server2:11434" {
+		t.Errorf("Expected server2 (has model), got %s", server)
+	}
+
+	server, err = lb.SelectServerForModel("llama3")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server != "http://server1:11434" {
+		t.Errorf("Expected server1 (least loaded with model), got %s", server)
+	}
+
+	_, err = lb.SelectServerForModel("mixtral")
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("Expected ErrModelNotFound, got %v", err)
+	}
+
+	// Excluded servers (already failed during a retry) are skipped
+	server, err = lb.SelectServerForModel("llama3", "http://server1:11434")
+	if err != nil || server != "http://server2:11434" {
+		t.Errorf("Expected server2 with server1 excluded, got %s (%v)", server, err)
+	}
+	if _, err := lb.SelectServerForModel("llama3:70b", "http://server2:11434"); err == nil || errors.Is(err, ErrModelNotFound) {
+		t.Errorf("Expected no-server error with the only candidate excluded, got %v", err)
+	}
+
+	// Unavailable servers do not count
+	lb.SetServerAvailable("http://server2:11434", false)
+	if _, err := lb.SelectServerForModel("llama3:70b"); !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("Expected ErrModelNotFound with server2 down, got %v", err)
+	}
+}
+
+func TestOllamaLoadBalancer_SelectServerForModel_UnknownInventory(t *testing.T) {
+	lb := NewOllamaLoadBalancer([]string{"http://known:11434"}, 30, newTestLogger())
+	lb.mutex.Lock()
+	lb.metrics["http://known:11434"].Models = []string{"llama3"}
+	lb.metrics["http://known:11434"].ModelsUpdated = time.Now()
+	lb.mutex.Unlock()
+
+	if _, err := lb.SelectServerForModel("mistral"); !errors.Is(err, ErrModelNotFound) {
+		t.Fatalf("Expected ErrModelNotFound with every inventory known, got %v", err)
+	}
+
+	// A server added before its first poll may serve the model
+	lb.AddServer("http://new:11434")
+	server, err := lb.SelectServerForModel("mistral")
+	if err != nil || server != "http://new:11434" {
+		t.Errorf("Expected server with unknown inventory, got %s (%v)", server, err)
+	}
+
+	// Servers known to have the model are preferred
+	if server, _ := lb.SelectServerForModel("llama3"); server != "http://known:11434" {
+		t.Errorf("Expected server with llama3 in its inventory, got %s", server)
+	}
+
+	// Same for static servers added by a reload
+	lb.RemoveServer("http://new:11434")
+	lb.SetStaticServers([]string{"http://known:11434", "http://static:11434"})
+	server, err = lb.SelectServerForModel("mistral")
+	if err != nil || server != "http://static:11434" {
+		t.Errorf("Expected new static server with unknown inventory, got %s (%v)", server, err)
+	}
+}
@@ -3,90 +3,29 @@ package loadbalancer
 import (
 	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// ServerMetrics contiene le metriche di carico di un server
-type ServerMetrics struct {
-	URL          string
-	CPUPercent   float64
-	RAMPercent   float64
-	GPUCount     int
-	GPUAvgUtil   float64
-	GPUAvgMemory float64
-	Available    bool
-	LastCheck    time.Time
-	ErrorCount   int
-	TotalWeight  float64 // Carico totale calcolato
-}
-
 // OllamaLoadBalancer gestisce il load balancing tra server Ollama
 type OllamaLoadBalancer struct {
-	servers         []string
-	metrics         map[string]*ServerMetrics
-	mutex           sync.RWMutex
-	log             *logrus.Logger
-	checkInterval   time.Duration
-	maxConsecErrors int
-	roundRobinIndex int
+	*pool
 }
 
 // NewOllamaLoadBalancer crea un nuovo load balancer
 func NewOllamaLoadBalancer(servers []string, checkInterval int, log *logrus.Logger) *OllamaLoadBalancer {
-	lb := &OllamaLoadBalancer{
-		servers:         servers,
-		metrics:         make(map[string]*ServerMetrics),
-		log:             log,
-		checkInterval:   time.Duration(checkInterval) * time.Second,
-		maxConsecErrors: 3,
-		roundRobinIndex: 0,
-	}
-
-	// Inizializza metriche per ogni server
-	for _, server := range servers {
-		lb.metrics[server] = &ServerMetrics{
-			URL:       server,
-			Available: true,
-		}
-	}
-
+	lb := &OllamaLoadBalancer{pool: newPool("Ollama", servers, checkInterval, log)}
+	lb.check = lb.poll
+	lb.fetchModels = fetchOllamaModels
 	return lb
 }
 
-// Start avvia il monitoraggio periodico dei server
-func (lb *OllamaLoadBalancer) Start() {
-	// Controllo iniziale
-	lb.checkAllServers()
-
-	// Avvia polling periodico
-	ticker := time.NewTicker(lb.checkInterval)
-	go func() {
-		for range ticker.C {
-			lb.checkAllServers()
-		}
-	}()
-
-	lb.log.WithField("interval", lb.checkInterval).Info("Load balancer Ollama avviato")
-}
-
-// checkAllServers controlla lo stato di tutti i server
-func (lb *OllamaLoadBalancer) checkAllServers() {
-	var wg sync.WaitGroup
-
-	for _, server := range lb.servers {
-		wg.Add(1)
-		go func(serverURL string) {
-			defer wg.Done()
-			lb.checkServer(serverURL)
-		}(server)
-	}
-
-	wg.Wait()
+// poll aggiorna carico e modelli residenti di un server
+func (lb *OllamaLoadBalancer) poll(serverURL string) {
+	lb.checkServer(serverURL)
+	lb.refreshLoaded(serverURL)
 }
 
 // checkServer controlla metriche di un singolo server
@@ -104,20 +43,20 @@ func (lb *OllamaLoadBalancer) checkServer(serverURL string) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		// Agent ollama-metrics assente (tipico dei nodi scoperti via mDNS):
+		// verifica solo che Ollama risponda
+		lb.checkLiveness(client, serverURL)
+		return
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		lb.handleServerError(serverURL, fmt.Errorf("status code: %d", resp.StatusCode))
 		return
 	}
 
 	// Parse JSON response
-	var data struct {
-		CPUPercent   float64 `json:"cpu_percent"`
-		RAMPercent   float64 `json:"ram_percent"`
-		GPUCount     int     `json:"gpu_count"`
-		GPUAvgUtil   float64 `json:"gpu_avg_utilization_percent"`
-		GPUAvgMemory float64 `json:"gpu_avg_memory_percent"`
-	}
-
+	var data agentMetrics
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
 		lb.handleServerError(serverURL, err)
 		return
@@ -127,24 +66,21 @@ func (lb *OllamaLoadBalancer) checkServer(serverURL string) {
 	lb.mutex.Lock()
 	defer lb.mutex.Unlock()
 
-	metrics := lb.metrics[serverURL]
+	metrics, ok := lb.metrics[serverURL]
+	if !ok {
+		return
+	}
 	metrics.CPUPercent = data.CPUPercent
 	metrics.RAMPercent = data.RAMPercent
 	metrics.GPUCount = data.GPUCount
 	metrics.GPUAvgUtil = data.GPUAvgUtil
 	metrics.GPUAvgMemory = data.GPUAvgMemory
+	metrics.TotalWeight = data.weight()
+	metrics.LoadSource = LoadSourceJSON
+	metrics.VRAMTotal, metrics.VRAMFree = data.vram()
 
-	// Calcola peso totale: CPU + RAM + (GPU util * 1.5) + (GPU mem * 1.5)
-	// GPU ha peso maggiore perché più critica per inferenza AI
-	gpuWeight := 0.0
-	if data.GPUCount > 0 {
-		gpuWeight = (data.GPUAvgUtil * 1.5) + (data.GPUAvgMemory * 1.5)
-	}
-	metrics.TotalWeight = data.CPUPercent + data.RAMPercent + gpuWeight
-
-	metrics.Available = true
 	metrics.LastCheck = time.Now()
-	metrics.ErrorCount = 0
+	lb.recordSuccess(serverURL, metrics)
 
 	lb.log.WithFields(logrus.Fields{
 		"server":    serverURL,
@@ -157,97 +93,64 @@ func (lb *OllamaLoadBalancer) checkServer(serverURL string) {
 	}).Debug("Metriche server aggiornate")
 }
 
-// handleServerError gestisce errori di comunicazione con il server
-func (lb *OllamaLoadBalancer) handleServerError(serverURL string, err error) {
-	lb.mutex.Lock()
-	defer lb.mutex.Unlock()
-
-	metrics := lb.metrics[serverURL]
-	metrics.ErrorCount++
-	metrics.LastCheck = time.Now()
-
-	if metrics.ErrorCount >= lb.maxConsecErrors {
-		metrics.Available = false
-		lb.log.WithFields(logrus.Fields{
-			"server":      serverURL,
-			"error_count": metrics.ErrorCount,
-			"error":       err.Error(),
-		}).Warn("Server marcato non disponibile")
-	} else {
-		lb.log.WithFields(logrus.Fields{
-			"server":      serverURL,
-			"error_count": metrics.ErrorCount,
-			"error":       err.Error(),
-		}).Debug("Errore comunicazione server")
+// checkLiveness verifica la raggiungibilità di Ollama tramite /api/tags quando
+// le metriche dettagliate non sono disponibili
+func (lb *OllamaLoadBalancer) checkLiveness(client *http.Client, serverURL string) {
+	resp, err := client.Get(fmt.Sprintf("%s/api/tags", serverURL))
+	if err != nil {
+		lb.handleServerError(serverURL, err)
+		return
 	}
-}
+	defer resp.Body.Close()
 
-// SelectServer seleziona il server migliore usando weighted least-load
-func (lb *OllamaLoadBalancer) SelectServer() (string, error) {
-	lb.mutex.RLock()
-	defer lb.mutex.RUnlock()
-
-	// Trova server disponibili
-	var availableServers []*ServerMetrics
-	for _, metrics := range lb.metrics {
-		if metrics.Available {
-			availableServers = append(availableServers, metrics)
-		}
+	if resp.StatusCode != http.StatusOK {
+		lb.handleServerError(serverURL, fmt.Errorf("status code: %d", resp.StatusCode))
+		return
 	}
 
-	if len(availableServers) == 0 {
-		return "", fmt.Errorf("nessun server Ollama disponibile")
-	}
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
 
-	// Se abbiamo metriche valide, usa weighted least-load
-	var hasMetrics bool
-	for _, m := range availableServers {
-		if !m.LastCheck.IsZero() {
-			hasMetrics = true
-			break
-		}
+	metrics, ok := lb.metrics[serverURL]
+	if !ok {
+		return
 	}
+	lb.recordSuccess(serverURL, metrics)
 
-	if hasMetrics {
-		// Trova server con carico minore
-		minWeight := math.MaxFloat64
-		var selectedServer string
-
-		for _, m := range availableServers {
-			if !m.LastCheck.IsZero() && m.TotalWeight < minWeight {
-				minWeight = m.TotalWeight
-				selectedServer = m.URL
-			}
-		}
-
-		if selectedServer != "" {
-			lb.log.WithFields(logrus.Fields{
-				"server": selectedServer,
-				"weight": minWeight,
-			}).Debug("Server selezionato (weighted least-load)")
-			return selectedServer, nil
-		}
-	}
+	lb.log.WithField("server", serverURL).Debug("Server Ollama disponibile (senza metriche dettagliate)")
+}
 
-	// Fallback: round-robin
-	selected := availableServers[lb.roundRobinIndex%len(availableServers)]
-	lb.roundRobinIndex++
+// refreshLoaded legge da /api/ps i modelli residenti in memoria. Le versioni di
+// Ollama senza /api/ps non sono un errore: la preferenza viene solo disattivata.
+func (lb *OllamaLoadBalancer) refreshLoaded(serverURL string) {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
 
-	lb.log.WithField("server", selected.URL).Debug("Server selezionato (round-robin fallback)")
-	return selected.URL, nil
-}
+	loaded, err := fetchOllamaLoaded(client, serverURL)
 
-// GetMetrics restituisce le metriche correnti (per debugging/monitoring)
-func (lb *OllamaLoadBalancer) GetMetrics() map[string]*ServerMetrics {
-	lb.mutex.RLock()
-	defer lb.mutex.RUnlock()
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
 
-	// Copia per evitare race conditions
-	result := make(map[string]*ServerMetrics)
-	for k, v := range lb.metrics {
-		metricsCopy := *v
-		result[k] = &metricsCopy
+	metrics, ok := lb.metrics[serverURL]
+	if !ok {
+		return
 	}
+	if err != nil {
+		metrics.LoadedModels = nil
+		metrics.VRAMUsed = 0
+		lb.log.WithFields(logrus.Fields{
+			"server": serverURL,
+			"error":  err.Error(),
+		}).Debug("Impossibile leggere modelli caricati Ollama")
+		return
+	}
+	metrics.LoadedModels = loaded.Names
+	metrics.VRAMUsed = loaded.VRAMUsed
 
-	return result
+	lb.log.WithFields(logrus.Fields{
+		"server":    serverURL,
+		"loaded":    loaded.Names,
+		"vram_used": loaded.VRAMUsed,
+	}).Debug("Modelli caricati Ollama aggiornati")
 }
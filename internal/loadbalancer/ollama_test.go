@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -424,3 +425,30 @@ func TestOllamaLoadBalancer_ErrorCountReset(t *testing.T) {
 		t.Errorf("Expected error count 0 after successful check, got %d", errorCountAfter)
 	}
 }
+
+func TestOllamaLoadBalancer_StartStop(t *testing.T) {
+	var polls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			polls.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"models":[]}`)
+	}))
+	defer server.Close()
+
+	lb := NewOllamaLoadBalancer([]string{server.URL}, 30, newTestLogger())
+	lb.checkInterval = 10 * time.Millisecond
+	lb.Start()
+
+	waitFor(t, func() bool { return polls.Load() >= 3 })
+	lb.Stop()
+	lb.Stop() // idempotent
+
+	// No poll runs once Stop has returned
+	stopped := polls.Load()
+	time.Sleep(50 * time.Millisecond)
+	if polls.Load() != stopped {
+		t.Errorf("Expected polling to stop, got %d polls after Stop", polls.Load()-stopped)
+	}
+}
@@ -0,0 +1,92 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// loadedModels è lo stato di /api/ps: modelli residenti in memoria e VRAM occupata
+type loadedModels struct {
+	Names    []string
+	VRAMUsed int64
+}
+
+// fetchOllamaLoaded legge da /api/ps i modelli attualmente caricati da Ollama
+func fetchOllamaLoaded(client *http.Client, serverURL string) (loadedModels, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/api/ps", serverURL))
+	if err != nil {
+		return loadedModels{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return loadedModels{}, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Models []struct {
+			Name     string `json:"name"`
+			Model    string `json:"model"`
+			SizeVRAM int64  `json:"size_vram"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return loadedModels{}, err
+	}
+
+	var out loadedModels
+	for _, m := range data.Models {
+		name := m.Name
+		if name == "" {
+			name = m.Model
+		}
+		if name == "" {
+			continue
+		}
+		out.Names = append(out.Names, name)
+		out.VRAMUsed += m.SizeVRAM
+	}
+	return out, nil
+}
+
+// fitsInVRAM indica se il modello può essere caricato nella VRAM libera del
+// server. La dimensione su disco approssima per difetto quella in VRAM; senza
+// VRAM o dimensione note il server non viene scartato.
+func fitsInVRAM(m *ServerMetrics, model string) bool {
+	if m.VRAMTotal <= 0 {
+		return true
+	}
+	size, ok := m.ModelSizes[normalizeModelName(model)]
+	if !ok {
+		return true
+	}
+	return size <= m.VRAMFree
+}
+
+// preferResident restringe i candidati ai server che hanno già il modello in
+// memoria, evitando un caricamento a freddo di decine di secondi; altrimenti a
+// quelli con VRAM libera sufficiente. La scelta finale resta alla strategia;
+// se nessun server soddisfa il criterio i candidati restano invariati.
+func preferResident(candidates []*ServerMetrics, model string) []*ServerMetrics {
+	if model == "" {
+		return candidates
+	}
+
+	var resident, fitting []*ServerMetrics
+	for _, m := range candidates {
+		switch {
+		case hasModel(m.LoadedModels, model):
+			resident = append(resident, m)
+		case fitsInVRAM(m, model):
+			fitting = append(fitting, m)
+		}
+	}
+	if len(resident) > 0 {
+		return resident
+	}
+	if len(fitting) > 0 {
+		return fitting
+	}
+	return candidates
+}
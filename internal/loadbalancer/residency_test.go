@@ -0,0 +1,105 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const gib = int64(1 << 30)
+
+func TestOllamaLoadBalancer_PollLoadedModels(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"cpu_percent": 10.0,
+				"ram_percent": 20.0,
+				"gpu_count":   1,
+				"gpus": []map[string]interface{}{
+					{"memory_used_mb": 6144.0, "memory_total_mb": 24576.0},
+				},
+			})
+		case "/api/ps":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"models": []map[string]interface{}{
+					{"name": "llama3:latest", "size_vram": 5 * gib},
+				},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+
+	lb := NewOllamaLoadBalancer([]string{mockServer.URL}, 30, newTestLogger())
+	lb.poll(mockServer.URL)
+
+	m := lb.GetMetrics()[mockServer.URL]
+	if !hasModel(m.LoadedModels, "llama3") {
+		t.Errorf("Expected llama3 to be resident, got %v", m.LoadedModels)
+	}
+	if m.VRAMUsed != 5*gib {
+		t.Errorf("Expected 5 GiB used by models, got %d", m.VRAMUsed)
+	}
+	if m.VRAMTotal != 24*gib || m.VRAMFree != 18*gib {
+		t.Errorf("Expected 24 GiB total and 18 GiB free, got %d and %d", m.VRAMTotal, m.VRAMFree)
+	}
+}
+
+func TestOllamaLoadBalancer_PrefersResidentModel(t *testing.T) {
+	servers := []string{"http://cold:11434", "http://small:11434", "http://warm:11434"}
+	lb := NewOllamaLoadBalancer(servers, 30, newTestLogger())
+
+	sizes := map[string]int64{"llama3": 5 * gib, "mistral": 4 * gib}
+	lb.mutex.Lock()
+	lb.metrics["http://cold:11434"] = &ServerMetrics{
+		URL: "http://cold:11434", Available: true, LastCheck: time.Now(), LoadSource: LoadSourceJSON,
+		TotalWeight: 10, Models: []string{"llama3", "mistral"}, ModelSizes: sizes,
+		VRAMTotal: 24 * gib, VRAMFree: 20 * gib,
+	}
+	lb.metrics["http://small:11434"] = &ServerMetrics{
+		URL: "http://small:11434", Available: true, LastCheck: time.Now(), LoadSource: LoadSourceJSON,
+		TotalWeight: 5, Models: []string{"llama3", "mistral"}, ModelSizes: sizes,
+		VRAMTotal: 8 * gib, VRAMFree: 2 * gib,
+	}
+	lb.metrics["http://warm:11434"] = &ServerMetrics{
+		URL: "http://warm:11434", Available: true, LastCheck: time.Now(), LoadSource: LoadSourceJSON,
+		TotalWeight: 80, Models: []string{"llama3", "mistral"}, ModelSizes: sizes,
+		LoadedModels: []string{"llama3:latest"}, VRAMTotal: 24 * gib, VRAMFree: 4 * gib,
+	}
+	lb.mutex.Unlock()
+
+	// Resident model wins over a less loaded server that would cold load it
+	if server, _ := lb.SelectServerForModel("llama3"); server != "http://warm:11434" {
+		t.Errorf("Expected server with llama3 resident, got %s", server)
+	}
+
+	// Not resident anywhere: least loaded among the servers with enough free VRAM
+	if server, _ := lb.SelectServerForModel("mistral"); server != "http://cold:11434" {
+		t.Errorf("Expected least loaded server with enough VRAM, got %s", server)
+	}
+
+	// The resident server is still used for failover when excluded
+	if server, _ := lb.SelectServerForModel("llama3", "http://warm:11434"); server != "http://cold:11434" {
+		t.Errorf("Expected failover to a server with enough VRAM, got %s", server)
+	}
+
+	// Without model the pool falls back to plain least-load
+	if server, _ := lb.SelectServer(); server != "http://small:11434" {
+		t.Errorf("Expected least loaded server, got %s", server)
+	}
+}
+
+func TestPreferResident_NoServerFits(t *testing.T) {
+	candidates := []*ServerMetrics{
+		{URL: "http://a", VRAMTotal: 8 * gib, VRAMFree: gib, ModelSizes: map[string]int64{"big": 40 * gib}},
+		{URL: "http://b", VRAMTotal: 8 * gib, VRAMFree: 2 * gib, ModelSizes: map[string]int64{"big": 40 * gib}},
+	}
+	// Ollama can still offload to CPU: never leave the request without candidates
+	if got := preferResident(candidates, "big"); len(got) != 2 {
+		t.Errorf("Expected all candidates kept, got %d", len(got))
+	}
+}
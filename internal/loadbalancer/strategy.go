@@ -0,0 +1,288 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+// Strategie di selezione dei server
+const (
+	StrategyWeightedLeastLoad = "weighted_least_load"
+	StrategyRoundRobin        = "round_robin"
+	StrategyLeastConnections  = "least_connections"
+	StrategyPowerOfTwo        = "p2c"
+	StrategyConsistentHash    = "consistent_hash"
+	StrategyStaticWeights     = "static_weights"
+)
+
+// Chiavi di hashing per consistent_hash
+const (
+	HashKeyUser  = "user"
+	HashKeyModel = "model"
+)
+
+// defaultInflightWeight è il peso di ogni richiesta in corso, nella stessa
+// scala di TotalWeight (punti percentuali di CPU/RAM/GPU)
+const defaultInflightWeight = 10.0
+
+// hashReplicas è il numero di nodi virtuali per server sull'anello di hashing
+const hashReplicas = 100
+
+// Strategy sceglie un server tra i candidati già filtrati (disponibili, con il
+// modello, non esclusi), ordinati per URL e mai vuoti. Viene chiamata con il
+// mutex del pool acquisito: lo stato interno non richiede lock propri.
+type Strategy interface {
+	Name() string
+	Select(candidates []*ServerMetrics, req SelectRequest) *ServerMetrics
+}
+
+// NewStrategy crea la strategia indicata dalla configurazione del pool
+func NewStrategy(cfg config.BalancerConfig) (Strategy, error) {
+	inflightWeight := cfg.InflightWeight
+	if inflightWeight <= 0 {
+		inflightWeight = defaultInflightWeight
+	}
+
+	switch cfg.Strategy {
+	case "", StrategyWeightedLeastLoad:
+		return newWeightedLeastLoad(inflightWeight), nil
+	case StrategyRoundRobin:
+		return &roundRobin{}, nil
+	case StrategyLeastConnections:
+		return &leastConnections{}, nil
+	case StrategyPowerOfTwo:
+		return &powerOfTwo{
+			inflightWeight: inflightWeight,
+			rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		}, nil
+	case StrategyConsistentHash:
+		key := cfg.HashKey
+		if key == "" {
+			key = HashKeyUser
+		}
+		if key != HashKeyUser && key != HashKeyModel {
+			return nil, fmt.Errorf("hash_key non valida: %q (user o model)", cfg.HashKey)
+		}
+		return &consistentHash{key: key}, nil
+	case StrategyStaticWeights:
+		for server, weight := range cfg.Weights {
+			if weight <= 0 {
+				return nil, fmt.Errorf("peso non valido per %s: %d", server, weight)
+			}
+		}
+		return &staticWeights{weights: cfg.Weights, current: make(map[string]int)}, nil
+	}
+	return nil, fmt.Errorf("strategia di load balancing sconosciuta: %q", cfg.Strategy)
+}
+
+// effectiveLoad combina il carico rilevato dal polling con le richieste in
+// corso, che riflettono subito i burst tra un health check e l'altro
+func effectiveLoad(m *ServerMetrics, inflightWeight float64) float64 {
+	return m.TotalWeight + float64(m.ActiveRequests)*inflightWeight
+}
+
+// hasLoadMetrics indica se il server ha riportato metriche di carico al polling.
+// Un server vLLM inattivo ha peso 0 ma metriche valide: conta la loro origine.
+func hasLoadMetrics(m *ServerMetrics) bool {
+	return m.LoadSource != "" || (!m.LastCheck.IsZero() && m.TotalWeight > 0)
+}
+
+// weightedLeastLoad sceglie il server con carico effettivo minore tra quelli
+// con metriche; se nessuno le ha ripiega su round-robin
+type weightedLeastLoad struct {
+	inflightWeight float64
+	fallback       roundRobin
+}
+
+func newWeightedLeastLoad(inflightWeight float64) *weightedLeastLoad {
+	return &weightedLeastLoad{inflightWeight: inflightWeight}
+}
+
+func (s *weightedLeastLoad) Name() string { return StrategyWeightedLeastLoad }
+
+func (s *weightedLeastLoad) Select(candidates []*ServerMetrics, req SelectRequest) *ServerMetrics {
+	minWeight := math.MaxFloat64
+	var selected *ServerMetrics
+	for _, m := range candidates {
+		if !hasLoadMetrics(m) {
+			continue
+		}
+		if load := effectiveLoad(m, s.inflightWeight); load < minWeight {
+			minWeight = load
+			selected = m
+		}
+	}
+	if selected != nil {
+		return selected
+	}
+	return s.fallback.Select(candidates, req)
+}
+
+// roundRobin alterna i candidati in ordine
+type roundRobin struct {
+	next int
+}
+
+func (s *roundRobin) Name() string { return StrategyRoundRobin }
+
+func (s *roundRobin) Select(candidates []*ServerMetrics, _ SelectRequest) *ServerMetrics {
+	selected := candidates[s.next%len(candidates)]
+	s.next++
+	return selected
+}
+
+// leastConnections sceglie il server con meno richieste in corso; a parità i
+// candidati vengono alternati in round-robin. Non richiede metriche di carico.
+type leastConnections struct {
+	ties roundRobin
+}
+
+func (s *leastConnections) Name() string { return StrategyLeastConnections }
+
+func (s *leastConnections) Select(candidates []*ServerMetrics, req SelectRequest) *ServerMetrics {
+	minActive := -1
+	var tied []*ServerMetrics
+	for _, m := range candidates {
+		switch {
+		case minActive < 0 || m.ActiveRequests < minActive:
+			minActive = m.ActiveRequests
+			tied = []*ServerMetrics{m}
+		case m.ActiveRequests == minActive:
+			tied = append(tied, m)
+		}
+	}
+	return s.ties.Select(tied, req)
+}
+
+// powerOfTwo (power of two choices) confronta due candidati casuali e sceglie
+// quello meno carico: evita l'effetto gregge del least-load puro
+type powerOfTwo struct {
+	inflightWeight float64
+	rand           *rand.Rand
+}
+
+func (s *powerOfTwo) Name() string { return StrategyPowerOfTwo }
+
+func (s *powerOfTwo) Select(candidates []*ServerMetrics, _ SelectRequest) *ServerMetrics {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := s.rand.Intn(len(candidates))
+	j := s.rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	if effectiveLoad(b, s.inflightWeight) < effectiveLoad(a, s.inflightWeight) {
+		return b
+	}
+	return a
+}
+
+// consistentHash assegna ogni utente (o modello) sempre allo stesso server
+// finché l'insieme dei candidati non cambia; quando un server esce, solo le
+// chiavi assegnate a lui vengono redistribuite. Senza chiave usa least-connections.
+type consistentHash struct {
+	key      string
+	fallback leastConnections
+
+	// Anello dell'ultimo insieme di candidati, ricostruito solo se cambia
+	ringKey string
+	ring    []uint32
+	owners  map[uint32]string
+}
+
+func (s *consistentHash) Name() string { return StrategyConsistentHash }
+
+func (s *consistentHash) Select(candidates []*ServerMetrics, req SelectRequest) *ServerMetrics {
+	key := req.User
+	if s.key == HashKeyModel {
+		key = normalizeModelName(req.Model)
+	}
+	if key == "" {
+		return s.fallback.Select(candidates, req)
+	}
+
+	s.buildRing(candidates)
+	h := hashString(key)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+
+	owner := s.owners[s.ring[i]]
+	for _, m := range candidates {
+		if m.URL == owner {
+			return m
+		}
+	}
+	return candidates[0]
+}
+
+// buildRing costruisce l'anello con hashReplicas nodi virtuali per server
+func (s *consistentHash) buildRing(candidates []*ServerMetrics) {
+	urls := make([]string, len(candidates))
+	for i, m := range candidates {
+		urls[i] = m.URL
+	}
+	ringKey := strings.Join(urls, "\n")
+	if ringKey == s.ringKey {
+		return
+	}
+
+	s.ringKey = ringKey
+	s.ring = s.ring[:0]
+	s.owners = make(map[uint32]string, len(urls)*hashReplicas)
+	for _, url := range urls {
+		for r := 0; r < hashReplicas; r++ {
+			h := hashString(url + "#" + strconv.Itoa(r))
+			if _, exists := s.owners[h]; exists {
+				continue
+			}
+			s.owners[h] = url
+			s.ring = append(s.ring, h)
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i] < s.ring[j] })
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// staticWeights distribuisce le richieste in proporzione ai pesi configurati
+// (smooth weighted round-robin); i server senza peso valgono 1
+type staticWeights struct {
+	weights map[string]int
+	current map[string]int
+}
+
+func (s *staticWeights) Name() string { return StrategyStaticWeights }
+
+func (s *staticWeights) Select(candidates []*ServerMetrics, _ SelectRequest) *ServerMetrics {
+	total := 0
+	var selected *ServerMetrics
+	for _, m := range candidates {
+		weight := s.weights[m.URL]
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		s.current[m.URL] += weight
+		if selected == nil || s.current[m.URL] > s.current[selected.URL] {
+			selected = m
+		}
+	}
+	s.current[selected.URL] -= total
+	return selected
+}
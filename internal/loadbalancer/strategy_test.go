@@ -0,0 +1,203 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+func TestOllamaLoadBalancer_InflightRequestsShiftLoad(t *testing.T) {
+	servers := []string{"http://server1:11434", "http://server2:11434"}
+	lb := NewOllamaLoadBalancer(servers, 30, newTestLogger())
+
+	// server1 is slightly less loaded according to the last poll
+	lb.mutex.Lock()
+	lb.metrics["http://server1:11434"] = &ServerMetrics{
+		URL:         "http://server1:11434",
+		TotalWeight: 40.0,
+		Available:   true,
+		LastCheck:   time.Now(),
+	}
+	lb.metrics["http://server2:11434"] = &ServerMetrics{
+		URL:         "http://server2:11434",
+		TotalWeight: 55.0,
+		Available:   true,
+		LastCheck:   time.Now(),
+	}
+	lb.mutex.Unlock()
+
+	// A burst between two polls must not all land on server1
+	var picks []string
+	for i := 0; i < 4; i++ {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		lb.Acquire(server)
+		picks = append(picks, server)
+	}
+
+	counts := make(map[string]int)
+	for _, p := range picks {
+		counts[p]++
+	}
+	if counts["http://server2:11434"] == 0 {
+		t.Errorf("Expected in-flight requests to spread the burst, got %v", picks)
+	}
+
+	for _, p := range picks {
+		lb.Release(p)
+	}
+	for url, m := range lb.GetMetrics() {
+		if m.ActiveRequests != 0 {
+			t.Errorf("Expected no active requests on %s, got %d", url, m.ActiveRequests)
+		}
+	}
+
+	// Release never goes below zero
+	lb.Release("http://server1:11434")
+	if m := lb.GetMetrics()["http://server1:11434"]; m.ActiveRequests != 0 {
+		t.Errorf("Expected active requests to stay at 0, got %d", m.ActiveRequests)
+	}
+}
+
+func TestVLLMLoadBalancer_LeastConnections(t *testing.T) {
+	servers := []string{"http://vllm1:8000", "http://vllm2:8000", "http://vllm3:8000"}
+	lb := NewVLLMLoadBalancer(servers, 30, newTestLogger())
+	if err := lb.Configure(config.BalancerConfig{Strategy: StrategyLeastConnections}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lb.Acquire("http://vllm1:8000")
+	lb.Acquire("http://vllm1:8000")
+	lb.Acquire("http://vllm2:8000")
+
+	server, err := lb.SelectServer()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server != "http://vllm3:8000" {
+		t.Errorf("Expected vllm3 (no active requests), got %s", server)
+	}
+
+	// Ties are rotated, not always resolved to the same server
+	lb.Release("http://vllm1:8000")
+	lb.Acquire("http://vllm3:8000")
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		server, _ := lb.SelectServer()
+		seen[server] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected tied servers to rotate, got %v", seen)
+	}
+}
+
+func TestConfigure_UnknownStrategy(t *testing.T) {
+	lb := NewOllamaLoadBalancer(nil, 30, newTestLogger())
+	if err := lb.Configure(config.BalancerConfig{Strategy: "random"}); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
+
+// newStrategyTestCandidates crea candidati ordinati per URL come li passa il pool
+func newStrategyTestCandidates(urls ...string) []*ServerMetrics {
+	candidates := make([]*ServerMetrics, 0, len(urls))
+	for _, u := range urls {
+		candidates = append(candidates, &ServerMetrics{URL: u, Available: true})
+	}
+	return candidates
+}
+
+func TestStrategy_RoundRobin(t *testing.T) {
+	s, _ := NewStrategy(config.BalancerConfig{Strategy: StrategyRoundRobin})
+	candidates := newStrategyTestCandidates("http://a", "http://b", "http://c")
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, s.Select(candidates, SelectRequest{}).URL)
+	}
+	want := []string{"http://a", "http://b", "http://c", "http://a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestStrategy_PowerOfTwo(t *testing.T) {
+	s, _ := NewStrategy(config.BalancerConfig{Strategy: StrategyPowerOfTwo})
+	candidates := newStrategyTestCandidates("http://a", "http://b")
+	candidates[0].ActiveRequests = 5
+
+	// With two candidates both are always compared: the idle one wins
+	for i := 0; i < 10; i++ {
+		if got := s.Select(candidates, SelectRequest{}).URL; got != "http://b" {
+			t.Fatalf("Expected less loaded server b, got %s", got)
+		}
+	}
+}
+
+func TestStrategy_ConsistentHash(t *testing.T) {
+	s, _ := NewStrategy(config.BalancerConfig{Strategy: StrategyConsistentHash})
+	all := newStrategyTestCandidates("http://a", "http://b", "http://c", "http://d")
+
+	users := []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi"}
+	assigned := make(map[string]string)
+	for _, u := range users {
+		assigned[u] = s.Select(all, SelectRequest{User: u}).URL
+		// Same user, same server
+		if again := s.Select(all, SelectRequest{User: u}).URL; again != assigned[u] {
+			t.Errorf("User %s moved from %s to %s", u, assigned[u], again)
+		}
+	}
+
+	// Removing a server only moves the users that were assigned to it
+	removed := assigned["alice"]
+	var remaining []*ServerMetrics
+	for _, m := range all {
+		if m.URL != removed {
+			remaining = append(remaining, m)
+		}
+	}
+	for _, u := range users {
+		got := s.Select(remaining, SelectRequest{User: u}).URL
+		if assigned[u] != removed && got != assigned[u] {
+			t.Errorf("User %s should stay on %s, moved to %s", u, assigned[u], got)
+		}
+		if got == removed {
+			t.Errorf("User %s assigned to removed server", u)
+		}
+	}
+
+	// Hashing by model ignores the user and treats llama3 and llama3:latest alike
+	s, _ = NewStrategy(config.BalancerConfig{Strategy: StrategyConsistentHash, HashKey: HashKeyModel})
+	first := s.Select(all, SelectRequest{Model: "llama3", User: "alice"}).URL
+	if got := s.Select(all, SelectRequest{Model: "llama3:latest", User: "bob"}).URL; got != first {
+		t.Errorf("Expected the same server for the same model, got %s and %s", first, got)
+	}
+}
+
+func TestStrategy_StaticWeights(t *testing.T) {
+	s, err := NewStrategy(config.BalancerConfig{
+		Strategy: StrategyStaticWeights,
+		Weights:  map[string]int{"http://a": 3},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	candidates := newStrategyTestCandidates("http://a", "http://b")
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[s.Select(candidates, SelectRequest{}).URL]++
+	}
+	if counts["http://a"] != 6 || counts["http://b"] != 2 {
+		t.Errorf("Expected a 3:1 split, got %v", counts)
+	}
+
+	if _, err := NewStrategy(config.BalancerConfig{Strategy: StrategyStaticWeights, Weights: map[string]int{"http://a": 0}}); err == nil {
+		t.Error("Expected error for non-positive weight")
+	}
+}
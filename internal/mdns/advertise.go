@@ -116,11 +116,13 @@ func (a *Advertiser) Start() error {
 	return nil
 }
 
-// Stop stops the mDNS advertisement
+// Stop withdraws the mDNS advertisement (goodbye packets) and stops the
+// responder. It is safe to call more than once.
 func (a *Advertiser) Stop() {
 	a.cancel()
 	if a.server != nil {
 		a.server.Shutdown()
+		a.server = nil
 		a.log.Info("mDNS advertisement stopped")
 	}
 }
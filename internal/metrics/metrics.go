@@ -11,10 +11,13 @@ import (
 type Manager struct {
 	authAttempts  *prometheus.CounterVec
 	authFailures  *prometheus.CounterVec
+	authCache     *prometheus.CounterVec
 	proxyRequests *prometheus.CounterVec
 	proxyErrors   *prometheus.CounterVec
 	proxyLatency  *prometheus.HistogramVec
 	backendHealth *prometheus.GaugeVec
+	circuitState  *prometheus.GaugeVec
+	circuitEvents *prometheus.CounterVec
 }
 
 // NewManager crea un nuovo manager delle metriche
@@ -36,6 +39,14 @@ func NewManager() *Manager {
 			[]string{"reason"},
 		),
 
+		authCache: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "aiconnect_auth_cache_lookups_total",
+				Help: "Consultazioni della cache degli esiti LDAP (hit, negative_hit, miss)",
+			},
+			[]string{"result"},
+		),
+
 		proxyRequests: promauto.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "aiconnect_proxy_requests_total",
@@ -68,6 +79,22 @@ func NewManager() *Manager {
 			},
 			[]string{"backend", "server"},
 		),
+
+		circuitState: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "aiconnect_circuit_breaker_state",
+				Help: "Stato circuit breaker per server (0=closed, 1=half_open, 2=open)",
+			},
+			[]string{"backend", "server"},
+		),
+
+		circuitEvents: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "aiconnect_circuit_breaker_transitions_total",
+				Help: "Numero totale di transizioni di stato dei circuit breaker",
+			},
+			[]string{"backend", "server", "state"},
+		),
 	}
 }
 
@@ -85,6 +112,11 @@ func (m *Manager) IncrementAuthFailures(reason string) {
 	m.authFailures.WithLabelValues(reason).Inc()
 }
 
+// IncrementAuthCache conta una consultazione della cache degli esiti LDAP
+func (m *Manager) IncrementAuthCache(result string) {
+	m.authCache.WithLabelValues(result).Inc()
+}
+
 // IncrementProxyRequests incrementa il contatore richieste proxy
 func (m *Manager) IncrementProxyRequests(backend string) {
 	m.proxyRequests.WithLabelValues(backend).Inc()
@@ -108,3 +140,16 @@ func (m *Manager) SetBackendHealth(backend, server string, healthy bool) {
 	}
 	m.backendHealth.WithLabelValues(backend, server).Set(value)
 }
+
+// RecordCircuitState registra una transizione del circuit breaker di un server
+func (m *Manager) RecordCircuitState(backend, server, state string) {
+	value := 0.0
+	switch state {
+	case "half_open":
+		value = 1.0
+	case "open":
+		value = 2.0
+	}
+	m.circuitState.WithLabelValues(backend, server).Set(value)
+	m.circuitEvents.WithLabelValues(backend, server, state).Inc()
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fzanti/aiconnect/internal/auth"
+	"github.com/sirupsen/logrus"
+)
+
+type originalPathKey struct{}
+
+// withOriginalPath conserva il path ricevuto dal client, che gli handler
+// riscrivono (es. /v1/... inoltrato a /ollama/v1/...)
+func withOriginalPath(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), originalPathKey{}, r.URL.Path))
+}
+
+// originalPath restituisce il path ricevuto dal client
+func originalPath(r *http.Request) string {
+	if p, ok := r.Context().Value(originalPathKey{}).(string); ok {
+		return p
+	}
+	return r.URL.Path
+}
+
+// authorize verifica che la credenziale della richiesta possa usare il modello
+// sul backend (scope delle API key e policy per gruppo). Se non è consentito
+// risponde 403.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, backend, model string) bool {
+	scopes, ok := auth.ScopesFromContext(r.Context())
+	if !ok || scopes.Allows(backend, model) {
+		return h.authorizePolicy(w, r, backend, model)
+	}
+
+	h.log.WithFields(logrus.Fields{
+		"user":    auth.UserFromContext(r.Context()),
+		"backend": backend,
+		"model":   model,
+		"path":    r.URL.Path,
+	}).Warn("Richiesta fuori dagli scope della API key")
+
+	msg := fmt.Sprintf("API key not allowed to use backend %s", backend)
+	if model != "" {
+		msg = fmt.Sprintf("API key not allowed to use model %q on backend %s", model, backend)
+	}
+	writeForbidden(w, r.URL.Path, msg)
+	return false
+}
+
+// authorizePolicy applica le policy per gruppo; backend e modello vuoti
+// verificano solo il path
+func (h *Handler) authorizePolicy(w http.ResponseWriter, r *http.Request, backend, model string) bool {
+	policies := h.current().policies
+	if !policies.Enabled() {
+		return true
+	}
+
+	req := policyRequest(r, backend, model)
+	decision := policies.Authorize(req)
+	if decision.Allowed {
+		return true
+	}
+
+	h.log.WithFields(logrus.Fields{
+		"user":    req.User,
+		"backend": backend,
+		"model":   model,
+		"path":    originalPath(r),
+		"reason":  decision.Reason,
+	}).Warn("Richiesta negata dalle policy di autorizzazione")
+
+	writeForbidden(w, r.URL.Path, "access denied by policy: "+decision.Reason)
+	return false
+}
+
+// allows applica gli stessi controlli di authorize senza rispondere; serve a
+// filtrare gli elenchi dei modelli
+func (h *Handler) allows(r *http.Request, backend, model string) bool {
+	if scopes, ok := auth.ScopesFromContext(r.Context()); ok && !scopes.Allows(backend, model) {
+		return false
+	}
+	return h.current().policies.Authorize(policyRequest(r, backend, model)).Allowed
+}
+
+// policyRequest descrive la richiesta da valutare con le policy per gruppo
+func policyRequest(r *http.Request, backend, model string) auth.PolicyRequest {
+	req := auth.PolicyRequest{Path: originalPath(r), Backend: backend, Model: model}
+	if id := auth.IdentityFromContext(r.Context()); id != nil {
+		req.User, req.Groups = id.User, id.Groups
+	}
+	return req
+}
+
+// writeForbidden risponde 403 nel formato di errore atteso dal client
+func writeForbidden(w http.ResponseWriter, path, msg string) {
+	for _, prefix := range []string{"/ollama", "/vllm", "/openai"} {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	if strings.HasPrefix(path, "/api/") {
+		writeOllamaError(w, http.StatusForbidden, msg)
+		return
+	}
+	writeOpenAIError(w, http.StatusForbidden, "forbidden", msg)
+}
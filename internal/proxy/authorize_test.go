@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/auth"
+	"github.com/fzanti/aiconnect/internal/config"
+)
+
+func TestHandler_APIKeyScopes(t *testing.T) {
+	backend := newOllamaBackend(t, "ollama1", "llama3", "mistral")
+	h, _ := newRetryTestHandler(t, 1, backend.URL)
+
+	serve := func(path, body string, scopes auth.Scopes) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req = req.WithContext(auth.WithScopes(req.Context(), scopes))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("/ollama/api/chat", `{"model":"llama3"}`, auth.Scopes{"ollama:llama3"}); rec.Code != http.StatusOK {
+		t.Errorf("Expected allowed model to pass, got %d", rec.Code)
+	}
+
+	rec := serve("/ollama/api/chat", `{"model":"mistral"}`, auth.Scopes{"ollama:llama3"})
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Errorf("Expected Ollama-style 403 for model outside scope, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// The unified endpoint is checked against the resolved backend
+	rec = serve("/v1/chat/completions", `{"model":"llama3"}`, auth.Scopes{"vllm"})
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"forbidden"`) {
+		t.Errorf("Expected OpenAI-style 403 for backend outside scope, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandler_APIKeyOnlyAuth(t *testing.T) {
+	backend := newOllamaBackend(t, "ollama1", "llama3", "mistral")
+	h, _ := newRetryTestHandler(t, 1, backend.URL)
+
+	// API keys are the only authentication method enabled
+	cfg := *h.current().cfg
+	adDisabled := false
+	cfg.AD.Enabled = &adDisabled
+	cfg.APIKeys.Enabled = true
+	h.Reload(&cfg)
+
+	store, err := auth.OpenKeyStore(filepath.Join(t.TempDir(), "api_keys.json"))
+	if err != nil {
+		t.Fatalf("Failed to open key store: %v", err)
+	}
+	token, _, err := store.Create("svc-rag", auth.OwnerService, "", []string{"ollama:llama3"}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	handler := auth.AuthMiddleware(&cfg, newTestLogger(), auth.Authenticators{APIKeys: store})(h)
+
+	serve := func(body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ollama/api/chat", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(`{"model":"llama3"}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without API key, got %d", rec.Code)
+	}
+	if rec := serve(`{"model":"llama3"}`, token); rec.Code != http.StatusOK {
+		t.Errorf("Expected model in scope to pass, got %d", rec.Code)
+	}
+	if rec := serve(`{"model":"mistral"}`, token); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for model outside key scope, got %d", rec.Code)
+	}
+}
+
+func TestHandler_GroupPolicies(t *testing.T) {
+	h := newUnifiedTestHandler(t, nil)
+	cfg := *h.current().cfg
+	cfg.Policies = []config.Policy{
+		{
+			Name:         "local-models",
+			Groups:       []string{"*"},
+			PathPrefixes: []string{"/ollama/", "/openai/", "/v1/", "/api/"},
+			Backends:     []string{"ollama"},
+		},
+		{
+			Name:     "research-openai",
+			Groups:   []string{"CN=Research,OU=Groups,DC=example,DC=com"},
+			Backends: []string{"openai"},
+			Models:   []string{"gpt-4*"},
+		},
+		{Name: "rag-service", Users: []string{"svc-rag"}, Backends: []string{"openai"}},
+	}
+	h.Reload(&cfg)
+
+	serve := func(method, path, body, user string, groups ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if user != "" {
+			req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{User: user, Groups: groups}))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	const aiUsers = "CN=AI-Users,OU=Groups,DC=example,DC=com"
+	const research = "CN=Research,OU=Groups,DC=example,DC=com"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		user   string
+		groups []string
+		want   int
+		reason string
+	}{
+		{"everyone uses Ollama", "POST", "/v1/chat/completions", `{"model":"llama3"}`, "alice", []string{aiUsers}, http.StatusOK, ""},
+		{"paid backend denied", "POST", "/v1/chat/completions", `{"model":"gpt-4o"}`, "alice", []string{aiUsers}, http.StatusForbidden, "backend openai"},
+		{"research uses gpt-4", "POST", "/v1/chat/completions", `{"model":"gpt-4o"}`, "bob", []string{aiUsers, research}, http.StatusOK, ""},
+		{"research limited to gpt-4", "POST", "/openai/v1/chat/completions", `{"model":"llama3"}`, "bob", []string{research}, http.StatusForbidden, `model \"llama3\"`},
+		{"service identity by user", "POST", "/openai/v1/chat/completions", `{"model":"llama3"}`, "svc-rag", nil, http.StatusOK, ""},
+		{"path outside prefixes", "GET", "/vllm/v1/models", "", "alice", []string{aiUsers}, http.StatusForbidden, "path /vllm/v1/models"},
+		{"model listing allowed", "GET", "/v1/models", "", "alice", []string{aiUsers}, http.StatusOK, ""},
+		{"anonymous denied", "GET", "/v1/models", "", "", nil, http.StatusForbidden, "no access policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.method, tt.path, tt.body, tt.user, tt.groups...)
+			if rec.Code != tt.want {
+				t.Fatalf("Expected %d, got %d %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.reason != "" && !strings.Contains(rec.Body.String(), tt.reason) {
+				t.Errorf("Expected reason %q in %s", tt.reason, rec.Body.String())
+			}
+		})
+	}
+
+	// Reloading without policies lifts the restrictions
+	cfg.Policies = nil
+	h.Reload(&cfg)
+	if rec := serve("GET", "/v1/models", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected access after reload without policies, got %d", rec.Code)
+	}
+}
+
+func TestHandler_IdentityHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			w.Write([]byte(`{"cpu_percent":10,"ram_percent":10}`))
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3"}]}`))
+		case "/api/ps":
+			w.Write([]byte(`{"models":[]}`))
+		default:
+			got = r.Header.Clone()
+		}
+	}))
+	t.Cleanup(backend.Close)
+
+	h, _ := newRetryTestHandler(t, 1, backend.URL)
+	key := strings.Repeat("k", 32)
+	h.current().cfg.IdentityHeaders.SigningKey = key
+
+	serve := func(id *auth.Identity) {
+		req := httptest.NewRequest(http.MethodPost, "/ollama/api/chat", strings.NewReader(`{"model":"llama3"}`))
+		req.Header.Set(auth.HeaderUser, "mallory")
+		req.Header.Set(auth.HeaderGroups, "Domain+Admins")
+		if id != nil {
+			req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+	}
+
+	// Headers sent by the client never reach the backend
+	serve(nil)
+	if got.Get(auth.HeaderUser) != "" || got.Get(auth.HeaderGroups) != "" || got.Get(auth.HeaderSignature) != "" {
+		t.Errorf("Expected spoofed identity headers to be dropped, got %v", got)
+	}
+
+	serve(&auth.Identity{User: "alice", Groups: []string{"CN=AI-Users,OU=Groups,DC=example,DC=com"}, Method: auth.MethodLDAP})
+	id, err := auth.VerifyIdentityHeaders(got, []byte(key), time.Minute, time.Now())
+	if err != nil {
+		t.Fatalf("Expected signed identity headers, got %v", err)
+	}
+	if id.User != "alice" || id.Method != auth.MethodLDAP || len(id.Groups) != 1 || id.Groups[0] != "CN=AI-Users,OU=Groups,DC=example,DC=com" {
+		t.Errorf("Unexpected identity forwarded: %+v", id)
+	}
+}
+
+func TestHandler_ModelListsFiltered(t *testing.T) {
+	h := newUnifiedTestHandler(t, nil)
+	cfg := *h.current().cfg
+	cfg.Policies = []config.Policy{
+		{Name: "local-models", Groups: []string{"*"}, Backends: []string{"ollama"}},
+	}
+	h.Reload(&cfg)
+
+	list := func(path string, ctx func(*http.Request) *http.Request) string {
+		req := ctx(httptest.NewRequest(http.MethodGet, path, nil))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %s", path, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	withPolicyUser := func(r *http.Request) *http.Request {
+		return r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{User: "alice"}))
+	}
+	withScopedKey := func(r *http.Request) *http.Request {
+		ctx := auth.WithIdentity(r.Context(), &auth.Identity{User: "svc-rag"})
+		return r.WithContext(auth.WithScopes(ctx, auth.Scopes{"openai:gpt-4o", "ollama:llama3*"}))
+	}
+
+	// Policies hide the models of backends the user cannot use
+	for _, path := range []string{"/v1/models", "/api/tags"} {
+		body := list(path, withPolicyUser)
+		if !strings.Contains(body, "llama3") || strings.Contains(body, "gpt-4o") {
+			t.Errorf("%s: expected only Ollama models, got %s", path, body)
+		}
+	}
+
+	// Key scopes are applied on top of the policies
+	cfg.Policies = nil
+	h.Reload(&cfg)
+	scoped := auth.Scopes{"ollama:mistral"}
+	body := list("/v1/models", func(r *http.Request) *http.Request {
+		return r.WithContext(auth.WithScopes(r.Context(), scoped))
+	})
+	if strings.Contains(body, "llama3") || strings.Contains(body, "gpt-4o") {
+		t.Errorf("Expected no models outside key scope, got %s", body)
+	}
+	if body := list("/api/tags", withScopedKey); !strings.Contains(body, "llama3") || !strings.Contains(body, "gpt-4o") {
+		t.Errorf("Expected models in key scope, got %s", body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models/gpt-4o", nil)
+	req = req.WithContext(auth.WithScopes(req.Context(), scoped))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for model outside key scope, got %d", rec.Code)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/fzanti/aiconnect/internal/auth"
 	"github.com/fzanti/aiconnect/internal/config"
 	"github.com/fzanti/aiconnect/internal/loadbalancer"
 	"github.com/fzanti/aiconnect/internal/metrics"
@@ -16,16 +18,43 @@ import (
 
 // Handler gestisce il routing e il proxying delle richieste
 type Handler struct {
-	cfg            *config.Config
+	state          atomic.Pointer[handlerState]
 	log            *logrus.Logger
-	ollamaLB       *loadbalancer.OllamaLoadBalancer
-	vllmLB         *loadbalancer.VLLMLoadBalancer
-	openaiProxy    *httputil.ReverseProxy
+	ollamaLB       loadbalancer.Balancer
+	vllmLB         loadbalancer.Balancer
+	upstreamClient *http.Client // richieste tradotte tra API Ollama e OpenAI
 	metricsManager *metrics.Manager
 }
 
+// handlerState è la parte dell'handler che dipende dalla configurazione,
+// sostituita in blocco da Reload: le richieste in corso terminano con lo
+// stato con cui sono iniziate
+type handlerState struct {
+	cfg          *config.Config
+	openaiProxy  *httputil.ReverseProxy
+	openaiModels *openAIModelCache
+	policies     *auth.PolicyEngine
+}
+
 // NewHandler crea un nuovo proxy handler
-func NewHandler(cfg *config.Config, log *logrus.Logger, ollamaLB *loadbalancer.OllamaLoadBalancer, vllmLB *loadbalancer.VLLMLoadBalancer, mm *metrics.Manager) *Handler {
+func NewHandler(cfg *config.Config, log *logrus.Logger, ollamaLB, vllmLB loadbalancer.Balancer, mm *metrics.Manager) *Handler {
+	h := &Handler{
+		log:            log,
+		ollamaLB:       ollamaLB,
+		vllmLB:         vllmLB,
+		upstreamClient: &http.Client{},
+		metricsManager: mm,
+	}
+	h.Reload(cfg)
+	return h
+}
+
+// Reload applica una nuova configurazione già validata (endpoint e API key
+// OpenAI, routing, retry, policy, firma degli header di identità). I pool di
+// server sono aggiornati a parte dai rispettivi load balancer.
+func (h *Handler) Reload(cfg *config.Config) {
+	log := h.log
+
 	// Configura proxy per OpenAI
 	openaiURL, _ := url.Parse(cfg.Backends.OpenAIEndpoint)
 	openaiProxy := httputil.NewSingleHostReverseProxy(openaiURL)
@@ -40,32 +69,52 @@ func NewHandler(cfg *config.Config, log *logrus.Logger, ollamaLB *loadbalancer.O
 		req.Header.Del("Authorization")
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.Backends.OpenAIAPIKey))
 
-		// Mantieni X-Forwarded-User per audit
-		if user := req.Header.Get("X-Forwarded-User"); user != "" {
-			req.Header.Set("X-Forwarded-User", user)
-		}
+		// Identità autenticata per audit
+		setIdentityHeaders(cfg, req)
 
 		log.WithFields(logrus.Fields{
-			"user":   req.Header.Get("X-Forwarded-User"),
+			"user":   auth.UserFromContext(req.Context()),
 			"path":   req.URL.Path,
 			"method": req.Method,
 		}).Debug("Proxying richiesta OpenAI")
 	}
 
-	return &Handler{
-		cfg:            cfg,
-		log:            log,
-		ollamaLB:       ollamaLB,
-		vllmLB:         vllmLB,
-		openaiProxy:    openaiProxy,
-		metricsManager: mm,
+	// Gestione errori
+	openaiProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.WithError(err).Error("Errore proxy OpenAI")
+		h.metricsManager.IncrementProxyErrors("openai")
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 	}
+
+	h.state.Store(&handlerState{
+		cfg:          cfg,
+		openaiProxy:  openaiProxy,
+		openaiModels: newOpenAIModelCache(cfg.Backends.OpenAIEndpoint, cfg.Backends.OpenAIAPIKey, time.Duration(cfg.Monitoring.HealthCheckInterval)*time.Second, log),
+		policies:     auth.NewPolicyEngine(cfg.Policies),
+	})
+}
+
+// current restituisce lo stato in uso
+func (h *Handler) current() *handlerState {
+	return h.state.Load()
+}
+
+// setIdentityHeaders sostituisce gli header di identità della richiesta verso il
+// backend con l'utente autenticato nel contesto, firmati se è configurata la chiave
+func setIdentityHeaders(cfg *config.Config, req *http.Request) {
+	auth.SetIdentityHeaders(req.Header, auth.IdentityFromContext(req.Context()), []byte(cfg.IdentityHeaders.SigningKey), time.Now())
 }
 
 // ServeHTTP implementa http.Handler per gestire le richieste
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
+	// Le policy sui path valgono anche per gli endpoint senza backend (elenchi modelli)
+	r = withOriginalPath(r)
+	if !h.authorizePolicy(w, r, "", "") {
+		return
+	}
+
 	// Routing basato su path
 	if strings.HasPrefix(r.URL.Path, "/ollama/") {
 		h.handleOllama(w, r, start)
@@ -73,6 +122,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleVLLM(w, r, start)
 	} else if strings.HasPrefix(r.URL.Path, "/openai/") {
 		h.handleOpenAI(w, r, start)
+	} else if strings.HasPrefix(r.URL.Path, "/v1/") {
+		h.handleUnified(w, r, start)
+	} else if strings.HasPrefix(r.URL.Path, "/api/") {
+		h.handleNative(w, r, start)
 	} else {
 		h.log.WithField("path", r.URL.Path).Warn("Path non riconosciuto")
 		http.NotFound(w, r)
@@ -81,69 +134,29 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleOllama gestisce richieste per backend Ollama
 func (h *Handler) handleOllama(w http.ResponseWriter, r *http.Request, start time.Time) {
-	// Seleziona server tramite load balancer
-	serverURL, err := h.ollamaLB.SelectServer()
-	if err != nil {
-		h.log.WithError(err).Error("Impossibile selezionare server Ollama")
-		h.metricsManager.IncrementProxyErrors("ollama")
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	// Crea proxy per il server selezionato
-	targetURL, _ := url.Parse(serverURL)
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-
-	// Configura director per modificare richiesta
-	proxy.Director = func(req *http.Request) {
-		req.URL.Scheme = targetURL.Scheme
-		req.URL.Host = targetURL.Host
-		req.Host = targetURL.Host
-
-		// Rimuovi prefisso /ollama/ dal path
-		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/ollama")
-		if req.URL.Path == "" {
-			req.URL.Path = "/"
+	// Legge il modello richiesto per gli endpoint di inferenza
+	var model string
+	if ollamaModelPaths[strings.TrimPrefix(r.URL.Path, "/ollama")] {
+		var err error
+		model, err = peekModel(r, h.maxBodyBytes())
+		if err != nil {
+			h.log.WithError(err).Warn("Impossibile leggere body richiesta Ollama")
+			status, _ := bodyError(err)
+			http.Error(w, http.StatusText(status), status)
+			return
 		}
-
-		// Rimuovi header Authorization (già autenticato)
-		req.Header.Del("Authorization")
-
-		// Mantieni X-Forwarded-* headers
-		if user := req.Header.Get("X-Forwarded-User"); user != "" {
-			req.Header.Set("X-Forwarded-User", user)
-		}
-		req.Header.Set("X-Forwarded-For", r.RemoteAddr)
-		req.Header.Set("X-Forwarded-Proto", "https")
-
-		h.log.WithFields(logrus.Fields{
-			"user":   req.Header.Get("X-Forwarded-User"),
-			"server": serverURL,
-			"path":   req.URL.Path,
-			"method": req.Method,
-		}).Debug("Proxying richiesta Ollama")
 	}
-
-	// Gestione errori proxy
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		h.log.WithFields(logrus.Fields{
-			"server": serverURL,
-			"error":  err.Error(),
-		}).Error("Errore proxy Ollama")
-		h.metricsManager.IncrementProxyErrors("ollama")
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	if !h.authorize(w, r, "ollama", model) {
+		return
 	}
 
-	// Esegui proxy
-	proxy.ServeHTTP(w, r)
-
-	// Registra latenza
-	duration := time.Since(start)
-	h.metricsManager.RecordLatency("ollama", duration)
-	h.log.WithFields(logrus.Fields{
-		"server":   serverURL,
-		"duration": duration.Milliseconds(),
-	}).Info("Richiesta Ollama completata")
+	// Inoltra a un server che serve il modello, con failover sugli altri
+	h.proxyToPool(w, r, start, poolTarget{
+		backend: "ollama",
+		label:   "Ollama",
+		prefix:  "/ollama",
+		pool:    h.ollamaLB,
+	}, model)
 }
 
 // handleOpenAI gestisce richieste per backend OpenAI
@@ -154,15 +167,39 @@ func (h *Handler) handleOpenAI(w http.ResponseWriter, r *http.Request, start tim
 		r.URL.Path = "/"
 	}
 
-	// Gestione errori
-	h.openaiProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		h.log.WithError(err).Error("Errore proxy OpenAI")
-		h.metricsManager.IncrementProxyErrors("openai")
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	// Client con API nativa Ollama: traduzione verso l'API OpenAI
+	if kind, ok := nativeKinds[r.URL.Path]; ok {
+		model, err := peekModel(r, h.maxBodyBytes())
+		if err != nil {
+			h.log.WithError(err).Warn("Impossibile leggere body richiesta OpenAI")
+			status, msg := bodyError(err)
+			writeOllamaError(w, status, msg)
+			return
+		}
+		if !h.authorize(w, r, "openai", model) {
+			return
+		}
+		h.serveNativeViaOpenAI(w, r, start, "openai", kind, model)
+		return
+	}
+
+	var model string
+	if unifiedInferencePaths[r.URL.Path] {
+		var err error
+		model, err = peekModel(r, h.maxBodyBytes())
+		if err != nil {
+			h.log.WithError(err).Warn("Impossibile leggere body richiesta OpenAI")
+			status, _ := bodyError(err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	if !h.authorize(w, r, "openai", model) {
+		return
 	}
 
 	// Esegui proxy
-	h.openaiProxy.ServeHTTP(w, r)
+	h.current().openaiProxy.ServeHTTP(w, r)
 
 	// Registra latenza
 	duration := time.Since(start)
@@ -172,67 +209,43 @@ func (h *Handler) handleOpenAI(w http.ResponseWriter, r *http.Request, start tim
 
 // handleVLLM gestisce richieste per backend vLLM
 func (h *Handler) handleVLLM(w http.ResponseWriter, r *http.Request, start time.Time) {
-	// Seleziona server tramite load balancer
-	serverURL, err := h.vllmLB.SelectServer()
-	if err != nil {
-		h.log.WithError(err).Error("Impossibile selezionare server vLLM")
-		h.metricsManager.IncrementProxyErrors("vllm")
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	// Client con API nativa Ollama: traduzione verso l'API OpenAI di vLLM
+	if kind, ok := nativeKinds[strings.TrimPrefix(r.URL.Path, "/vllm")]; ok {
+		model, err := peekModel(r, h.maxBodyBytes())
+		if err != nil {
+			h.log.WithError(err).Warn("Impossibile leggere body richiesta vLLM")
+			status, msg := bodyError(err)
+			writeOllamaError(w, status, msg)
+			return
+		}
+		if !h.authorize(w, r, "vllm", model) {
+			return
+		}
+		h.serveNativeViaOpenAI(w, r, start, "vllm", kind, model)
 		return
 	}
 
-	// Crea proxy per il server selezionato
-	targetURL, _ := url.Parse(serverURL)
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-
-	// Configura director per modificare richiesta
-	proxy.Director = func(req *http.Request) {
-		req.URL.Scheme = targetURL.Scheme
-		req.URL.Host = targetURL.Host
-		req.Host = targetURL.Host
-
-		// Rimuovi prefisso /vllm/ dal path
-		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/vllm")
-		if req.URL.Path == "" {
-			req.URL.Path = "/"
+	// Legge il modello richiesto per gli endpoint di inferenza
+	var model string
+	if vllmModelPaths[strings.TrimPrefix(r.URL.Path, "/vllm")] {
+		var err error
+		model, err = peekModel(r, h.maxBodyBytes())
+		if err != nil {
+			h.log.WithError(err).Warn("Impossibile leggere body richiesta vLLM")
+			status, _ := bodyError(err)
+			http.Error(w, http.StatusText(status), status)
+			return
 		}
-
-		// Rimuovi header Authorization (già autenticato)
-		req.Header.Del("Authorization")
-
-		// Mantieni X-Forwarded-* headers
-		if user := req.Header.Get("X-Forwarded-User"); user != "" {
-			req.Header.Set("X-Forwarded-User", user)
-		}
-		req.Header.Set("X-Forwarded-For", r.RemoteAddr)
-		req.Header.Set("X-Forwarded-Proto", "https")
-
-		h.log.WithFields(logrus.Fields{
-			"user":   req.Header.Get("X-Forwarded-User"),
-			"server": serverURL,
-			"path":   req.URL.Path,
-			"method": req.Method,
-		}).Debug("Proxying richiesta vLLM")
 	}
-
-	// Gestione errori proxy
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		h.log.WithFields(logrus.Fields{
-			"server": serverURL,
-			"error":  err.Error(),
-		}).Error("Errore proxy vLLM")
-		h.metricsManager.IncrementProxyErrors("vllm")
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	if !h.authorize(w, r, "vllm", model) {
+		return
 	}
 
-	// Esegui proxy
-	proxy.ServeHTTP(w, r)
-
-	// Registra latenza
-	duration := time.Since(start)
-	h.metricsManager.RecordLatency("vllm", duration)
-	h.log.WithFields(logrus.Fields{
-		"server":   serverURL,
-		"duration": duration.Milliseconds(),
-	}).Info("Richiesta vLLM completata")
+	// Inoltra a un server che serve il modello, con failover sugli altri
+	h.proxyToPool(w, r, start, poolTarget{
+		backend: "vllm",
+		label:   "vLLM",
+		prefix:  "/vllm",
+		pool:    h.vllmLB,
+	}, model)
 }
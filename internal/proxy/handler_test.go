@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/fzanti/aiconnect/internal/loadbalancer"
+	"github.com/fzanti/aiconnect/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	testMetricsOnce sync.Once
+	testMetrics     *metrics.Manager
+)
+
+// newTestMetrics restituisce un unico manager: le metriche Prometheus sono registrate globalmente
+func newTestMetrics() *metrics.Manager {
+	testMetricsOnce.Do(func() {
+		testMetrics = metrics.NewManager()
+	})
+	return testMetrics
+}
+
+func newTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel) // Suppress logs during tests
+	return log
+}
+
+// newOllamaBackend crea un finto server Ollama che serve i modelli indicati
+func newOllamaBackend(t *testing.T, name string, models ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			json.NewEncoder(w).Encode(map[string]interface{}{"cpu_percent": 10.0, "ram_percent": 10.0})
+		case "/api/tags":
+			list := make([]map[string]string, 0, len(models))
+			for _, m := range models {
+				list = append(list, map[string]string{"name": m})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": list})
+		case "/api/ps":
+			w.Write([]byte(`{"models":[]}`))
+		default:
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Backend", name)
+			w.Write(body)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPeekModel(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/ollama/api/chat", strings.NewReader(`{"model":"llama3:70b","messages":[]}`))
+
+	model, err := peekModel(req, 1<<10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if model != "llama3:70b" {
+		t.Errorf("Expected llama3:70b, got %q", model)
+	}
+
+	// Body must still be readable by the proxy
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != `{"model":"llama3:70b","messages":[]}` {
+		t.Errorf("Body not restored: %s", body)
+	}
+
+	// Non-JSON body yields no model
+	req = httptest.NewRequest(http.MethodPost, "/ollama/api/chat", strings.NewReader("not json"))
+	if model, err := peekModel(req, 1<<10); err != nil || model != "" {
+		t.Errorf("Expected empty model without error, got %q, %v", model, err)
+	}
+
+	// Bodies over the limit are rejected, with or without Content-Length
+	large := `{"model":"llama3","prompt":"` + strings.Repeat("x", 64) + `"}`
+	req = httptest.NewRequest(http.MethodPost, "/ollama/api/chat", strings.NewReader(large))
+	if _, err := peekModel(req, 32); !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("Expected errBodyTooLarge with Content-Length, got %v", err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/ollama/api/chat", io.NopCloser(strings.NewReader(large)))
+	req.ContentLength = -1
+	if _, err := peekModel(req, 32); !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("Expected errBodyTooLarge for chunked body, got %v", err)
+	}
+}
+
+func TestHandler_RequestBodyLimit(t *testing.T) {
+	backend := newOllamaBackend(t, "ollama1", "llama3")
+	h, _ := newRetryTestHandler(t, 1, backend.URL)
+	body := `{"model":"llama3","prompt":"` + strings.Repeat("x", 2<<20) + `"}`
+
+	for _, path := range []string{"/ollama/api/generate", "/v1/chat/completions", "/api/chat"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected 413 over retry.max_body_bytes, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestHandler_OllamaModelRouting(t *testing.T) {
+	small := newOllamaBackend(t, "small", "llama3:latest")
+	big := newOllamaBackend(t, "big", "llama3:latest", "llama3:70b")
+
+	log := newTestLogger()
+	ollamaLB := loadbalancer.NewOllamaLoadBalancer([]string{small.URL, big.URL}, 30, log)
+	ollamaLB.Start()
+	vllmLB := loadbalancer.NewVLLMLoadBalancer(nil, 30, log)
+
+	cfg := &config.Config{}
+	h := NewHandler(cfg, log, ollamaLB, vllmLB, newTestMetrics())
+
+	// Model available only on the "big" backend
+	req := httptest.NewRequest(http.MethodPost, "/ollama/api/chat", strings.NewReader(`{"model":"llama3:70b"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Backend"); got != "big" {
+		t.Errorf("Expected request routed to big backend, got %q", got)
+	}
+	if rec.Body.String() != `{"model":"llama3:70b"}` {
+		t.Errorf("Body not forwarded intact: %s", rec.Body.String())
+	}
+
+	// Unknown model returns 404 in Ollama error format
+	req = httptest.NewRequest(http.MethodPost, "/ollama/api/chat", strings.NewReader(`{"model":"mixtral"}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404, got %d", rec.Code)
+	}
+	var errBody map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&errBody); err != nil || !strings.Contains(errBody["error"], "mixtral") {
+		t.Errorf("Unexpected error body: %v (%v)", errBody, err)
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ollamaModelPaths sono gli endpoint Ollama il cui campo "model" determina il server
+var ollamaModelPaths = map[string]bool{
+	"/api/generate":        true,
+	"/api/chat":            true,
+	"/api/embed":           true,
+	"/api/embeddings":      true,
+	"/api/show":            true,
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+// vllmModelPaths sono gli endpoint vLLM (OpenAI-compatibili) instradati per modello
+var vllmModelPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/tokenize":            true,
+	"/detokenize":          true,
+}
+
+// defaultMaxBodyBytes è il limite dei body letti in memoria se retry.max_body_bytes non è impostato
+const defaultMaxBodyBytes = 10 << 20
+
+// errBodyTooLarge indica un body della richiesta oltre retry.max_body_bytes
+var errBodyTooLarge = errors.New("body della richiesta troppo grande")
+
+// maxBodyBytes restituisce il limite dei body della richiesta letti in memoria
+func (h *Handler) maxBodyBytes() int64 {
+	if limit := h.current().cfg.Retry.MaxBodyBytes; limit > 0 {
+		return limit
+	}
+	return defaultMaxBodyBytes
+}
+
+// readBody legge in memoria il body della richiesta fino a limit byte; oltre
+// restituisce errBodyTooLarge senza leggere il resto
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	defer r.Body.Close()
+	if r.ContentLength > limit {
+		return nil, errBodyTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("errore lettura body: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+// bodyError restituisce status e messaggio per un errore di lettura del body:
+// 413 oltre il limite, altrimenti 400
+func bodyError(err error) (int, string) {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge, "request body too large"
+	}
+	return http.StatusBadRequest, "unable to read request body"
+}
+
+// peekModel legge il campo JSON "model" dal body della richiesta senza consumarlo:
+// il body, al massimo limit byte, viene bufferizzato e ripristinato per il proxy.
+func peekModel(r *http.Request, limit int64) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", nil
+	}
+
+	body, err := readBody(r, limit)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	var payload struct {
+		Model string `json:"model"`
+	}
+	// Body non JSON: nessun modello, la richiesta viene instradata normalmente
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil
+	}
+	return strings.TrimSpace(payload.Model), nil
+}
+
+// writeModelNotFound risponde 404 nel formato di errore atteso dal client:
+// API nativa Ollama (/api/...) oppure OpenAI (vLLM ed endpoint /v1)
+func writeModelNotFound(w http.ResponseWriter, backend, path, model string) {
+	msg := fmt.Sprintf("model %q not found on any %s server", model, backend)
+	if strings.HasPrefix(path, "/api/") {
+		writeOllamaError(w, http.StatusNotFound, msg)
+		return
+	}
+	writeOpenAIError(w, http.StatusNotFound, "model_not_found", msg)
+}
+
+// writeOllamaError scrive un errore nel formato Ollama: {"error": "..."}
+func writeOllamaError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// writeOpenAIError scrive un errore nel formato OpenAI
+func writeOpenAIError(w http.ResponseWriter, status int, code, msg string) {
+	errType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errType = "server_error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": msg,
+			"type":    errType,
+			"param":   nil,
+			"code":    code,
+		},
+	})
+}
@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/auth"
+	"github.com/fzanti/aiconnect/internal/loadbalancer"
+	"github.com/sirupsen/logrus"
+)
+
+// maxErrorBody limita la lettura dei body di errore restituiti dai backend
+const maxErrorBody = 64 * 1024
+
+// maxTranslatedResponse limita le risposte non in streaming lette in memoria
+// per la traduzione; include i batch di embeddings più grandi
+const maxTranslatedResponse = 128 << 20
+
+// handleNative gestisce l'API nativa Ollama unificata (/api/...): il modello
+// viene risolto verso un pool e, se non è Ollama, la richiesta viene tradotta
+func (h *Handler) handleNative(w http.ResponseWriter, r *http.Request, start time.Time) {
+	if r.URL.Path == "/api/tags" && r.Method == http.MethodGet {
+		h.handleNativeTags(w, r)
+		return
+	}
+
+	kind, ok := nativeKinds[r.URL.Path]
+	if !ok {
+		writeOllamaError(w, http.StatusNotFound, fmt.Sprintf("unknown endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	model, err := peekModel(r, h.maxBodyBytes())
+	if err != nil {
+		h.log.WithError(err).Warn("Impossibile leggere body richiesta nativa")
+		status, msg := bodyError(err)
+		writeOllamaError(w, status, msg)
+		return
+	}
+	if model == "" {
+		writeOllamaError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	backend, ok := h.resolveBackend(model)
+	if !ok {
+		h.log.WithField("model", model).Warn("Modello non disponibile su nessun backend")
+		writeModelNotFound(w, "configured", r.URL.Path, model)
+		return
+	}
+
+	if backend == "ollama" {
+		r.URL.Path = "/ollama" + r.URL.Path
+		h.handleOllama(w, r, start)
+		return
+	}
+	if !h.authorize(w, r, backend, model) {
+		return
+	}
+	h.serveNativeViaOpenAI(w, r, start, backend, kind, model)
+}
+
+// handleNativeTags implementa GET /api/tags aggregando i modelli di tutti i pool
+func (h *Handler) handleNativeTags(w http.ResponseWriter, r *http.Request) {
+	type tag struct {
+		Name  string `json:"name"`
+		Model string `json:"model"`
+	}
+
+	entries := h.unifiedModels(r)
+	tags := make([]tag, 0, len(entries))
+	for _, e := range entries {
+		tags = append(tags, tag{Name: e.ID, Model: e.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"models": tags})
+}
+
+// selectUpstream restituisce il base URL del server da usare per il backend
+func (h *Handler) selectUpstream(backend string, req loadbalancer.SelectRequest) (string, error) {
+	if pool := h.pool(backend); pool != nil {
+		return pool.Select(req)
+	}
+	if backend == "openai" {
+		u, err := url.Parse(h.current().cfg.Backends.OpenAIEndpoint)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("openai_endpoint non valido")
+		}
+		return fmt.Sprintf("%s://%s", u.Scheme, u.Host), nil
+	}
+	return "", fmt.Errorf("backend sconosciuto: %s", backend)
+}
+
+// doUpstream invia la richiesta tradotta al backend
+func (h *Handler) doUpstream(r *http.Request, backend, serverURL, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, serverURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	cfg := h.current().cfg
+	req.Header.Set("Content-Type", "application/json")
+	setIdentityHeaders(cfg, req)
+	req.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if backend == "openai" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.Backends.OpenAIAPIKey))
+	}
+
+	h.log.WithFields(logrus.Fields{
+		"user":    auth.UserFromContext(r.Context()),
+		"backend": backend,
+		"server":  serverURL,
+		"path":    path,
+	}).Debug("Proxying richiesta tradotta")
+
+	return h.upstreamClient.Do(req)
+}
+
+// upstreamErrorMessage estrae il messaggio da un errore OpenAI, Ollama o testuale
+func upstreamErrorMessage(body []byte) string {
+	var openAIErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &openAIErr); err == nil && openAIErr.Error.Message != "" {
+		return openAIErr.Error.Message
+	}
+
+	var ollamaErr struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &ollamaErr); err == nil && ollamaErr.Error != "" {
+		return ollamaErr.Error
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// serveNativeViaOpenAI serve una richiesta nativa Ollama da un backend OpenAI-compatibile (vLLM o OpenAI)
+func (h *Handler) serveNativeViaOpenAI(w http.ResponseWriter, r *http.Request, start time.Time, backend, kind, model string) {
+	body, err := readBody(r, h.maxBodyBytes())
+	if err != nil {
+		status, msg := bodyError(err)
+		writeOllamaError(w, status, msg)
+		return
+	}
+
+	path, payload, stream, err := nativeToOpenAI(kind, body)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	resp, serverURL, err := h.sendUpstream(r, backend, model, path, payload)
+	if errors.Is(err, loadbalancer.ErrModelNotFound) {
+		writeModelNotFound(w, backend, "/api/", model)
+		return
+	}
+	if errors.Is(err, errNoUpstream) {
+		h.log.WithError(err).WithField("backend", backend).Error("Impossibile selezionare server")
+		h.metricsManager.IncrementProxyErrors(backend)
+		writeOllamaError(w, http.StatusServiceUnavailable, "service unavailable")
+		return
+	}
+	if err != nil {
+		h.log.WithFields(logrus.Fields{
+			"server": serverURL,
+			"error":  err.Error(),
+		}).Error("Errore proxy richiesta tradotta")
+		h.metricsManager.IncrementProxyErrors(backend)
+		writeOllamaError(w, http.StatusBadGateway, "bad gateway")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		writeOllamaError(w, resp.StatusCode, upstreamErrorMessage(errBody))
+	} else if stream {
+		if err := streamOpenAIToNative(w, resp.Body, kind, model, start); err != nil {
+			h.log.WithError(err).WithField("server", serverURL).Warn("Stream tradotto interrotto")
+		}
+	} else {
+		h.writeTranslated(w, resp.Body, func(data []byte) (interface{}, error) {
+			return openAIResponseToNative(kind, data, model, start)
+		}, writeOllamaError)
+	}
+
+	duration := time.Since(start)
+	h.metricsManager.RecordLatency(backend, duration)
+	h.log.WithFields(logrus.Fields{
+		"server":   serverURL,
+		"model":    model,
+		"duration": duration.Milliseconds(),
+	}).Info("Richiesta nativa Ollama servita da " + backend + " completata")
+}
+
+// serveOpenAIViaNative serve una richiesta OpenAI da un server Ollama tramite la sua API nativa
+func (h *Handler) serveOpenAIViaNative(w http.ResponseWriter, r *http.Request, start time.Time, kind, model string) {
+	body, err := readBody(r, h.maxBodyBytes())
+	if err != nil {
+		status, msg := bodyError(err)
+		writeOpenAIError(w, status, "invalid_request", msg)
+		return
+	}
+
+	path, payload, stream, includeUsage, err := openAIToNative(kind, body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	resp, serverURL, err := h.sendUpstream(r, "ollama", model, path, payload)
+	if errors.Is(err, loadbalancer.ErrModelNotFound) {
+		writeModelNotFound(w, "ollama", "/v1/", model)
+		return
+	}
+	if errors.Is(err, errNoUpstream) {
+		h.log.WithError(err).Error("Impossibile selezionare server Ollama")
+		h.metricsManager.IncrementProxyErrors("ollama")
+		writeOpenAIError(w, http.StatusServiceUnavailable, "service_unavailable", "service unavailable")
+		return
+	}
+	if err != nil {
+		h.log.WithFields(logrus.Fields{
+			"server": serverURL,
+			"error":  err.Error(),
+		}).Error("Errore proxy richiesta tradotta")
+		h.metricsManager.IncrementProxyErrors("ollama")
+		writeOpenAIError(w, http.StatusBadGateway, "bad_gateway", "bad gateway")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		writeOpenAIError(w, resp.StatusCode, "upstream_error", upstreamErrorMessage(errBody))
+	} else if stream {
+		if err := streamNativeToOpenAI(w, resp.Body, kind, model, includeUsage); err != nil {
+			h.log.WithError(err).WithField("server", serverURL).Warn("Stream tradotto interrotto")
+		}
+	} else {
+		h.writeTranslated(w, resp.Body, func(data []byte) (interface{}, error) {
+			return nativeResponseToOpenAI(kind, data, model)
+		}, func(w http.ResponseWriter, status int, msg string) {
+			writeOpenAIError(w, status, "upstream_error", msg)
+		})
+	}
+
+	duration := time.Since(start)
+	h.metricsManager.RecordLatency("ollama", duration)
+	h.log.WithFields(logrus.Fields{
+		"server":   serverURL,
+		"model":    model,
+		"duration": duration.Milliseconds(),
+	}).Info("Richiesta OpenAI servita da Ollama nativo completata")
+}
+
+// writeTranslated converte una risposta non in streaming, al massimo
+// maxTranslatedResponse byte, e la scrive come JSON
+func (h *Handler) writeTranslated(w http.ResponseWriter, body io.Reader, convert func([]byte) (interface{}, error), writeError func(http.ResponseWriter, int, string)) {
+	data, err := io.ReadAll(io.LimitReader(body, maxTranslatedResponse+1))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "error reading backend response")
+		return
+	}
+	if len(data) > maxTranslatedResponse {
+		h.log.WithField("limit", maxTranslatedResponse).Warn("Risposta backend troppo grande per la traduzione")
+		writeError(w, http.StatusBadGateway, "backend response too large")
+		return
+	}
+
+	out, err := convert(data)
+	if err != nil {
+		h.log.WithError(err).Warn("Risposta backend non traducibile")
+		writeError(w, http.StatusBadGateway, "invalid backend response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,329 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/auth"
+	"github.com/fzanti/aiconnect/internal/loadbalancer"
+	"github.com/sirupsen/logrus"
+)
+
+// poolTarget descrive un pool Ollama/vLLM verso cui inoltrare una richiesta
+type poolTarget struct {
+	backend string // etichetta metriche (ollama, vllm)
+	label   string // nome nei log (Ollama, vLLM)
+	prefix  string // prefisso del path da rimuovere (/ollama, /vllm)
+	pool    loadbalancer.Balancer
+}
+
+// errRetryableStatus fa scartare a ReverseProxy una risposta 502/503 da ritentare
+var errRetryableStatus = errors.New("risposta backend ritentabile")
+
+// errNoUpstream indica che non è stato possibile selezionare alcun server
+var errNoUpstream = errors.New("nessun server disponibile")
+
+// isRetryableStatus indica le risposte upstream che giustificano il failover
+func isRetryableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable
+}
+
+// bufferBody legge in memoria il body della richiesta per poterlo reinviare a
+// un altro server. Oltre limit il body viene ricostruito intatto e ok è false:
+// la richiesta viene inoltrata in streaming senza retry.
+func bufferBody(r *http.Request, limit int64) (body []byte, ok bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if r.ContentLength > limit {
+		return nil, false, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return nil, false, nil
+	}
+	r.Body.Close()
+	return data, true, nil
+}
+
+// maxAttempts restituisce il budget di tentativi per una richiesta
+func (h *Handler) maxAttempts(retryable bool) int {
+	attempts := h.current().cfg.Retry.MaxAttempts
+	if !retryable || attempts < 1 {
+		return 1
+	}
+	return attempts
+}
+
+// proxyToPool inoltra la richiesta a un server del pool. Errori di connessione e
+// risposte 502/503 vengono ritentati su un altro server (escludendo quelli già
+// falliti) finché il budget lo consente; ogni errore viene segnalato al load
+// balancer così i nodi guasti vengono declassati subito.
+func (h *Handler) proxyToPool(w http.ResponseWriter, r *http.Request, start time.Time, t poolTarget, model string) {
+	body, retryable, err := bufferBody(r, h.current().cfg.Retry.MaxBodyBytes)
+	if err != nil {
+		h.log.WithError(err).Warn("Impossibile leggere body richiesta " + t.label)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	maxAttempts := h.maxAttempts(retryable)
+
+	var tried []string
+	var lastStatus int
+	var serverURL string
+	for attempt := 1; ; attempt++ {
+		serverURL, err = t.pool.Select(loadbalancer.SelectRequest{
+			Model:   model,
+			User:    auth.UserFromContext(r.Context()),
+			Exclude: tried,
+		})
+		if err != nil && len(tried) > 0 {
+			h.log.WithFields(logrus.Fields{
+				"attempts": len(tried),
+				"error":    err.Error(),
+			}).Error("Nessun server " + t.label + " alternativo per il retry")
+			status := http.StatusBadGateway
+			if lastStatus != 0 {
+				status = lastStatus
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if errors.Is(err, loadbalancer.ErrModelNotFound) {
+			h.log.WithField("model", model).Warn("Modello non disponibile su nessun server " + t.label)
+			writeModelNotFound(w, t.backend, strings.TrimPrefix(r.URL.Path, t.prefix), model)
+			return
+		}
+		if err != nil {
+			h.log.WithError(err).Error("Impossibile selezionare server " + t.label)
+			h.metricsManager.IncrementProxyErrors(t.backend)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		}
+
+		failure, status, retry := h.proxyAttempt(w, r, t, serverURL, model, attempt < maxAttempts)
+		if failure == nil {
+			// Una richiesta annullata dal client non dice nulla sul server
+			if r.Context().Err() == nil {
+				t.pool.ReportSuccess(serverURL)
+			}
+			break
+		}
+
+		t.pool.ReportFailure(serverURL, failure)
+		h.metricsManager.IncrementProxyErrors(t.backend)
+		entry := h.log.WithFields(logrus.Fields{
+			"server":  serverURL,
+			"attempt": attempt,
+			"error":   failure.Error(),
+		})
+		if !retry {
+			entry.Error("Errore proxy " + t.label)
+			break
+		}
+		entry.Warn("Errore proxy " + t.label + ", nuovo tentativo su un altro server")
+		tried = append(tried, serverURL)
+		lastStatus = status
+	}
+
+	// Registra latenza
+	duration := time.Since(start)
+	h.metricsManager.RecordLatency(t.backend, duration)
+	h.log.WithFields(logrus.Fields{
+		"server":   serverURL,
+		"attempts": len(tried) + 1,
+		"duration": duration.Milliseconds(),
+	}).Info("Richiesta " + t.label + " completata")
+}
+
+// proxyAttempt esegue un singolo tentativo verso serverURL. ReverseProxy invoca
+// ModifyResponse ed ErrorHandler prima di scrivere header o body al client: se
+// canRetry è vero gli errori vengono solo registrati e retry indica al chiamante
+// che la richiesta può essere ripetuta su un altro server.
+func (h *Handler) proxyAttempt(w http.ResponseWriter, r *http.Request, t poolTarget, serverURL, model string, canRetry bool) (failure error, status int, retry bool) {
+	// Richiesta in corso: conta nel carico del server fino alla fine dello streaming
+	t.pool.Acquire(serverURL)
+	defer t.pool.Release(serverURL)
+
+	targetURL, _ := url.Parse(serverURL)
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+
+	// Configura director per modificare richiesta
+	proxy.Director = func(req *http.Request) {
+		req.URL.Scheme = targetURL.Scheme
+		req.URL.Host = targetURL.Host
+		req.Host = targetURL.Host
+
+		// Rimuovi prefisso del pool dal path
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, t.prefix)
+		if req.URL.Path == "" {
+			req.URL.Path = "/"
+		}
+
+		// Rimuovi header Authorization (già autenticato)
+		req.Header.Del("Authorization")
+
+		// Identità autenticata e X-Forwarded-* headers
+		setIdentityHeaders(h.current().cfg, req)
+		req.Header.Set("X-Forwarded-For", r.RemoteAddr)
+		req.Header.Set("X-Forwarded-Proto", "https")
+
+		h.log.WithFields(logrus.Fields{
+			"user":   auth.UserFromContext(req.Context()),
+			"server": serverURL,
+			"model":  model,
+			"path":   req.URL.Path,
+			"method": req.Method,
+		}).Debug("Proxying richiesta " + t.label)
+	}
+
+	// 502/503 dal backend: il server è sovraccarico o guasto
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if !isRetryableStatus(resp.StatusCode) {
+			return nil
+		}
+		failure = fmt.Errorf("status code: %d", resp.StatusCode)
+		status = resp.StatusCode
+		if canRetry {
+			return errRetryableStatus
+		}
+		return nil
+	}
+
+	// Gestione errori proxy
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		// Client disconnesso: non è un guasto del server
+		if req.Context().Err() != nil {
+			h.log.WithField("server", serverURL).Debug("Richiesta annullata dal client")
+			return
+		}
+		if !errors.Is(err, errRetryableStatus) {
+			failure = err
+		}
+		if canRetry {
+			retry = true
+			return
+		}
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	}
+
+	proxy.ServeHTTP(w, r)
+	return failure, status, retry
+}
+
+// sendUpstream seleziona un server e invia la richiesta tradotta, con failover
+// sugli altri server del pool per errori di connessione e risposte 502/503.
+// L'ultima risposta 502/503 viene restituita al chiamante invariata.
+func (h *Handler) sendUpstream(r *http.Request, backend, model, path string, payload []byte) (*http.Response, string, error) {
+	pool := h.pool(backend)
+	maxAttempts := 1
+	if pool != nil {
+		maxAttempts = h.maxAttempts(int64(len(payload)) <= h.current().cfg.Retry.MaxBodyBytes)
+	}
+
+	var tried []string
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		serverURL, err := h.selectUpstream(backend, loadbalancer.SelectRequest{
+			Model:   model,
+			User:    auth.UserFromContext(r.Context()),
+			Exclude: tried,
+		})
+		if err != nil {
+			if lastErr != nil {
+				return nil, "", lastErr
+			}
+			if errors.Is(err, loadbalancer.ErrModelNotFound) {
+				return nil, "", err
+			}
+			return nil, "", fmt.Errorf("%w: %w", errNoUpstream, err)
+		}
+
+		if pool != nil {
+			pool.Acquire(serverURL)
+		}
+		resp, err := h.doUpstream(r, backend, serverURL, path, payload)
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			if pool != nil {
+				pool.ReportSuccess(serverURL)
+				resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { pool.Release(serverURL) }}
+			}
+			return resp, serverURL, nil
+		}
+		if pool != nil {
+			pool.Release(serverURL)
+		}
+		if r.Context().Err() != nil {
+			return resp, serverURL, err
+		}
+
+		failure := err
+		if failure == nil {
+			failure = fmt.Errorf("status code: %d", resp.StatusCode)
+		}
+		if pool != nil {
+			pool.ReportFailure(serverURL, failure)
+		}
+		if attempt >= maxAttempts {
+			return resp, serverURL, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		h.metricsManager.IncrementProxyErrors(backend)
+		h.log.WithFields(logrus.Fields{
+			"server":  serverURL,
+			"attempt": attempt,
+			"error":   failure.Error(),
+		}).Warn("Errore proxy richiesta tradotta, nuovo tentativo su un altro server")
+		tried = append(tried, serverURL)
+		lastErr = failure
+	}
+}
+
+// releaseOnClose rilascia il server quando il body della risposta viene chiuso,
+// cioè al termine dello streaming verso il client
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// pool restituisce il load balancer del backend (nil per OpenAI, endpoint singolo)
+func (h *Handler) pool(backend string) loadbalancer.Balancer {
+	switch backend {
+	case "ollama":
+		return h.ollamaLB
+	case "vllm":
+		return h.vllmLB
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/fzanti/aiconnect/internal/loadbalancer"
+)
+
+// newFailingOllamaBackend crea un server Ollama sano per i poll ma che risponde
+// status alle richieste di inferenza, contando le richieste ricevute
+func newFailingOllamaBackend(t *testing.T, status int, hits *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			json.NewEncoder(w).Encode(map[string]interface{}{"cpu_percent": 10.0, "ram_percent": 10.0})
+		case "/api/tags":
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": []map[string]string{{"name": "llama3"}}})
+		case "/api/ps":
+			w.Write([]byte(`{"models":[]}`))
+		default:
+			atomic.AddInt32(hits, 1)
+			w.WriteHeader(status)
+			w.Write([]byte("overloaded"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newRetryTestHandler(t *testing.T, maxAttempts int, servers ...string) (*Handler, *loadbalancer.OllamaLoadBalancer) {
+	t.Helper()
+	log := newTestLogger()
+	ollamaLB := loadbalancer.NewOllamaLoadBalancer(servers, 30, log)
+	ollamaLB.Start()
+	vllmLB := loadbalancer.NewVLLMLoadBalancer(nil, 30, log)
+
+	cfg := &config.Config{}
+	cfg.Retry.MaxAttempts = maxAttempts
+	cfg.Retry.MaxBodyBytes = 1 << 20
+	return NewHandler(cfg, log, ollamaLB, vllmLB, newTestMetrics()), ollamaLB
+}
+
+func TestHandler_RetryOnUnavailableStatus(t *testing.T) {
+	var badHits int32
+	bad := newFailingOllamaBackend(t, http.StatusServiceUnavailable, &badHits)
+	good := newOllamaBackend(t, "good", "llama3")
+	h, lb := newRetryTestHandler(t, 2, bad.URL, good.URL)
+	// Alternate servers so the failing one is picked first at least once
+	lb.Configure(config.BalancerConfig{Strategy: loadbalancer.StrategyRoundRobin})
+
+	body := `{"model":"llama3","prompt":"hello"}`
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/ollama/api/generate", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200 after failover, got %d", i, rec.Code)
+		}
+		if got := rec.Header().Get("X-Backend"); got != "good" {
+			t.Errorf("Request %d: expected good backend, got %q", i, got)
+		}
+		// The buffered body must be replayed intact on the second server
+		if rec.Body.String() != body {
+			t.Errorf("Request %d: body not replayed, got %q", i, rec.Body.String())
+		}
+	}
+
+	if atomic.LoadInt32(&badHits) == 0 {
+		t.Fatal("Expected the failing server to be tried at least once")
+	}
+	if m := lb.GetMetrics()[bad.URL]; m.ErrorCount == 0 && m.Available {
+		t.Error("Expected proxy failures to be reported to the load balancer")
+	}
+}
+
+func TestHandler_RetryOnConnectionError(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+	good := newOllamaBackend(t, "good")
+	h, lb := newRetryTestHandler(t, 3, deadURL, good.URL)
+	lb.Configure(config.BalancerConfig{Strategy: loadbalancer.StrategyRoundRobin})
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/ollama/api/generate", strings.NewReader(`{}`))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Backend") != "good" {
+			t.Fatalf("Request %d: expected failover to good backend, got %d %q", i, rec.Code, rec.Header().Get("X-Backend"))
+		}
+	}
+}
+
+func TestHandler_RetryBudgetExhausted(t *testing.T) {
+	var hits int32
+	bad := newFailingOllamaBackend(t, http.StatusServiceUnavailable, &hits)
+	h, _ := newRetryTestHandler(t, 1, bad.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/ollama/api/generate", strings.NewReader(`{"model":"llama3"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	// Without retry budget the upstream response is passed through unchanged
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "overloaded" {
+		t.Errorf("Expected upstream 503 passed through, got %d %q", rec.Code, rec.Body.String())
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected a single attempt, got %d", hits)
+	}
+
+	// With budget but no alternative server the client gets the last status
+	h, _ = newRetryTestHandler(t, 3, bad.URL)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ollama/api/generate", strings.NewReader(`{"model":"llama3"}`)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when no other server is available, got %d", rec.Code)
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("small"))
+	body, ok, err := bufferBody(req, 10)
+	if err != nil || !ok || string(body) != "small" {
+		t.Errorf("Expected buffered body, got %q %v %v", body, ok, err)
+	}
+
+	// Larger than the limit: not retryable, but the body stays intact
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("this body is too large"))
+	req.ContentLength = -1
+	body, ok, err = bufferBody(req, 10)
+	if err != nil || ok || body != nil {
+		t.Fatalf("Expected unbuffered body, got %q %v %v", body, ok, err)
+	}
+	rest, _ := io.ReadAll(req.Body)
+	if string(rest) != "this body is too large" {
+		t.Errorf("Body not restored: %q", rest)
+	}
+}
+
+func TestHandler_TracksActiveRequests(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			http.NotFound(w, r)
+		case "/api/tags", "/api/ps":
+			w.Write([]byte(`{"models":[]}`))
+		default:
+			close(started)
+			<-unblock
+		}
+	}))
+	defer backend.Close()
+	h, lb := newRetryTestHandler(t, 1, backend.URL)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ollama/api/generate", strings.NewReader(`{}`)))
+	}()
+
+	<-started
+	if got := lb.GetMetrics()[backend.URL].ActiveRequests; got != 1 {
+		t.Errorf("Expected 1 active request while proxying, got %d", got)
+	}
+	close(unblock)
+	<-done
+
+	if got := lb.GetMetrics()[backend.URL].ActiveRequests; got != 0 {
+		t.Errorf("Expected 0 active requests after completion, got %d", got)
+	}
+}
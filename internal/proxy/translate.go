@@ -0,0 +1,967 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Tipi di richiesta gestiti dal layer di traduzione
+const (
+	kindChat       = "chat"
+	kindGenerate   = "generate"
+	kindEmbed      = "embed"
+	kindEmbeddings = "embeddings" // API Ollama legacy /api/embeddings
+	kindCompletion = "completion"
+)
+
+// nativeKinds associa gli endpoint nativi Ollama al tipo di richiesta
+var nativeKinds = map[string]string{
+	"/api/chat":       kindChat,
+	"/api/generate":   kindGenerate,
+	"/api/embed":      kindEmbed,
+	"/api/embeddings": kindEmbeddings,
+}
+
+// openAIKinds associa gli endpoint OpenAI al tipo di richiesta
+var openAIKinds = map[string]string{
+	"/v1/chat/completions": kindChat,
+	"/v1/completions":      kindCompletion,
+	"/v1/embeddings":       kindEmbed,
+}
+
+// --- API nativa Ollama ---
+
+type ollamaOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   *bool           `json:"stream,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    json.RawMessage `json:"tools,omitempty"`
+}
+
+type ollamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	System  string          `json:"system,omitempty"`
+	Images  []string        `json:"images,omitempty"`
+	Stream  *bool           `json:"stream,omitempty"`
+	Raw     bool            `json:"raw,omitempty"`
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options *ollamaOptions  `json:"options,omitempty"`
+}
+
+type ollamaEmbedRequest struct {
+	Model  string          `json:"model"`
+	Input  json.RawMessage `json:"input,omitempty"`
+	Prompt string          `json:"prompt,omitempty"` // solo /api/embeddings
+}
+
+// ollamaResponse copre le risposte di /api/chat (Message) e /api/generate (Response)
+type ollamaResponse struct {
+	Model           string         `json:"model"`
+	CreatedAt       string         `json:"created_at"`
+	Message         *ollamaMessage `json:"message,omitempty"`
+	Response        *string        `json:"response,omitempty"`
+	Done            bool           `json:"done"`
+	DoneReason      string         `json:"done_reason,omitempty"`
+	TotalDuration   int64          `json:"total_duration,omitempty"`
+	PromptEvalCount int            `json:"prompt_eval_count,omitempty"`
+	EvalCount       int            `json:"eval_count,omitempty"`
+	Error           string         `json:"error,omitempty"`
+}
+
+type ollamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings,omitempty"`
+	Embedding       []float64   `json:"embedding,omitempty"` // solo /api/embeddings
+	TotalDuration   int64       `json:"total_duration,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+// --- API OpenAI ---
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role,omitempty"`
+	Content    json.RawMessage  `json:"content,omitempty"` // stringa o array di parti
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+// openAIJSONSchema descrive lo schema di uno structured output; OpenAI
+// richiede il nome
+type openAIJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+type openAIRequest struct {
+	Model               string                `json:"model"`
+	Messages            []openAIMessage       `json:"messages,omitempty"`
+	Prompt              json.RawMessage       `json:"prompt,omitempty"`
+	Input               json.RawMessage       `json:"input,omitempty"`
+	Stream              bool                  `json:"stream,omitempty"`
+	StreamOptions       *openAIStreamOptions  `json:"stream_options,omitempty"`
+	Temperature         *float64              `json:"temperature,omitempty"`
+	TopP                *float64              `json:"top_p,omitempty"`
+	MaxTokens           *int                  `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                  `json:"max_completion_tokens,omitempty"`
+	Stop                json.RawMessage       `json:"stop,omitempty"`
+	Seed                *int                  `json:"seed,omitempty"`
+	PresencePenalty     *float64              `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64              `json:"frequency_penalty,omitempty"`
+	ResponseFormat      *openAIResponseFormat `json:"response_format,omitempty"`
+	Tools               json.RawMessage       `json:"tools,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIChoice struct {
+	Index        int            `json:"index"`
+	Message      *openAIMessage `json:"message,omitempty"`
+	Delta        *openAIMessage `json:"delta,omitempty"`
+	Text         *string        `json:"text,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type openAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIEmbedding struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+type openAIEmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []openAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  *openAIUsage      `json:"usage,omitempty"`
+}
+
+// --- Helper ---
+
+// textContent codifica una stringa come content JSON
+func textContent(s string) json.RawMessage {
+	data, _ := json.Marshal(s)
+	return data
+}
+
+// stringList decodifica un campo che può essere stringa o array di stringhe
+func stringList(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	return nil
+}
+
+// boolDefault restituisce il valore del puntatore o il default
+func boolDefault(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+// newCompletionID genera un id nello stile OpenAI
+func newCompletionID(prefix string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return prefix + "-" + hex.EncodeToString(buf)
+}
+
+// nowRFC3339 restituisce il timestamp nel formato created_at di Ollama
+func nowRFC3339() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// finishToDoneReason converte finish_reason OpenAI in done_reason Ollama
+func finishToDoneReason(reason string) string {
+	if reason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// doneToFinishReason converte done_reason Ollama in finish_reason OpenAI
+func doneToFinishReason(reason string, hasToolCalls bool) string {
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	if reason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// imageDataURL converte un'immagine base64 Ollama in data URL OpenAI
+func imageDataURL(b64 string) string {
+	mime := "image/jpeg"
+	if raw, err := base64.StdEncoding.DecodeString(b64); err == nil {
+		if detected := http.DetectContentType(raw); strings.HasPrefix(detected, "image/") {
+			mime = detected
+		}
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mime, b64)
+}
+
+// optionsToOpenAI copia le opzioni di sampling Ollama nella richiesta OpenAI
+func optionsToOpenAI(opts *ollamaOptions, req *openAIRequest) {
+	if opts == nil {
+		return
+	}
+	req.Temperature = opts.Temperature
+	req.TopP = opts.TopP
+	req.MaxTokens = opts.NumPredict
+	req.Seed = opts.Seed
+	req.PresencePenalty = opts.PresencePenalty
+	req.FrequencyPenalty = opts.FrequencyPenalty
+	if len(opts.Stop) > 0 {
+		req.Stop, _ = json.Marshal(opts.Stop)
+	}
+}
+
+// formatToOpenAI converte il campo format Ollama ("json" o JSON schema) in response_format
+func formatToOpenAI(format json.RawMessage) *openAIResponseFormat {
+	if len(format) == 0 || string(format) == "null" || string(format) == `""` {
+		return nil
+	}
+	if string(format) == `"json"` {
+		return &openAIResponseFormat{Type: "json_object"}
+	}
+	return &openAIResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &openAIJSONSchema{Name: "response", Schema: format},
+	}
+}
+
+// messageToOpenAI costruisce un messaggio OpenAI con eventuali immagini come parti
+func messageToOpenAI(m ollamaMessage) openAIMessage {
+	msg := openAIMessage{Role: m.Role}
+
+	if len(m.Images) == 0 {
+		msg.Content = textContent(m.Content)
+	} else {
+		parts := []map[string]interface{}{{"type": "text", "text": m.Content}}
+		for _, img := range m.Images {
+			parts = append(parts, map[string]interface{}{
+				"type":      "image_url",
+				"image_url": map[string]string{"url": imageDataURL(img)},
+			})
+		}
+		msg.Content, _ = json.Marshal(parts)
+	}
+
+	for i, tc := range m.ToolCalls {
+		call := openAIToolCall{ID: fmt.Sprintf("call_%d", i), Type: "function"}
+		call.Function.Name = tc.Function.Name
+		call.Function.Arguments = string(tc.Function.Arguments)
+		if call.Function.Arguments == "" {
+			call.Function.Arguments = "{}"
+		}
+		msg.ToolCalls = append(msg.ToolCalls, call)
+	}
+	return msg
+}
+
+// nativeToOpenAI traduce una richiesta nativa Ollama in una richiesta OpenAI.
+// Ritorna il path OpenAI, il body e se la risposta è in streaming.
+func nativeToOpenAI(kind string, body []byte) (string, []byte, bool, error) {
+	switch kind {
+	case kindChat:
+		var in ollamaChatRequest
+		if err := json.Unmarshal(body, &in); err != nil {
+			return "", nil, false, err
+		}
+		out := openAIRequest{
+			Model:          in.Model,
+			Stream:         boolDefault(in.Stream, true),
+			ResponseFormat: formatToOpenAI(in.Format),
+			Tools:          in.Tools,
+		}
+		for _, m := range in.Messages {
+			out.Messages = append(out.Messages, messageToOpenAI(m))
+		}
+		optionsToOpenAI(in.Options, &out)
+		if out.Stream {
+			out.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+		}
+		data, err := json.Marshal(out)
+		return "/v1/chat/completions", data, out.Stream, err
+
+	case kindGenerate:
+		var in ollamaGenerateRequest
+		if err := json.Unmarshal(body, &in); err != nil {
+			return "", nil, false, err
+		}
+		out := openAIRequest{
+			Model:          in.Model,
+			Stream:         boolDefault(in.Stream, true),
+			ResponseFormat: formatToOpenAI(in.Format),
+		}
+		optionsToOpenAI(in.Options, &out)
+		if out.Stream {
+			out.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+		}
+
+		path := "/v1/chat/completions"
+		if in.Raw {
+			// Prompt grezzo senza template: completions classiche
+			path = "/v1/completions"
+			out.Prompt = textContent(in.Prompt)
+			out.ResponseFormat = nil
+		} else {
+			if in.System != "" {
+				out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: textContent(in.System)})
+			}
+			out.Messages = append(out.Messages, messageToOpenAI(ollamaMessage{Role: "user", Content: in.Prompt, Images: in.Images}))
+		}
+		data, err := json.Marshal(out)
+		return path, data, out.Stream, err
+
+	case kindEmbed, kindEmbeddings:
+		var in ollamaEmbedRequest
+		if err := json.Unmarshal(body, &in); err != nil {
+			return "", nil, false, err
+		}
+		out := openAIRequest{Model: in.Model, Input: in.Input}
+		if kind == kindEmbeddings {
+			out.Input = textContent(in.Prompt)
+		}
+		data, err := json.Marshal(out)
+		return "/v1/embeddings", data, false, err
+	}
+
+	return "", nil, false, fmt.Errorf("tipo richiesta non supportato: %s", kind)
+}
+
+// openAIContentText estrae testo e immagini (data URL base64) dal content OpenAI
+func openAIContentText(raw json.RawMessage) (string, []string) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil
+	}
+
+	var texts, images []string
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			texts = append(texts, p.Text)
+		case "image_url":
+			// Ollama accetta solo immagini inline in base64
+			if idx := strings.Index(p.ImageURL.URL, ";base64,"); strings.HasPrefix(p.ImageURL.URL, "data:") && idx >= 0 {
+				images = append(images, p.ImageURL.URL[idx+len(";base64,"):])
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), images
+}
+
+// optionsFromOpenAI estrae le opzioni di sampling da una richiesta OpenAI
+func optionsFromOpenAI(in *openAIRequest) *ollamaOptions {
+	opts := &ollamaOptions{
+		Temperature:      in.Temperature,
+		TopP:             in.TopP,
+		NumPredict:       in.MaxTokens,
+		Stop:             stringList(in.Stop),
+		Seed:             in.Seed,
+		PresencePenalty:  in.PresencePenalty,
+		FrequencyPenalty: in.FrequencyPenalty,
+	}
+	if in.MaxCompletionTokens != nil {
+		opts.NumPredict = in.MaxCompletionTokens
+	}
+	return opts
+}
+
+// formatFromOpenAI converte response_format nel campo format Ollama
+func formatFromOpenAI(rf *openAIResponseFormat) json.RawMessage {
+	if rf == nil {
+		return nil
+	}
+	switch rf.Type {
+	case "json_object":
+		return json.RawMessage(`"json"`)
+	case "json_schema":
+		if rf.JSONSchema != nil {
+			return rf.JSONSchema.Schema
+		}
+	}
+	return nil
+}
+
+// openAIToNative traduce una richiesta OpenAI nella richiesta nativa Ollama equivalente.
+// Ritorna il path nativo, il body, se la risposta è in streaming e se includere l'usage nello stream.
+func openAIToNative(kind string, body []byte) (string, []byte, bool, bool, error) {
+	var in openAIRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return "", nil, false, false, err
+	}
+	includeUsage := in.StreamOptions != nil && in.StreamOptions.IncludeUsage
+
+	switch kind {
+	case kindChat:
+		out := ollamaChatRequest{
+			Model:   in.Model,
+			Stream:  &in.Stream,
+			Format:  formatFromOpenAI(in.ResponseFormat),
+			Options: optionsFromOpenAI(&in),
+			Tools:   in.Tools,
+		}
+		for _, m := range in.Messages {
+			text, images := openAIContentText(m.Content)
+			msg := ollamaMessage{Role: m.Role, Content: text, Images: images}
+			for _, tc := range m.ToolCalls {
+				var call ollamaToolCall
+				call.Function.Name = tc.Function.Name
+				call.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(call.Function.Arguments) {
+					call.Function.Arguments = json.RawMessage("{}")
+				}
+				msg.ToolCalls = append(msg.ToolCalls, call)
+			}
+			out.Messages = append(out.Messages, msg)
+		}
+		data, err := json.Marshal(out)
+		return "/api/chat", data, in.Stream, includeUsage, err
+
+	case kindCompletion:
+		prompts := stringList(in.Prompt)
+		if len(prompts) > 1 {
+			return "", nil, false, false, fmt.Errorf("prompt multipli non supportati")
+		}
+		out := ollamaGenerateRequest{
+			Model:   in.Model,
+			Stream:  &in.Stream,
+			Raw:     true,
+			Options: optionsFromOpenAI(&in),
+		}
+		if len(prompts) == 1 {
+			out.Prompt = prompts[0]
+		}
+		data, err := json.Marshal(out)
+		return "/api/generate", data, in.Stream, includeUsage, err
+
+	case kindEmbed:
+		// L'input numerico (token id) non è supportato da Ollama
+		inputs := stringList(in.Input)
+		if inputs == nil {
+			return "", nil, false, false, fmt.Errorf("input embeddings non supportato")
+		}
+		input, _ := json.Marshal(inputs)
+		data, err := json.Marshal(ollamaEmbedRequest{Model: in.Model, Input: input})
+		return "/api/embed", data, false, false, err
+	}
+
+	return "", nil, false, false, fmt.Errorf("tipo richiesta non supportato: %s", kind)
+}
+
+// --- Conversione risposte OpenAI -> Ollama ---
+
+// openAIToolCallsToNative converte le tool call OpenAI (arguments stringa) in formato Ollama
+func openAIToolCallsToNative(calls []openAIToolCall) []ollamaToolCall {
+	var out []ollamaToolCall
+	for _, tc := range calls {
+		var call ollamaToolCall
+		call.Function.Name = tc.Function.Name
+		call.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+		if !json.Valid(call.Function.Arguments) {
+			call.Function.Arguments, _ = json.Marshal(tc.Function.Arguments)
+		}
+		out = append(out, call)
+	}
+	return out
+}
+
+// openAIResponseToNative converte una risposta OpenAI completa nella risposta Ollama
+func openAIResponseToNative(kind string, body []byte, model string, start time.Time) (interface{}, error) {
+	if kind == kindEmbed || kind == kindEmbeddings {
+		var in openAIEmbeddingResponse
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		out := ollamaEmbedResponse{Model: model, TotalDuration: time.Since(start).Nanoseconds()}
+		if in.Usage != nil {
+			out.PromptEvalCount = in.Usage.PromptTokens
+		}
+		for _, d := range in.Data {
+			out.Embeddings = append(out.Embeddings, d.Embedding)
+		}
+		if kind == kindEmbeddings {
+			if len(out.Embeddings) > 0 {
+				out.Embedding = out.Embeddings[0]
+			}
+			out.Embeddings = nil
+		}
+		return out, nil
+	}
+
+	var in openAIResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out := ollamaResponse{
+		Model:         model,
+		CreatedAt:     nowRFC3339(),
+		Done:          true,
+		DoneReason:    "stop",
+		TotalDuration: time.Since(start).Nanoseconds(),
+	}
+	if in.Usage != nil {
+		out.PromptEvalCount = in.Usage.PromptTokens
+		out.EvalCount = in.Usage.CompletionTokens
+	}
+
+	var text string
+	var toolCalls []ollamaToolCall
+	if len(in.Choices) > 0 {
+		choice := in.Choices[0]
+		if choice.FinishReason != nil {
+			out.DoneReason = finishToDoneReason(*choice.FinishReason)
+		}
+		if choice.Message != nil {
+			text, _ = openAIContentText(choice.Message.Content)
+			toolCalls = openAIToolCallsToNative(choice.Message.ToolCalls)
+		} else if choice.Text != nil {
+			text = *choice.Text
+		}
+	}
+
+	if kind == kindChat {
+		out.Message = &ollamaMessage{Role: "assistant", Content: text, ToolCalls: toolCalls}
+	} else {
+		out.Response = &text
+	}
+	return out, nil
+}
+
+// streamWriter scrive righe e forza il flush verso il client
+type streamWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func newStreamWriter(w http.ResponseWriter) *streamWriter {
+	flusher, _ := w.(http.Flusher)
+	return &streamWriter{w: w, flusher: flusher}
+}
+
+func (s *streamWriter) write(data []byte) error {
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+// newLineScanner crea uno scanner di righe con buffer ampio (chunk con tool call o embedding)
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	return scanner
+}
+
+// maxStreamToolCalls limita le tool call accumulate da uno stream tradotto
+const maxStreamToolCalls = 128
+
+// validToolCallIndexes verifica gli indici delle tool call di un chunk: un
+// indice negativo o oltre maxStreamToolCalls rende il chunk malformato.
+// accumulated è il numero di tool call già ricevute.
+func validToolCallIndexes(chunk openAIResponse, accumulated int) bool {
+	for _, choice := range chunk.Choices {
+		if choice.Delta == nil {
+			continue
+		}
+		for _, tc := range choice.Delta.ToolCalls {
+			idx := accumulated
+			if tc.Index != nil {
+				idx = *tc.Index
+			}
+			if idx < 0 || idx >= maxStreamToolCalls {
+				return false
+			}
+			if idx >= accumulated {
+				accumulated = idx + 1
+			}
+		}
+	}
+	return true
+}
+
+// streamOpenAIToNative converte uno stream SSE OpenAI in NDJSON Ollama
+func streamOpenAIToNative(w http.ResponseWriter, body io.Reader, kind, model string, start time.Time) error {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	out := newStreamWriter(w)
+
+	doneReason := "stop"
+	var usage *openAIUsage
+	// Le tool call arrivano frammentate: vengono accumulate e inviate nel messaggio finale
+	var toolCalls []openAIToolCall
+
+	scanner := newLineScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if !validToolCallIndexes(chunk, len(toolCalls)) {
+			continue
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				doneReason = finishToDoneReason(*choice.FinishReason)
+			}
+
+			var text string
+			if choice.Delta != nil {
+				text, _ = openAIContentText(choice.Delta.Content)
+				for _, tc := range choice.Delta.ToolCalls {
+					idx := len(toolCalls)
+					if tc.Index != nil {
+						idx = *tc.Index
+					}
+					for len(toolCalls) <= idx {
+						toolCalls = append(toolCalls, openAIToolCall{})
+					}
+					if tc.Function.Name != "" {
+						toolCalls[idx].Function.Name = tc.Function.Name
+					}
+					toolCalls[idx].Function.Arguments += tc.Function.Arguments
+				}
+			} else if choice.Text != nil {
+				text = *choice.Text
+			}
+			if text == "" {
+				continue
+			}
+
+			resp := ollamaResponse{Model: model, CreatedAt: nowRFC3339()}
+			if kind == kindChat {
+				resp.Message = &ollamaMessage{Role: "assistant", Content: text}
+			} else {
+				resp.Response = &text
+			}
+			if err := writeNDJSON(out, resp); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	final := ollamaResponse{
+		Model:         model,
+		CreatedAt:     nowRFC3339(),
+		Done:          true,
+		DoneReason:    doneReason,
+		TotalDuration: time.Since(start).Nanoseconds(),
+	}
+	if usage != nil {
+		final.PromptEvalCount = usage.PromptTokens
+		final.EvalCount = usage.CompletionTokens
+	}
+	empty := ""
+	if kind == kindChat {
+		final.Message = &ollamaMessage{Role: "assistant", ToolCalls: openAIToolCallsToNative(toolCalls)}
+	} else {
+		final.Response = &empty
+	}
+	return writeNDJSON(out, final)
+}
+
+// writeNDJSON scrive un oggetto come riga NDJSON
+func writeNDJSON(out *streamWriter, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return out.write(append(data, '\n'))
+}
+
+// --- Conversione risposte Ollama -> OpenAI ---
+
+// nativeToolCallsToOpenAI converte le tool call Ollama (arguments oggetto) in formato OpenAI
+func nativeToolCallsToOpenAI(calls []ollamaToolCall, withIndex bool) []openAIToolCall {
+	var out []openAIToolCall
+	for i, tc := range calls {
+		call := openAIToolCall{ID: newCompletionID("call"), Type: "function"}
+		if withIndex {
+			idx := i
+			call.Index = &idx
+		}
+		call.Function.Name = tc.Function.Name
+		call.Function.Arguments = string(tc.Function.Arguments)
+		out = append(out, call)
+	}
+	return out
+}
+
+// usageFromNative costruisce l'usage OpenAI dai contatori Ollama
+func usageFromNative(promptEval, eval int) *openAIUsage {
+	return &openAIUsage{
+		PromptTokens:     promptEval,
+		CompletionTokens: eval,
+		TotalTokens:      promptEval + eval,
+	}
+}
+
+// nativeResponseToOpenAI converte una risposta Ollama completa nella risposta OpenAI
+func nativeResponseToOpenAI(kind string, body []byte, model string) (interface{}, error) {
+	if kind == kindEmbed {
+		var in ollamaEmbedResponse
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		out := openAIEmbeddingResponse{
+			Object: "list",
+			Model:  model,
+			Data:   make([]openAIEmbedding, 0, len(in.Embeddings)),
+			Usage:  usageFromNative(in.PromptEvalCount, 0),
+		}
+		for i, e := range in.Embeddings {
+			out.Data = append(out.Data, openAIEmbedding{Object: "embedding", Index: i, Embedding: e})
+		}
+		return out, nil
+	}
+
+	var in ollamaResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out := openAIResponse{
+		Created: time.Now().Unix(),
+		Model:   model,
+		Usage:   usageFromNative(in.PromptEvalCount, in.EvalCount),
+	}
+
+	if kind == kindChat {
+		out.ID = newCompletionID("chatcmpl")
+		out.Object = "chat.completion"
+		msg := &openAIMessage{Role: "assistant"}
+		var toolCalls []ollamaToolCall
+		if in.Message != nil {
+			msg.Content = textContent(in.Message.Content)
+			toolCalls = in.Message.ToolCalls
+			msg.ToolCalls = nativeToolCallsToOpenAI(toolCalls, false)
+		}
+		reason := doneToFinishReason(in.DoneReason, len(toolCalls) > 0)
+		out.Choices = []openAIChoice{{Index: 0, Message: msg, FinishReason: &reason}}
+	} else {
+		out.ID = newCompletionID("cmpl")
+		out.Object = "text_completion"
+		text := ""
+		if in.Response != nil {
+			text = *in.Response
+		}
+		reason := doneToFinishReason(in.DoneReason, false)
+		out.Choices = []openAIChoice{{Index: 0, Text: &text, FinishReason: &reason}}
+	}
+	return out, nil
+}
+
+// streamNativeToOpenAI converte uno stream NDJSON Ollama in SSE OpenAI
+func streamNativeToOpenAI(w http.ResponseWriter, body io.Reader, kind, model string, includeUsage bool) error {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	out := newStreamWriter(w)
+
+	id := newCompletionID("chatcmpl")
+	object := "chat.completion.chunk"
+	if kind == kindCompletion {
+		id = newCompletionID("cmpl")
+		object = "text_completion"
+	}
+	created := time.Now().Unix()
+
+	newChunk := func() openAIResponse {
+		return openAIResponse{ID: id, Object: object, Created: created, Model: model}
+	}
+
+	// Ollama invia le tool call in chunk intermedi: gli indici proseguono tra
+	// un chunk e l'altro e il finish_reason finale tiene conto di tutte
+	first := true
+	toolCalls := 0
+	scanner := newLineScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var in ollamaResponse
+		if err := json.Unmarshal([]byte(line), &in); err != nil {
+			continue
+		}
+		if in.Error != "" {
+			return fmt.Errorf("errore backend: %s", in.Error)
+		}
+
+		chunk := newChunk()
+		choice := openAIChoice{Index: 0}
+
+		if kind == kindChat {
+			delta := &openAIMessage{}
+			if first {
+				delta.Role = "assistant"
+			}
+			if in.Message != nil {
+				if in.Message.Content != "" {
+					delta.Content = textContent(in.Message.Content)
+				}
+				delta.ToolCalls = nativeToolCallsToOpenAI(in.Message.ToolCalls, true)
+				for _, call := range delta.ToolCalls {
+					*call.Index += toolCalls
+				}
+				toolCalls += len(delta.ToolCalls)
+			}
+			choice.Delta = delta
+		} else {
+			text := ""
+			if in.Response != nil {
+				text = *in.Response
+			}
+			choice.Text = &text
+		}
+
+		if in.Done {
+			reason := doneToFinishReason(in.DoneReason, toolCalls > 0)
+			choice.FinishReason = &reason
+		}
+		chunk.Choices = []openAIChoice{choice}
+		if err := writeSSE(out, chunk); err != nil {
+			return err
+		}
+		first = false
+
+		if in.Done {
+			if includeUsage {
+				usageChunk := newChunk()
+				usageChunk.Choices = []openAIChoice{}
+				usageChunk.Usage = usageFromNative(in.PromptEvalCount, in.EvalCount)
+				if err := writeSSE(out, usageChunk); err != nil {
+					return err
+				}
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return out.write([]byte("data: [DONE]\n\n"))
+}
+
+// writeSSE scrive un evento Server-Sent Events con payload JSON
+func writeSSE(out *streamWriter, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return out.write([]byte("data: " + string(data) + "\n\n"))
+}
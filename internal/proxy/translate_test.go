@@ -0,0 +1,386 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/fzanti/aiconnect/internal/loadbalancer"
+)
+
+func TestNativeToOpenAI_Chat(t *testing.T) {
+	body := `{
+		"model": "llama3",
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": "describe", "images": ["iVBORw0KGgoAAAANSUhEUgAAAAE="]}
+		],
+		"format": "json",
+		"options": {"temperature": 0.2, "num_predict": 64, "stop": ["\n\n"]}
+	}`
+
+	path, payload, stream, err := nativeToOpenAI(kindChat, []byte(body))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/v1/chat/completions" {
+		t.Errorf("Expected /v1/chat/completions, got %s", path)
+	}
+	if !stream {
+		t.Error("Ollama chat should stream by default")
+	}
+
+	var req openAIRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		t.Fatalf("Invalid payload: %v", err)
+	}
+	if req.MaxTokens == nil || *req.MaxTokens != 64 {
+		t.Errorf("Expected max_tokens 64, got %v", req.MaxTokens)
+	}
+	if req.Temperature == nil || *req.Temperature != 0.2 {
+		t.Errorf("Expected temperature 0.2, got %v", req.Temperature)
+	}
+	if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_object" {
+		t.Errorf("Expected json_object response format, got %+v", req.ResponseFormat)
+	}
+	if req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+		t.Error("Expected include_usage when streaming")
+	}
+	if got := stringList(req.Stop); len(got) != 1 || got[0] != "\n\n" {
+		t.Errorf("Unexpected stop: %v", got)
+	}
+	if !strings.Contains(string(req.Messages[1].Content), "data:image/png;base64,") {
+		t.Errorf("Expected image converted to data URL, got %s", req.Messages[1].Content)
+	}
+}
+
+func TestFormatToOpenAI_JSONSchema(t *testing.T) {
+	rf := formatToOpenAI(json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}}}`))
+	data, _ := json.Marshal(rf)
+
+	// OpenAI rejects json_schema without a name
+	want := `{"type":"json_schema","json_schema":{"name":"response","schema":{"type":"object","properties":{"city":{"type":"string"}}}}}`
+	if string(data) != want {
+		t.Errorf("Unexpected response_format:\n got %s\nwant %s", data, want)
+	}
+}
+
+func TestNativeToOpenAI_Generate(t *testing.T) {
+	_, payload, stream, err := nativeToOpenAI(kindGenerate, []byte(`{"model":"m","prompt":"hi","system":"sys","stream":false}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stream {
+		t.Error("Expected stream false")
+	}
+	var req openAIRequest
+	json.Unmarshal(payload, &req)
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[1].Role != "user" {
+		t.Errorf("Unexpected messages: %+v", req.Messages)
+	}
+
+	path, payload, _, _ := nativeToOpenAI(kindGenerate, []byte(`{"model":"m","prompt":"raw text","raw":true}`))
+	if path != "/v1/completions" {
+		t.Errorf("Raw generate should use /v1/completions, got %s", path)
+	}
+	json.Unmarshal(payload, &req)
+	if string(req.Prompt) != `"raw text"` {
+		t.Errorf("Unexpected prompt: %s", req.Prompt)
+	}
+}
+
+func TestOpenAIToNative_Chat(t *testing.T) {
+	body := `{
+		"model": "llama3",
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "what is this"},
+			{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}
+		]}],
+		"max_completion_tokens": 32,
+		"stop": "END",
+		"response_format": {"type": "json_object"},
+		"stream": true,
+		"stream_options": {"include_usage": true}
+	}`
+
+	path, payload, stream, includeUsage, err := openAIToNative(kindChat, []byte(body))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/api/chat" || !stream || !includeUsage {
+		t.Errorf("Unexpected path/stream/usage: %s %v %v", path, stream, includeUsage)
+	}
+
+	var req ollamaChatRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		t.Fatalf("Invalid payload: %v", err)
+	}
+	if req.Messages[0].Content != "what is this" || len(req.Messages[0].Images) != 1 || req.Messages[0].Images[0] != "AAAA" {
+		t.Errorf("Unexpected message: %+v", req.Messages[0])
+	}
+	if req.Options.NumPredict == nil || *req.Options.NumPredict != 32 {
+		t.Errorf("Expected num_predict 32, got %v", req.Options.NumPredict)
+	}
+	if len(req.Options.Stop) != 1 || req.Options.Stop[0] != "END" {
+		t.Errorf("Unexpected stop: %v", req.Options.Stop)
+	}
+	if string(req.Format) != `"json"` {
+		t.Errorf("Expected format json, got %s", req.Format)
+	}
+}
+
+func TestOpenAIResponseToNative_Usage(t *testing.T) {
+	body := `{"id":"x","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"length"}],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}`
+
+	out, err := openAIResponseToNative(kindChat, []byte(body), "llama3", time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp := out.(ollamaResponse)
+	if resp.Message.Content != "hello" || !resp.Done || resp.DoneReason != "length" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if resp.PromptEvalCount != 7 || resp.EvalCount != 3 {
+		t.Errorf("Unexpected token counts: %d/%d", resp.PromptEvalCount, resp.EvalCount)
+	}
+}
+
+func TestNativeResponseToOpenAI_ToolCalls(t *testing.T) {
+	body := `{"model":"llama3","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Rome"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`
+
+	out, err := nativeResponseToOpenAI(kindChat, []byte(body), "llama3")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp := out.(openAIResponse)
+	choice := resp.Choices[0]
+	if *choice.FinishReason != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %s", *choice.FinishReason)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"city":"Rome"}` {
+		t.Errorf("Unexpected tool calls: %+v", choice.Message.ToolCalls)
+	}
+	if resp.Usage.TotalTokens != 7 {
+		t.Errorf("Expected 7 total tokens, got %d", resp.Usage.TotalTokens)
+	}
+}
+
+func TestHandler_NativeChatServedByVLLM(t *testing.T) {
+	vllm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+		case "/v1/models":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": []map[string]string{{"id": "qwen"}}})
+		case "/v1/chat/completions":
+			var req openAIRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if !req.Stream {
+				t.Error("Expected streaming upstream request")
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, tok := range []string{"Hel", "lo"} {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q},\"finish_reason\":null}]}\n\n", tok)
+			}
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":2,\"total_tokens\":6}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vllm.Close()
+
+	log := newTestLogger()
+	ollamaLB := loadbalancer.NewOllamaLoadBalancer(nil, 30, log)
+	vllmLB := loadbalancer.NewVLLMLoadBalancer([]string{vllm.URL}, 30, log)
+	vllmLB.Start()
+	h := NewHandler(&config.Config{}, log, ollamaLB, vllmLB, newTestMetrics())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"qwen","messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON content type, got %s", ct)
+	}
+
+	var lines []ollamaResponse
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var line ollamaResponse
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 NDJSON lines, got %d", len(lines))
+	}
+	if lines[0].Message.Content+lines[1].Message.Content != "Hello" {
+		t.Errorf("Unexpected content: %q %q", lines[0].Message.Content, lines[1].Message.Content)
+	}
+	last := lines[2]
+	if !last.Done || last.DoneReason != "stop" || last.PromptEvalCount != 4 || last.EvalCount != 2 {
+		t.Errorf("Unexpected final line: %+v", last)
+	}
+}
+
+func TestStreamOpenAIToNative_InvalidToolCallIndex(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"get_weather","arguments":"{\"city\":"}}]}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":-1,"function":{"arguments":"x"}}]}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1000000000,"function":{"arguments":"x"}}]}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Rome\"}"}}]}}]}`,
+		`data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+
+	rec := httptest.NewRecorder()
+	if err := streamOpenAIToNative(rec, strings.NewReader(stream), kindChat, "qwen", time.Now()); err != nil {
+		t.Fatalf("Unexpected stream error: %v", err)
+	}
+
+	// Chunks with out-of-range indexes are skipped as malformed
+	var last ollamaResponse
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		last = ollamaResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("Invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+	}
+	if !last.Done || last.Message == nil || len(last.Message.ToolCalls) != 1 {
+		t.Fatalf("Expected a single tool call in the final message, got %+v", last)
+	}
+	if args := string(last.Message.ToolCalls[0].Function.Arguments); args != `{"city":"Rome"}` {
+		t.Errorf("Unexpected tool call arguments: %s", args)
+	}
+}
+
+func TestStreamNativeToOpenAI_ToolCallsBeforeDone(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Rome"}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_time","arguments":{"tz":"CET"}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`,
+	}, "\n")
+
+	rec := httptest.NewRecorder()
+	if err := streamNativeToOpenAI(rec, strings.NewReader(stream), kindChat, "llama3", false); err != nil {
+		t.Fatalf("Unexpected stream error: %v", err)
+	}
+
+	// Calls from separate chunks keep distinct indexes and the final
+	// finish_reason reports them even if the done chunk carries none
+	indexes := map[int]string{}
+	var finish string
+	for _, ev := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		if ev == "data: [DONE]" {
+			continue
+		}
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(ev, "data: ")), &chunk); err != nil {
+			t.Fatalf("Invalid SSE chunk %q: %v", ev, err)
+		}
+		for _, c := range chunk.Choices {
+			for _, tc := range c.Delta.ToolCalls {
+				indexes[*tc.Index] = tc.Function.Name
+			}
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+		}
+	}
+	if indexes[0] != "get_weather" || indexes[1] != "get_time" || len(indexes) != 2 {
+		t.Errorf("Expected tool calls at indexes 0 and 1, got %v", indexes)
+	}
+	if finish != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %q", finish)
+	}
+}
+
+func TestHandler_OpenAIChatServedByOllamaNative(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			http.NotFound(w, r)
+		case "/api/tags":
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": []map[string]string{{"name": "llama3:latest"}}})
+		case "/api/chat":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"Hi"},"done":false}`)
+			fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"!"},"done":false}`)
+			fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":2}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ollama.Close()
+
+	log := newTestLogger()
+	ollamaLB := loadbalancer.NewOllamaLoadBalancer([]string{ollama.URL}, 30, log)
+	ollamaLB.Start()
+	vllmLB := loadbalancer.NewVLLMLoadBalancer(nil, 30, log)
+
+	cfg := &config.Config{}
+	cfg.Routing.OllamaNative = true
+	h := NewHandler(cfg, log, ollamaLB, vllmLB, newTestMetrics())
+
+	body := `{"model":"llama3","messages":[{"role":"user","content":"hi"}],"stream":true,"stream_options":{"include_usage":true}}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	data, _ := io.ReadAll(rec.Body)
+	events := strings.Split(strings.TrimSpace(string(data)), "\n\n")
+	if events[len(events)-1] != "data: [DONE]" {
+		t.Errorf("Expected stream to end with [DONE], got %q", events[len(events)-1])
+	}
+
+	var content string
+	var usage *openAIUsage
+	var finish string
+	for _, ev := range events[:len(events)-1] {
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(ev, "data: ")), &chunk); err != nil {
+			t.Fatalf("Invalid SSE chunk %q: %v", ev, err)
+		}
+		if chunk.Object != "chat.completion.chunk" {
+			t.Errorf("Unexpected object %s", chunk.Object)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			text, _ := openAIContentText(c.Delta.Content)
+			content += text
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+		}
+	}
+
+	if content != "Hi!" {
+		t.Errorf("Expected content Hi!, got %q", content)
+	}
+	if finish != "stop" {
+		t.Errorf("Expected finish_reason stop, got %q", finish)
+	}
+	if usage == nil || usage.PromptTokens != 3 || usage.CompletionTokens != 2 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unifiedInferencePaths sono gli endpoint di inferenza dell'API unificata /v1
+var unifiedInferencePaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+// modelEntry è un modello nel formato della lista OpenAI /v1/models
+type modelEntry struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// openAIModelCache mantiene l'elenco dei modelli esposti dall'endpoint OpenAI upstream
+type openAIModelCache struct {
+	endpoint string
+	apiKey   string
+	ttl      time.Duration
+	client   *http.Client
+	log      *logrus.Logger
+
+	mutex      sync.Mutex
+	models     []string
+	fetched    time.Time
+	refreshing chan struct{} // chiuso al termine dell'aggiornamento in corso
+}
+
+// newOpenAIModelCache crea la cache; ritorna nil se l'endpoint non è configurato
+func newOpenAIModelCache(endpoint, apiKey string, ttl time.Duration, log *logrus.Logger) *openAIModelCache {
+	if strings.TrimSpace(endpoint) == "" {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &openAIModelCache{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		apiKey:   apiKey,
+		ttl:      ttl,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		log:      log,
+	}
+}
+
+// list restituisce i modelli upstream. Se la cache è scaduta avvia un solo
+// aggiornamento in background e intanto restituisce l'elenco precedente; solo
+// il primo elenco viene atteso, non essendoci altro da servire.
+func (c *openAIModelCache) list() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	if time.Since(c.fetched) < c.ttl {
+		defer c.mutex.Unlock()
+		return c.models
+	}
+	done := c.refreshing
+	if done == nil {
+		done = make(chan struct{})
+		c.refreshing = done
+		go c.refresh(done)
+	}
+	initial := c.fetched.IsZero()
+	models := c.models
+	c.mutex.Unlock()
+
+	if !initial {
+		return models
+	}
+	<-done
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.models
+}
+
+// refresh aggiorna l'elenco fuori dal lock. In caso di errore viene
+// mantenuto l'ultimo elenco valido.
+func (c *openAIModelCache) refresh(done chan struct{}) {
+	models, err := c.fetch()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		c.log.WithError(err).Warn("Impossibile aggiornare elenco modelli OpenAI")
+	} else {
+		c.models = models
+	}
+	// Anche dopo un errore evita di ritentare ad ogni richiesta
+	c.fetched = time.Now()
+	c.refreshing = nil
+	close(done)
+}
+
+// fetch interroga GET {endpoint}/models
+func (c *openAIModelCache) fetch() ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(data.Data))
+	for _, m := range data.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+// has indica se l'upstream OpenAI espone il modello
+func (c *openAIModelCache) has(model string) bool {
+	for _, m := range c.list() {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// handleUnified gestisce l'API OpenAI-compatibile unificata /v1
+func (h *Handler) handleUnified(w http.ResponseWriter, r *http.Request, start time.Time) {
+	switch {
+	case r.URL.Path == "/v1/models" && r.Method == http.MethodGet:
+		h.handleUnifiedModels(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/models/") && r.Method == http.MethodGet:
+		h.handleUnifiedModel(w, r, strings.TrimPrefix(r.URL.Path, "/v1/models/"))
+	case unifiedInferencePaths[r.URL.Path]:
+		h.handleUnifiedInference(w, r, start)
+	default:
+		writeOpenAIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("unknown endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// handleUnifiedInference risolve il modello verso un pool e inoltra la richiesta
+func (h *Handler) handleUnifiedInference(w http.ResponseWriter, r *http.Request, start time.Time) {
+	model, err := peekModel(r, h.maxBodyBytes())
+	if err != nil {
+		h.log.WithError(err).Warn("Impossibile leggere body richiesta /v1")
+		status, msg := bodyError(err)
+		writeOpenAIError(w, status, "invalid_request", msg)
+		return
+	}
+	if model == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "missing_model", "the 'model' field is required")
+		return
+	}
+
+	backend, ok := h.resolveBackend(model)
+	if !ok {
+		h.log.WithField("model", model).Warn("Modello non disponibile su nessun backend")
+		writeModelNotFound(w, "configured", r.URL.Path, model)
+		return
+	}
+
+	h.log.WithFields(logrus.Fields{
+		"model":   model,
+		"backend": backend,
+		"path":    r.URL.Path,
+	}).Debug("Richiesta /v1 risolta")
+
+	// Ollama tramite API nativa (es. versioni senza layer OpenAI-compatibile)
+	if backend == "ollama" && h.current().cfg.Routing.OllamaNative {
+		if !h.authorize(w, r, backend, model) {
+			return
+		}
+		h.serveOpenAIViaNative(w, r, start, openAIKinds[r.URL.Path], model)
+		return
+	}
+
+	// Riusa gli handler dei singoli pool: Ollama e vLLM espongono gli stessi
+	// endpoint /v1 OpenAI-compatibili, OpenAI riceve il path invariato
+	r.URL.Path = "/" + backend + r.URL.Path
+	switch backend {
+	case "ollama":
+		h.handleOllama(w, r, start)
+	case "vllm":
+		h.handleVLLM(w, r, start)
+	case "openai":
+		h.handleOpenAI(w, r, start)
+	}
+}
+
+// resolveBackend determina il pool per un modello: prima le regole configurate,
+// poi gli inventari live di Ollama, vLLM e OpenAI upstream e infine i pool con
+// server di cui l'inventario non è ancora noto
+func (h *Handler) resolveBackend(model string) (string, bool) {
+	for _, route := range h.current().cfg.Routing.ModelBackends {
+		if matched, _ := path.Match(route.Pattern, model); matched {
+			return route.Backend, true
+		}
+	}
+
+	if h.ollamaLB.HasModel(model) {
+		return "ollama", true
+	}
+	if h.vllmLB.HasModel(model) {
+		return "vllm", true
+	}
+	if h.current().openaiModels.has(model) {
+		return "openai", true
+	}
+	if h.ollamaLB.HasUnknownInventory() {
+		return "ollama", true
+	}
+	if h.vllmLB.HasUnknownInventory() {
+		return "vllm", true
+	}
+	return "", false
+}
+
+// unifiedModels aggrega i modelli di tutti i pool che la richiesta può usare
+// (scope delle API key e policy per gruppo); a parità di id vince il primo pool
+func (h *Handler) unifiedModels(r *http.Request) []modelEntry {
+	pools := []struct {
+		backend string
+		models  []string
+	}{
+		{"ollama", h.ollamaLB.Models()},
+		{"vllm", h.vllmLB.Models()},
+		{"openai", h.current().openaiModels.list()},
+	}
+
+	seen := make(map[string]bool)
+	entries := make([]modelEntry, 0)
+	for _, pool := range pools {
+		for _, model := range pool.models {
+			if seen[model] {
+				continue
+			}
+			seen[model] = true
+			if !h.allows(r, pool.backend, model) {
+				continue
+			}
+			entries = append(entries, modelEntry{
+				ID:      model,
+				Object:  "model",
+				OwnedBy: pool.backend,
+			})
+		}
+	}
+	return entries
+}
+
+// handleUnifiedModels implementa GET /v1/models
+func (h *Handler) handleUnifiedModels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   h.unifiedModels(r),
+	})
+}
+
+// handleUnifiedModel implementa GET /v1/models/{id}
+func (h *Handler) handleUnifiedModel(w http.ResponseWriter, r *http.Request, id string) {
+	for _, entry := range h.unifiedModels(r) {
+		if entry.ID == id {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entry)
+			return
+		}
+	}
+	writeOpenAIError(w, http.StatusNotFound, "model_not_found", fmt.Sprintf("model %q not found", id))
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fzanti/aiconnect/internal/config"
+	"github.com/fzanti/aiconnect/internal/loadbalancer"
+)
+
+// newOpenAIBackend crea un finto upstream OpenAI con i modelli indicati
+func newOpenAIBackend(t *testing.T, models ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			data := make([]map[string]string, 0, len(models))
+			for _, m := range models {
+				data = append(data, map[string]string{"id": m, "object": "model"})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+		default:
+			w.Header().Set("X-Backend", "openai")
+			w.Header().Set("X-Path", r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newUnifiedTestHandler(t *testing.T, routes []config.ModelRoute) *Handler {
+	t.Helper()
+	ollama := newOllamaBackend(t, "ollama", "llama3:latest")
+	openai := newOpenAIBackend(t, "gpt-4o", "llama3")
+
+	log := newTestLogger()
+	ollamaLB := loadbalancer.NewOllamaLoadBalancer([]string{ollama.URL}, 30, log)
+	ollamaLB.Start()
+	vllmLB := loadbalancer.NewVLLMLoadBalancer(nil, 30, log)
+
+	cfg := &config.Config{}
+	cfg.Backends.OpenAIEndpoint = openai.URL + "/v1"
+	cfg.Backends.OpenAIAPIKey = "test-key"
+	cfg.Routing.ModelBackends = routes
+
+	return NewHandler(cfg, log, ollamaLB, vllmLB, newTestMetrics())
+}
+
+func TestHandler_UnifiedInferenceRouting(t *testing.T) {
+	h := newUnifiedTestHandler(t, nil)
+
+	tests := []struct {
+		model   string
+		backend string
+	}{
+		{"llama3", "ollama"}, // live Ollama inventory wins over OpenAI
+		{"gpt-4o", "openai"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+tt.model+`"}`))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tt.model, rec.Code)
+		}
+		if got := rec.Header().Get("X-Backend"); got != tt.backend {
+			t.Errorf("%s: expected backend %s, got %q", tt.model, tt.backend, got)
+		}
+	}
+
+	// OpenAI receives the /v1 path unchanged
+	req := httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"model":"gpt-4o"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Path"); got != "/v1/embeddings" {
+		t.Errorf("Expected upstream path /v1/embeddings, got %q", got)
+	}
+
+	// Unknown model: OpenAI-style 404
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"mixtral"}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404, got %d", rec.Code)
+	}
+	var errBody struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&errBody); err != nil || errBody.Error.Code != "model_not_found" {
+		t.Errorf("Unexpected error body (%v): %+v", err, errBody)
+	}
+
+	// Missing model
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without model, got %d", rec.Code)
+	}
+}
+
+func TestHandler_UnifiedRoutingRules(t *testing.T) {
+	h := newUnifiedTestHandler(t, []config.ModelRoute{
+		{Pattern: "llama*", Backend: "openai"},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"llama3"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Backend"); got != "openai" {
+		t.Errorf("Expected configured rule to route to openai, got %q", got)
+	}
+}
+
+func TestHandler_UnifiedModels(t *testing.T) {
+	h := newUnifiedTestHandler(t, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var body struct {
+		Object string       `json:"object"`
+		Data   []modelEntry `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	owners := make(map[string]string)
+	for _, m := range body.Data {
+		owners[m.ID] = m.OwnedBy
+	}
+	if owners["llama3:latest"] != "ollama" {
+		t.Errorf("Expected llama3:latest owned by ollama, got %v", owners)
+	}
+	if owners["gpt-4o"] != "openai" {
+		t.Errorf("Expected gpt-4o owned by openai, got %v", owners)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/models/gpt-4o", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for single model, got %d", rec.Code)
+	}
+}
+
+func TestOpenAIModelCache_RefreshDoesNotBlock(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		model := "gpt-4o"
+		if atomic.AddInt32(&calls, 1) > 1 {
+			<-release
+			model = "gpt-4.1"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": []map[string]string{{"id": model}}})
+	}))
+	t.Cleanup(upstream.Close)
+	t.Cleanup(func() { close(release) })
+
+	cache := newOpenAIModelCache(upstream.URL+"/v1", "test-key", time.Hour, newTestLogger())
+
+	// The first list is awaited
+	if got := cache.list(); len(got) != 1 || got[0] != "gpt-4o" {
+		t.Fatalf("Expected initial models, got %v", got)
+	}
+
+	// Once expired, a slow upstream does not delay callers: they get the
+	// previous list while a single refresh runs
+	cache.mutex.Lock()
+	cache.fetched = time.Now().Add(-2 * time.Hour)
+	cache.mutex.Unlock()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if got := cache.list(); len(got) != 1 || got[0] != "gpt-4o" {
+			t.Fatalf("Expected stale models during refresh, got %v", got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected list to return without waiting for the refresh, took %v", elapsed)
+	}
+
+	release <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for !cache.has("gpt-4.1") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected refreshed models")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected a single refresh request, got %d upstream calls", n-1)
+	}
+}
+
+func TestHandler_UnifiedRoutingUnknownInventory(t *testing.T) {
+	h := newUnifiedTestHandler(t, nil)
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("/v1/chat/completions", `{"model":"qwen2"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 with every inventory known, got %d", rec.Code)
+	}
+
+	// A server discovered before its first poll may serve the model
+	fresh := newOllamaBackend(t, "fresh", "qwen2")
+	h.ollamaLB.AddServer(fresh.URL)
+	for _, path := range []string{"/v1/chat/completions", "/api/chat"} {
+		rec := serve(path, `{"model":"qwen2"}`)
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Backend") != "fresh" {
+			t.Errorf("%s: expected request routed to the fresh server, got %d %q", path, rec.Code, rec.Header().Get("X-Backend"))
+		}
+	}
+
+	// Known inventories still win
+	if rec := serve("/v1/chat/completions", `{"model":"gpt-4o"}`); rec.Header().Get("X-Backend") != "openai" {
+		t.Errorf("Expected gpt-4o routed to openai, got %q", rec.Header().Get("X-Backend"))
+	}
+}
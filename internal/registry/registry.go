@@ -61,6 +61,9 @@ type Registry struct {
 	nodes     map[string]*Node // key is "host:port"
 	mutex     sync.RWMutex
 	callbacks []EventCallback
+	// cbMutex guards callbacks separately so that emit can be called while
+	// mutex is held by the caller
+	cbMutex sync.RWMutex
 }
 
 // NewRegistry creates a new node registry
@@ -83,22 +86,24 @@ func nodeKeyFromNode(n *Node) string {
 
 // OnEvent registers an event callback
 func (r *Registry) OnEvent(callback EventCallback) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+	r.cbMutex.Lock()
+	defer r.cbMutex.Unlock()
 	r.callbacks = append(r.callbacks, callback)
 }
 
 // emit emits an event to all registered callbacks
 func (r *Registry) emit(eventType EventType, node *Node) {
+	// Callbacks run asynchronously: pass a snapshot of the node
+	nodeCopy := *node
 	event := Event{
 		Type:      eventType,
-		Node:      node,
+		Node:      &nodeCopy,
 		Timestamp: time.Now(),
 	}
-	r.mutex.RLock()
+	r.cbMutex.RLock()
 	callbacks := make([]EventCallback, len(r.callbacks))
 	copy(callbacks, r.callbacks)
-	r.mutex.RUnlock()
+	r.cbMutex.RUnlock()
 	for _, cb := range callbacks {
 		go func(callback EventCallback) {
 			defer func() {
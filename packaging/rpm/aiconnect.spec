@@ -43,6 +43,9 @@ install -Dpm0600 config.example.yaml %{buildroot}/etc/aiconnect/config.yaml
 # autocert cache directory
 install -d -m0700 %{buildroot}/var/cache/aiconnect/autocert
 
+# state directory (API key)
+install -d -m0700 %{buildroot}/var/lib/aiconnect
+
 %pre
 getent group aiconnect >/dev/null || groupadd -r aiconnect
 getent passwd aiconnect >/dev/null || useradd -r -g aiconnect -s /sbin/nologin -d /var/cache/aiconnect aiconnect
@@ -72,6 +75,7 @@ systemctl daemon-reload >/dev/null 2>&1 || :
 /usr/lib/systemd/system/aiconnect.service
 %dir %attr(0700,aiconnect,aiconnect) /var/cache/aiconnect
 %dir %attr(0700,aiconnect,aiconnect) /var/cache/aiconnect/autocert
+%dir %attr(0700,aiconnect,aiconnect) /var/lib/aiconnect
 
 %changelog
 * Sat Dec 13 2025 AIConnect CI <ci@example.invalid> - %{version}-1